@@ -12,39 +12,50 @@ import (
 	"opsy/internal/parser"
 )
 
-// ListSOPs lists all available SOPs in the base directory
-func ListSOPs() {
-	cfg := config.GetConfig()
-	
-	// Check if base directory exists
-	if _, err := os.Stat(cfg.BaseDirectory); os.IsNotExist(err) {
-		fmt.Printf("Base directory does not exist: %s\n", cfg.BaseDirectory)
+// ListSOPs lists all available SOPs in every configured root
+// Each SOP is printed as <root>/<relative path> so roots can be told apart
+func ListSOPs(cfg *config.Config) {
+	for _, root := range cfg.Roots {
+		listRoot(root, cfg.Interpreters)
+	}
+}
+
+// listRoot lists the SOPs found in one root
+func listRoot(root config.Root, interpreters map[string]string) {
+	// Check if root directory exists
+	if _, err := os.Stat(root.Path); os.IsNotExist(err) {
+		fmt.Printf("Root %s does not exist: %s\n", root.Name, root.Path)
 		fmt.Println("Please create the directory or update your configuration.")
 		return
 	}
-	
+
 	// Walk through the directory to find all .md files
-	err := filepath.Walk(cfg.BaseDirectory, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(root.Path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		
+
 		// Only process markdown files
 		if !info.IsDir() && filepath.Ext(path) == ".md" {
 			// Try to parse the SOP to get its title
-			sop, parseErr := parser.ParseSOP(path)
+			name := path
+			if rel, relErr := filepath.Rel(root.Path, path); relErr == nil {
+				name = filepath.Join(root.Name, rel)
+			}
+
+			sop, parseErr := parser.ParseSOP(path, interpreters)
 			if parseErr != nil {
-				fmt.Printf("  [ERROR] %s: could not parse (%v)\n", path, parseErr)
+				fmt.Printf("  [ERROR] %s: could not parse (%v)\n", name, parseErr)
 				return nil // Continue with other files
 			}
-			
-			fmt.Printf("  %s: %s\n", path, sop.Title)
+
+			fmt.Printf("  %s: %s\n", name, sop.Title)
 		}
-		
+
 		return nil
 	})
-	
+
 	if err != nil {
-		log.Printf("Error walking through directory: %v", err)
+		log.Printf("Error walking through root %s: %v", root.Name, err)
 	}
-}
\ No newline at end of file
+}
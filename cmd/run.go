@@ -0,0 +1,490 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"opsy/internal/executor"
+	"opsy/internal/logger"
+	"opsy/internal/parser"
+	"opsy/internal/policy"
+	"opsy/internal/types"
+)
+
+// stepRunner executes a single step, either in a fresh shell or a shell session
+type stepRunner interface {
+	ExecuteStepStream(ctx context.Context, step types.Step, onOutput func(line string)) (*types.ExecutionResult, error)
+}
+
+// varFlags collects repeated --var KEY=VALUE flags
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	return ""
+}
+
+func (v varFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	v[key] = val
+	return nil
+}
+
+// RunSOP executes every step of an SOP without the TUI.
+// Usage: opsy run [--yes] [--skip-manual] [--rollback] [--var KEY=VALUE ...] <sop.md>
+// Step headers and output are printed to stdout, the run is written to the
+// log directory and an error is returned if any step does not succeed.
+// Manual steps are answered on stdin, --yes does not answer them; with
+// --skip-manual they are logged as skipped without asking.
+// When the run fails, the rollback blocks of the steps that succeeded can be
+// run in reverse order; --rollback does so without asking.
+func RunSOP(args []string, exec *executor.Executor, log *logger.Logger) error {
+	return runSOP(args, exec, log, os.Stdin, os.Stdout)
+}
+
+// executionLogger writes the log of a run
+type executionLogger interface {
+	LogExecution(execution types.SOPExecution) (string, error)
+}
+
+// runSOP runs an SOP like RunSOP, reading the operator's answers from in and
+// printing the run to out
+func runSOP(args []string, exec *executor.Executor, log executionLogger, in io.Reader, out io.Writer) error {
+	vars := varFlags{}
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.Var(vars, "var", "set an SOP variable (KEY=VALUE, repeatable)")
+	assumeYes := flags.Bool("yes", false, "run steps that require confirmation without prompting")
+	skipManual := flags.Bool("skip-manual", false, "log manual steps as skipped instead of asking for their outcome")
+	rollback := flags.Bool("rollback", false, "roll back the succeeded steps without prompting if the run fails")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: opsy run [--yes] [--skip-manual] [--rollback] [--var KEY=VALUE ...] <sop.md>")
+		flags.PrintDefaults()
+	}
+
+	// Allow flags both before and after the SOP path
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected exactly one SOP path")
+	}
+	path := positional[0]
+
+	sop, err := parser.ParseSOP(path, exec.Interpreters)
+	if err != nil {
+		return fmt.Errorf("could not parse SOP: %w", err)
+	}
+	if len(sop.Steps) == 0 {
+		return fmt.Errorf("no executable steps found in %s", path)
+	}
+
+	// Resolve declared variables from --var flags and defaults
+	values, err := parser.ResolveVariables(sop.Variables, vars)
+	if err != nil {
+		return fmt.Errorf("%w (set variables with --var KEY=VALUE)", err)
+	}
+	parser.ApplyVariables(sop, values)
+
+	// Refuse to start if a tool the SOP depends on is not installed
+	if missing := missingTools(sop.RequiredTools); len(missing) > 0 {
+		return fmt.Errorf("missing required tools: %s", strings.Join(missing, ", "))
+	}
+
+	title := sop.Title
+	if title == "" {
+		title = path
+	}
+	fmt.Fprintf(out, "Running SOP: %s\n", title)
+	if sop.Description != "" {
+		fmt.Fprintln(out, sop.Description)
+	}
+	fmt.Fprintln(out)
+
+	startedAt := time.Now()
+	runCtx := logger.CollectRunContext(sop.Path, sop.Checksum)
+	execution := types.SOPExecution{
+		ID:           logger.NewRunID(startedAt),
+		SOPName:      sop.Title,
+		SOPPath:      sop.Path,
+		ExecutedBy:   runCtx.User,
+		StartedAt:    startedAt,
+		Context:      runCtx,
+		Status:       "completed",
+		ExecutionLog: []types.ExecutionStep{},
+	}
+	if len(values) > 0 {
+		execution.Variables = values
+	}
+
+	// Steps share one shell when the SOP asks for a persistent session
+	var runner stepRunner = exec
+	if sop.ExecutionMode == types.ExecutionPersistent {
+		session, err := exec.NewShellSession(sop.SessionLanguage)
+		if err != nil {
+			return fmt.Errorf("could not start shell session: %w", err)
+		}
+		defer session.Close()
+		runner = session
+	}
+
+	// Ctrl+C cancels the running step (and its children) and ends the run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stdin := bufio.NewReader(in)
+
+	var failure error
+	for i, step := range sop.Steps {
+		execStep := types.ExecutionStep{
+			StepID:       step.ID,
+			OriginalStep: step,
+		}
+
+		// Remaining steps are still recorded so the log shows what was not run
+		if failure != nil {
+			execution.ExecutionLog = append(execution.ExecutionLog, execStep)
+			continue
+		}
+
+		printStepHeader(out, i+1, len(sop.Steps), step)
+
+		// Manual steps are answered by the operator instead of run
+		if step.Manual {
+			if *skipManual {
+				execStep.ExecutionResult = &types.ExecutionResult{
+					ExecutedAt: time.Now(),
+					Status:     "skipped",
+					Note:       "Skipped with --skip-manual",
+				}
+				execution.ExecutionLog = append(execution.ExecutionLog, execStep)
+				fmt.Fprint(out, "[skipped] manual step (--skip-manual)\n\n")
+				continue
+			}
+			result := answerManualStep(stdin, out)
+			if result == nil {
+				execStep.ExecutionResult = &types.ExecutionResult{
+					ExecutedAt: time.Now(),
+					Status:     "skipped",
+					Error:      "No answer from the operator",
+					ExitCode:   -1,
+				}
+				execution.ExecutionLog = append(execution.ExecutionLog, execStep)
+				execution.Status = "interrupted"
+				failure = fmt.Errorf("step %d is a manual step and needs an operator", step.ID)
+				fmt.Fprintln(out)
+				continue
+			}
+			execStep.ExecutionResult = result
+			execution.ExecutionLog = append(execution.ExecutionLog, execStep)
+			fmt.Fprintln(out)
+			if result.Status == "error" {
+				execution.Status = "failed"
+				failure = fmt.Errorf("step %d marked as failed", step.ID)
+			}
+			continue
+		}
+
+		// The command policy is checked before anything runs
+		decision := exec.CheckCommand(step.Command)
+		if decision.Action == policy.ActionDeny {
+			execStep.ExecutionResult = &types.ExecutionResult{
+				ExecutedAt: time.Now(),
+				Status:     "denied",
+				Error:      "Denied by " + decision.Reason(),
+				ExitCode:   -1,
+				PolicyRule: decision.RuleName(),
+			}
+			execution.ExecutionLog = append(execution.ExecutionLog, execStep)
+			execution.Status = "failed"
+			failure = fmt.Errorf("step %d denied by %s", step.ID, decision.Reason())
+			printStepResult(out, execStep.ExecutionResult)
+			continue
+		}
+		if decision.Action == policy.ActionConfirm {
+			fmt.Fprintf(out, "Confirmation required by %s\n", decision.Reason())
+		}
+
+		// Steps marked confirm=true (or matching a confirm rule) need an
+		// explicit yes before they run
+		needsConfirm := step.Confirm || decision.Action == policy.ActionConfirm
+		if needsConfirm && !*assumeYes && !confirmStep(stdin, out) {
+			execStep.ExecutionResult = &types.ExecutionResult{
+				ExecutedAt: time.Now(),
+				Status:     "skipped",
+				Error:      "Confirmation declined",
+				ExitCode:   -1,
+			}
+			execution.ExecutionLog = append(execution.ExecutionLog, execStep)
+			execution.Status = "interrupted"
+			failure = fmt.Errorf("step %d was not confirmed (use --yes to run unattended)", step.ID)
+			fmt.Fprintln(out)
+			continue
+		}
+
+		// Stream output as it is produced so long commands show progress
+		result, err := runner.ExecuteStepStream(ctx, step, func(line string) {
+			fmt.Fprintln(out, line)
+		})
+		if err != nil {
+			result = &types.ExecutionResult{
+				ExecutedAt: time.Now(),
+				Status:     "error",
+				Error:      err.Error(),
+				ExitCode:   1,
+			}
+		}
+		execStep.ExecutionResult = result
+		execution.ExecutionLog = append(execution.ExecutionLog, execStep)
+
+		printStepResult(out, result)
+
+		if result.Status == "cancelled" {
+			execution.Status = "interrupted"
+			failure = fmt.Errorf("run interrupted at step %d", step.ID)
+		} else if result.Status != "success" && step.ContinueOnError {
+			fmt.Fprint(out, "Continuing: step allows errors (continue_on_error)\n\n")
+		} else if result.Status != "success" {
+			execution.Status = "failed"
+			failure = fmt.Errorf("step %d failed with status %s", step.ID, result.Status)
+		}
+	}
+
+	// A failed run is undone with the rollback blocks of the steps that
+	// succeeded, most recent first. Interrupted runs are not offered one, as
+	// in the TUI
+	if steps := rollbackSteps(execution.ExecutionLog); execution.Status == "failed" && len(steps) > 0 {
+		if *rollback || confirmRollback(stdin, out, len(steps)) {
+			var ok bool
+			execution.Rollbacks, ok = rollBack(ctx, runner, exec, steps, stdin, out, *assumeYes)
+			if ok {
+				execution.Status = "rolled_back"
+				fmt.Fprintf(out, "Rolled back %d step(s)\n\n", len(steps))
+			} else {
+				fmt.Fprint(out, "Rollback stopped\n\n")
+			}
+		}
+	}
+
+	execution.EndedAt = time.Now()
+
+	// Summarise where the run diverged from the SOP
+	if deviations := logger.Deviations(execution.ExecutionLog); len(deviations) > 0 {
+		fmt.Fprintln(out, "Deviations from the SOP:")
+		for _, deviation := range deviations {
+			fmt.Fprintf(out, "  - %s\n", logger.DeviationLabel(deviation))
+		}
+		fmt.Fprintln(out)
+	}
+
+	logPath, err := log.LogExecution(execution)
+	if err != nil {
+		fmt.Fprintf(out, "Failed to write log: %v\n", err)
+	} else {
+		fmt.Fprintf(out, "Log written to: %s\n", logPath)
+	}
+
+	return failure
+}
+
+// missingTools returns the required tools that cannot be found in PATH
+func missingTools(tools []string) []string {
+	var missing []string
+	for _, tool := range tools {
+		if _, err := osexec.LookPath(tool); err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	return missing
+}
+
+// confirmStep asks the operator whether to run a step marked confirm=true.
+// Anything other than y/yes, including EOF on a closed stdin, declines.
+func confirmStep(in *bufio.Reader, out io.Writer) bool {
+	fmt.Fprint(out, "This step requires confirmation. Run it? [y/N] ")
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(out)
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// confirmRollback asks the operator whether to roll back a failed run.
+// Anything other than y/yes, including EOF on a closed stdin, declines.
+func confirmRollback(in *bufio.Reader, out io.Writer, steps int) bool {
+	fmt.Fprintf(out, "Roll back %d succeeded step(s) in reverse order? [y/N] ", steps)
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(out)
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// rollbackSteps returns the succeeded steps of a run that have a rollback
+// block, in reverse order
+func rollbackSteps(log []types.ExecutionStep) []types.Step {
+	var steps []types.Step
+	for i := len(log) - 1; i >= 0; i-- {
+		result := log[i].ExecutionResult
+		if result != nil && result.Status == "success" && log[i].OriginalStep.Rollback != nil {
+			steps = append(steps, log[i].OriginalStep)
+		}
+	}
+	return steps
+}
+
+// rollBack runs the rollback blocks of steps in order, applying the command
+// policy and confirmations like the steps themselves. It stops at the first
+// rollback block that does not succeed and reports whether all of them did
+func rollBack(ctx context.Context, runner stepRunner, exec *executor.Executor, steps []types.Step, in *bufio.Reader, out io.Writer, assumeYes bool) ([]types.ExecutionStep, bool) {
+	var log []types.ExecutionStep
+	for i, step := range steps {
+		rollback := *step.Rollback
+		fmt.Fprintf(out, "==> Rollback %d/%d of step %d: %s\n", i+1, len(steps), step.ID, step.Title)
+		fmt.Fprintf(out, "$ %s\n", rollback.Command)
+		execStep := types.ExecutionStep{
+			StepID:       step.ID,
+			OriginalStep: rollback,
+		}
+
+		decision := exec.CheckCommand(rollback.Command)
+		if decision.Action == policy.ActionDeny {
+			execStep.ExecutionResult = &types.ExecutionResult{
+				ExecutedAt: time.Now(),
+				Status:     "denied",
+				Error:      "Denied by " + decision.Reason(),
+				ExitCode:   -1,
+				PolicyRule: decision.RuleName(),
+			}
+			printStepResult(out, execStep.ExecutionResult)
+			return append(log, execStep), false
+		}
+		if decision.Action == policy.ActionConfirm {
+			fmt.Fprintf(out, "Confirmation required by %s\n", decision.Reason())
+		}
+		needsConfirm := rollback.Confirm || decision.Action == policy.ActionConfirm
+		if needsConfirm && !assumeYes && !confirmStep(in, out) {
+			execStep.ExecutionResult = &types.ExecutionResult{
+				ExecutedAt: time.Now(),
+				Status:     "skipped",
+				Error:      "Confirmation declined",
+				ExitCode:   -1,
+			}
+			fmt.Fprintln(out)
+			return append(log, execStep), false
+		}
+
+		result, err := runner.ExecuteStepStream(ctx, rollback, func(line string) {
+			fmt.Fprintln(out, line)
+		})
+		if err != nil {
+			result = &types.ExecutionResult{
+				ExecutedAt: time.Now(),
+				Status:     "error",
+				Error:      err.Error(),
+				ExitCode:   1,
+			}
+		}
+		execStep.ExecutionResult = result
+		log = append(log, execStep)
+		printStepResult(out, result)
+		if result.Status != "success" {
+			return log, false
+		}
+	}
+	return log, true
+}
+
+// answerManualStep asks the operator whether a manual step was done, failed
+// or skipped, then for an optional note. It returns nil on EOF, when there is
+// nobody to answer.
+func answerManualStep(in *bufio.Reader, out io.Writer) *types.ExecutionResult {
+	for {
+		fmt.Fprint(out, "Mark this step [d]one, [f]ailed or [s]kipped: ")
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Fprintln(out)
+			return nil
+		}
+
+		result := &types.ExecutionResult{ExecutedAt: time.Now()}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "d", "done":
+			result.Status = "success"
+		case "f", "failed":
+			result.Status = "error"
+			result.Error = "Marked as failed by the operator"
+			result.ExitCode = 1
+		case "s", "skip", "skipped":
+			result.Status = "skipped"
+		default:
+			continue
+		}
+
+		fmt.Fprint(out, "Note (optional): ")
+		note, _ := in.ReadString('\n')
+		result.Note = strings.TrimSpace(note)
+		return result
+	}
+}
+
+// printStepHeader prints the header and command for a step
+func printStepHeader(out io.Writer, num, total int, step types.Step) {
+	header := fmt.Sprintf("==> Step %d/%d: %s", num, total, step.Title)
+	if step.Description != "" {
+		header += " - " + step.Description
+	}
+	fmt.Fprintln(out, header)
+	if step.Source != "" {
+		fmt.Fprintf(out, "[included from %s:%d]\n", step.Source, step.LineNumber)
+	}
+	if step.Manual {
+		fmt.Fprintln(out, "[manual] Perform this step by hand")
+		return
+	}
+	fmt.Fprintf(out, "$ %s\n", step.Command)
+}
+
+// printStepResult prints the final status of an executed step
+func printStepResult(out io.Writer, result *types.ExecutionResult) {
+	if result.Status == "success" {
+		fmt.Fprintf(out, "[OK] exit code %d\n\n", result.ExitCode)
+		return
+	}
+	fmt.Fprintf(out, "[%s] exit code %d", result.Status, result.ExitCode)
+	if len(result.Mismatches) > 0 {
+		fmt.Fprintln(out, ": expectations not met")
+		for _, mismatch := range result.Mismatches {
+			fmt.Fprintf(out, "  - %s\n", mismatch)
+		}
+		fmt.Fprintln(out)
+		return
+	}
+	if result.Error != "" {
+		fmt.Fprintf(out, ": %s", result.Error)
+	}
+	fmt.Fprint(out, "\n\n")
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"opsy/internal/config"
+	"opsy/internal/executor"
+	"opsy/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingLogger keeps the last execution it was asked to log
+type recordingLogger struct {
+	saved types.SOPExecution
+}
+
+func (l *recordingLogger) LogExecution(execution types.SOPExecution) (string, error) {
+	l.saved = execution
+	return "/tmp/test.log", nil
+}
+
+// failingLogger refuses to write any log
+type failingLogger struct{}
+
+func (failingLogger) LogExecution(execution types.SOPExecution) (string, error) {
+	return "", errors.New("disk full")
+}
+
+func TestRunSOP(t *testing.T) {
+	tests := []struct {
+		name      string
+		sop       string
+		args      []string
+		input     string
+		err       string
+		status    string
+		results   []string // Result status of each step, "" when it did not run
+		rollbacks []string // Result status of each rollback block that ran
+		output    string
+	}{
+		{
+			name:    "policy deny",
+			sop:     "```bash\necho one\n```\n\n```bash\nmkfs.ext4 /dev/null\n```\n\n```bash\necho three\n```\n",
+			err:     "step 2 denied by",
+			status:  "failed",
+			results: []string{"success", "denied", ""},
+			output:  "[denied] exit code -1: Denied by",
+		},
+		{
+			name:    "declined confirmation",
+			sop:     "```bash\necho one\n```\n\n```bash {confirm}\necho two\n```\n",
+			input:   "n\n",
+			err:     "step 2 was not confirmed",
+			status:  "interrupted",
+			results: []string{"success", "skipped"},
+			output:  "Run it? [y/N]",
+		},
+		{
+			name:    "confirmation with --yes",
+			sop:     "```bash {confirm}\necho one\n```\n",
+			args:    []string{"--yes"},
+			status:  "completed",
+			results: []string{"success"},
+		},
+		{
+			name:    "EOF on a manual step",
+			sop:     "- [ ] Notify the team\n\n```bash\necho two\n```\n",
+			err:     "step 1 is a manual step and needs an operator",
+			status:  "interrupted",
+			results: []string{"skipped", ""},
+			output:  "Mark this step [d]one, [f]ailed or [s]kipped:",
+		},
+		{
+			name:    "manual step answered",
+			sop:     "- [ ] Notify the team\n\n```bash\necho two\n```\n",
+			input:   "d\nposted\n",
+			status:  "completed",
+			results: []string{"success", "success"},
+		},
+		{
+			name:    "manual step with --skip-manual",
+			sop:     "- [ ] Notify the team\n\n```bash\necho two\n```\n",
+			args:    []string{"--skip-manual"},
+			status:  "completed",
+			results: []string{"skipped", "success"},
+			output:  "[skipped] manual step (--skip-manual)",
+		},
+		{
+			name:    "prose headings are not manual steps",
+			sop:     "## Overview\n\nWhat this does.\n\n## Run\n\n```bash\necho one\n```\n\n## Notes\n\nNothing to do.\n",
+			status:  "completed",
+			results: []string{"success"},
+		},
+		{
+			name:    "continue_on_error",
+			sop:     "```bash {continue_on_error}\nfalse\n```\n\n```bash\necho two\n```\n",
+			status:  "completed",
+			results: []string{"error", "success"},
+			output:  "Continuing: step allows errors",
+		},
+		{
+			name:      "accepted rollback",
+			sop:       "```bash\necho up\n```\n\n```bash rollback\necho down\n```\n\n```bash\nfalse\n```\n",
+			input:     "y\n",
+			err:       "step 2 failed with status error",
+			status:    "rolled_back",
+			results:   []string{"success", "error"},
+			rollbacks: []string{"success"},
+			output:    "==> Rollback 1/1 of step 1",
+		},
+		{
+			name:    "declined rollback",
+			sop:     "```bash\necho up\n```\n\n```bash rollback\necho down\n```\n\n```bash\nfalse\n```\n",
+			input:   "n\n",
+			err:     "step 2 failed with status error",
+			status:  "failed",
+			results: []string{"success", "error"},
+			output:  "Roll back 1 succeeded step(s) in reverse order? [y/N]",
+		},
+		{
+			name:      "rollback with --rollback",
+			sop:       "```bash\necho up\n```\n\n```bash rollback\nfalse\n```\n\n```bash\nfalse\n```\n",
+			args:      []string{"--rollback"},
+			err:       "step 2 failed with status error",
+			status:    "failed",
+			results:   []string{"success", "error"},
+			rollbacks: []string{"error"},
+			output:    "Rollback stopped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sop.md")
+			if err := os.WriteFile(path, []byte("# Test\n\n"+tt.sop), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			log := &recordingLogger{}
+			var out bytes.Buffer
+			err := runSOP(append(tt.args, path), executor.NewExecutor(config.Default()), log, strings.NewReader(tt.input), &out)
+
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+			assert.Equal(t, tt.status, log.saved.Status)
+			assert.Contains(t, out.String(), tt.output)
+			assert.Contains(t, out.String(), "Log written to: /tmp/test.log")
+
+			var results []string
+			for _, step := range log.saved.ExecutionLog {
+				status := ""
+				if step.ExecutionResult != nil {
+					status = step.ExecutionResult.Status
+				}
+				results = append(results, status)
+			}
+			assert.Equal(t, tt.results, results)
+
+			var rollbacks []string
+			for _, step := range log.saved.Rollbacks {
+				rollbacks = append(rollbacks, step.ExecutionResult.Status)
+			}
+			assert.Equal(t, tt.rollbacks, rollbacks)
+		})
+	}
+}
+
+func TestRunSOPLogFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sop.md")
+	if err := os.WriteFile(path, []byte("# Test\n\n```bash\necho one\n```\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log error goes to the injected writer
+	var out bytes.Buffer
+	err := runSOP([]string{path}, executor.NewExecutor(config.Default()), failingLogger{}, strings.NewReader(""), &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Failed to write log: disk full")
+	assert.NotContains(t, out.String(), "Log written to:")
+}
@@ -1,38 +1,407 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Root is a named directory of SOPs shown at the top of the browser
+type Root struct {
+	Name     string `yaml:"name"`
+	Path     string `yaml:"path"`
+	ReadOnly bool   `yaml:"read_only"` // Shared SOPs that opsy must never write to
+}
+
 // Config holds the application configuration
 type Config struct {
-	BaseDirectory string // Base directory for SOP files (default: ~/.opsy/sops/)
-	LogDirectory  string // Directory for logs (default: ~/.opsy/logs/)
+	BaseDirectory string            // Base directory for SOP files (default: ~/.opsy/sops/)
+	Roots         []Root            // SOP roots; defaults to a single root at BaseDirectory
+	LogDirectory  string            // Directory for logs (default: ~/.opsy/logs/)
+	Shell         string            // Shell used to run steps (default: sh)
+	Timeout       time.Duration     // Default step timeout (default: 30s)
+	Editor        string            // Editor command; empty means $EDITOR
+	Theme         string            // TUI color theme: dark or light
+	Keybindings   map[string]string // Execute mode action -> comma separated keys
+	PolicyFile    string            // Command policy file; empty means ~/.opsy/policy.yaml if present
+	Interpreters  map[string]string // Code block language -> interpreter command
 }
 
-// DefaultBaseDirectory returns the default base directory for SOPs
-func DefaultBaseDirectory() string {
+// DefaultRootName names the root created from base_directory
+const DefaultRootName = "sops"
+
+// Themes lists the supported TUI themes
+var Themes = []string{"dark", "light"}
+
+// DefaultKeybindings returns the default keys for each execute mode action
+func DefaultKeybindings() map[string]string {
+	return map[string]string{
+		"run":      "enter,space",
+		"run_all":  "a",
+		"cancel":   "c",
+		"edit":     "e",
+		"skip":     "s",
+		"logs":     "l",
+		"back":     "q",
+		"save":     "w",
+		"open":     "o",
+		"rollback": "b",
+	}
+}
+
+// reservedKeys are handled by execute mode before any keybinding, so binding
+// them to an action would never trigger it
+var reservedKeys = map[string]string{
+	"up":     "step navigation",
+	"down":   "step navigation",
+	"k":      "step navigation",
+	"j":      "step navigation",
+	"ctrl+u": "scrolling",
+	"ctrl+d": "scrolling",
+	"y":      "confirmation prompts",
+	"ctrl+c": "quitting",
+}
+
+// DefaultInterpreters returns the built-in interpreter for each executable
+// code block language. A step's code is written to a temporary file whose path
+// is appended to the command. ```shell blocks and steps without a language
+// run in the configured shell unless "shell" is mapped here
+func DefaultInterpreters() map[string]string {
+	return map[string]string{
+		"bash":       "bash",
+		"sh":         "sh",
+		"zsh":        "zsh",
+		"python":     "python3",
+		"python3":    "python3",
+		"node":       "node",
+		"javascript": "node",
+		"js":         "node",
+		"sql":        "psql -X -v ON_ERROR_STOP=1 -f",
+	}
+}
+
+// fileConfig mirrors config.yaml; durations are written as strings like "5m"
+type fileConfig struct {
+	BaseDirectory string            `yaml:"base_directory"`
+	Roots         []Root            `yaml:"roots"`
+	LogDirectory  string            `yaml:"log_directory"`
+	Shell         string            `yaml:"shell"`
+	Timeout       string            `yaml:"timeout"`
+	Editor        string            `yaml:"editor"`
+	Theme         string            `yaml:"theme"`
+	Keybindings   map[string]string `yaml:"keybindings"`
+	PolicyFile    string            `yaml:"policy_file"`
+	Interpreters  map[string]string `yaml:"interpreters"`
+}
+
+// homeDir returns the user's home directory, falling back to the working directory
+func homeDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		home = "."
 	}
-	return filepath.Join(home, ".opsy", "sops")
+	return home
+}
+
+// DefaultBaseDirectory returns the default base directory for SOPs
+func DefaultBaseDirectory() string {
+	return filepath.Join(homeDir(), ".opsy", "sops")
 }
 
 // DefaultLogDirectory returns the default directory for logs
 func DefaultLogDirectory() string {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		home = "."
-	}
-	return filepath.Join(home, ".opsy", "logs")
+	return filepath.Join(homeDir(), ".opsy", "logs")
 }
 
-// GetConfig returns the application configuration
-func GetConfig() *Config {
+// DefaultConfigPath returns the default location of the config file
+func DefaultConfigPath() string {
+	return filepath.Join(homeDir(), ".opsy", "config.yaml")
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
 	return &Config{
 		BaseDirectory: DefaultBaseDirectory(),
+		Roots:         []Root{{Name: DefaultRootName, Path: DefaultBaseDirectory()}},
 		LogDirectory:  DefaultLogDirectory(),
+		Shell:         "sh",
+		Timeout:       30 * time.Second,
+		Theme:         "dark",
+		Keybindings:   DefaultKeybindings(),
+		Interpreters:  DefaultInterpreters(),
+	}
+}
+
+// Load builds the configuration from the defaults, the config file and
+// OPSY_* environment variables, in increasing order of precedence.
+// An empty path means $OPSY_CONFIG or ~/.opsy/config.yaml; only an explicitly
+// requested file has to exist.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	cfg.Roots = nil // Derived from base_directory unless roots are configured
+
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("OPSY_CONFIG")
+		explicit = path != ""
+	}
+	if !explicit {
+		path = DefaultConfigPath()
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := cfg.applyFile(data); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+		// No config file, keep the defaults
+	default:
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
+
+	cfg.LogDirectory = cleanPath(cfg.LogDirectory)
+	cfg.BaseDirectory = cleanPath(cfg.BaseDirectory)
+	cfg.PolicyFile = cleanPath(cfg.PolicyFile)
+	for i := range cfg.Roots {
+		cfg.Roots[i].Path = cleanPath(cfg.Roots[i].Path)
+	}
+
+	// Without explicit roots the base directory is the only root; with roots,
+	// the first one is where new SOPs go
+	if len(cfg.Roots) == 0 {
+		cfg.Roots = []Root{{Name: DefaultRootName, Path: cfg.BaseDirectory}}
+	} else {
+		cfg.BaseDirectory = cfg.Roots[0].Path
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// applyFile overlays the values set in a config file
+func (c *Config) applyFile(data []byte) error {
+	var file fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	if file.BaseDirectory != "" && len(file.Roots) > 0 {
+		return fmt.Errorf("set either base_directory or roots, not both")
+	}
+	if file.BaseDirectory != "" {
+		c.BaseDirectory = file.BaseDirectory
+	}
+	if len(file.Roots) > 0 {
+		c.Roots = file.Roots
 	}
-}
\ No newline at end of file
+	if file.LogDirectory != "" {
+		c.LogDirectory = file.LogDirectory
+	}
+	if file.Shell != "" {
+		c.Shell = file.Shell
+	}
+	if file.Timeout != "" {
+		timeout, err := time.ParseDuration(file.Timeout)
+		if err != nil {
+			return fmt.Errorf("timeout: %q is not a duration (e.g. 30s, 5m)", file.Timeout)
+		}
+		c.Timeout = timeout
+	}
+	if file.Editor != "" {
+		c.Editor = file.Editor
+	}
+	if file.Theme != "" {
+		c.Theme = file.Theme
+	}
+	for action, keys := range file.Keybindings {
+		c.Keybindings[action] = keys
+	}
+	if file.PolicyFile != "" {
+		c.PolicyFile = file.PolicyFile
+	}
+	for language, command := range file.Interpreters {
+		language = strings.ToLower(language)
+		if strings.TrimSpace(command) == "" {
+			delete(c.Interpreters, language) // Show these blocks as reference content
+			continue
+		}
+		c.Interpreters[language] = command
+	}
+	return nil
+}
+
+// applyEnv overlays OPSY_* environment variables
+func (c *Config) applyEnv() error {
+	if v := os.Getenv("OPSY_BASE_DIR"); v != "" {
+		c.BaseDirectory = v
+		c.Roots = nil // A single root at the given directory
+	}
+	if v := os.Getenv("OPSY_LOG_DIR"); v != "" {
+		c.LogDirectory = v
+	}
+	if v := os.Getenv("OPSY_SHELL"); v != "" {
+		c.Shell = v
+	}
+	if v := os.Getenv("OPSY_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("OPSY_TIMEOUT: %q is not a duration (e.g. 30s, 5m)", v)
+		}
+		c.Timeout = timeout
+	}
+	if v := os.Getenv("OPSY_EDITOR"); v != "" {
+		c.Editor = v
+	}
+	if v := os.Getenv("OPSY_THEME"); v != "" {
+		c.Theme = v
+	}
+	if v := os.Getenv("OPSY_POLICY"); v != "" {
+		c.PolicyFile = v
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	if c.BaseDirectory == "" {
+		return fmt.Errorf("base_directory must not be empty")
+	}
+	if c.LogDirectory == "" {
+		return fmt.Errorf("log_directory must not be empty")
+	}
+	names := make(map[string]bool)
+	for i, root := range c.Roots {
+		switch {
+		case root.Name == "":
+			return fmt.Errorf("roots[%d]: name must not be empty", i)
+		case strings.ContainsAny(root.Name, `/\`):
+			return fmt.Errorf("roots[%d]: name %q must not contain a path separator", i, root.Name)
+		case names[root.Name]:
+			return fmt.Errorf("roots[%d]: duplicate name %q", i, root.Name)
+		case root.Path == "" || root.Path == ".":
+			return fmt.Errorf("roots[%d]: path must not be empty", i)
+		}
+		names[root.Name] = true
+	}
+	if strings.TrimSpace(c.Shell) == "" {
+		return fmt.Errorf("shell must not be empty")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %s", c.Timeout)
+	}
+
+	validTheme := false
+	for _, theme := range Themes {
+		if c.Theme == theme {
+			validTheme = true
+		}
+	}
+	if !validTheme {
+		return fmt.Errorf("theme must be one of %s, got %q", strings.Join(Themes, ", "), c.Theme)
+	}
+
+	// Every action must be known and no key may trigger two actions or clash
+	// with a key execute mode reserves
+	defaults := DefaultKeybindings()
+	actions := make([]string, 0, len(c.Keybindings))
+	for action := range c.Keybindings {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	owner := make(map[string]string)
+	for _, action := range actions {
+		if _, ok := defaults[action]; !ok {
+			return fmt.Errorf("keybindings: unknown action %q", action)
+		}
+		keys := SplitKeys(c.Keybindings[action])
+		if len(keys) == 0 {
+			return fmt.Errorf("keybindings: %s has no keys", action)
+		}
+		for _, key := range keys {
+			if use, ok := reservedKeys[key]; ok {
+				return fmt.Errorf("keybindings: %q is reserved for %s and cannot be bound to %s", key, use, action)
+			}
+			if other, ok := owner[key]; ok {
+				return fmt.Errorf("keybindings: %q is bound to both %s and %s", key, other, action)
+			}
+			owner[key] = action
+		}
+	}
+	return nil
+}
+
+// RootFor returns the root containing path, preferring the most specific one
+func (c *Config) RootFor(path string) (Root, bool) {
+	var match Root
+	found := false
+	path = cleanPath(path)
+	for _, root := range c.Roots {
+		rel, err := filepath.Rel(root.Path, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if !found || len(root.Path) > len(match.Path) {
+			match, found = root, true
+		}
+	}
+	return match, found
+}
+
+// IsReadOnly reports whether path lies in a read-only root
+func (c *Config) IsReadOnly(path string) bool {
+	root, ok := c.RootFor(path)
+	return ok && root.ReadOnly
+}
+
+// SplitKeys splits a comma separated key list, e.g. "enter, space"
+func SplitKeys(keys string) []string {
+	var result []string
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// cleanPath expands ~ and makes path absolute so roots can be compared
+func cleanPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	path = expandHome(path)
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path == "~" {
+		return homeDir()
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDir(), path[2:])
+	}
+	return path
+}
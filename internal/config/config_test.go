@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("OPSY_CONFIG", "")
+
+	cfg, err := Load("")
+
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultBaseDirectory(), cfg.BaseDirectory)
+	assert.Equal(t, DefaultLogDirectory(), cfg.LogDirectory)
+	assert.Equal(t, "sh", cfg.Shell)
+	assert.Equal(t, 30*time.Second, cfg.Timeout)
+	assert.Equal(t, "dark", cfg.Theme)
+	assert.Equal(t, "enter,space", cfg.Keybindings["run"])
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := writeConfig(t, `
+base_directory: ~/runbooks
+log_directory: /var/log/opsy
+shell: bash
+timeout: 2m
+editor: nvim
+theme: light
+keybindings:
+  run: r
+policy_file: ~/policy.yaml
+interpreters:
+  Ruby: ruby
+  python: python3.12 -u
+  sql: ""
+`)
+	t.Setenv("OPSY_TIMEOUT", "5m")
+	t.Setenv("OPSY_EDITOR", "")
+
+	cfg, err := Load(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "runbooks"), cfg.BaseDirectory)
+	assert.Equal(t, "/var/log/opsy", cfg.LogDirectory)
+	assert.Equal(t, "bash", cfg.Shell)
+	assert.Equal(t, 5*time.Minute, cfg.Timeout) // Environment wins over the file
+	assert.Equal(t, "nvim", cfg.Editor)
+	assert.Equal(t, "light", cfg.Theme)
+	assert.Equal(t, "r", cfg.Keybindings["run"])
+	assert.Equal(t, "c", cfg.Keybindings["cancel"]) // Unset actions keep defaults
+	assert.Equal(t, filepath.Join(home, "policy.yaml"), cfg.PolicyFile)
+	assert.Equal(t, "ruby", cfg.Interpreters["ruby"])
+	assert.Equal(t, "python3.12 -u", cfg.Interpreters["python"])
+	assert.Equal(t, "bash", cfg.Interpreters["bash"]) // Unset languages keep defaults
+	assert.NotContains(t, cfg.Interpreters, "sql")    // An empty command disables a language
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	cases := map[string]string{
+		"timeout: soon":                "not a duration",
+		"timeout: -1s":                 "timeout must be positive",
+		"theme: solarized":             "theme must be one of dark, light",
+		"keybindings:\n  explode: x":   "unknown action \"explode\"",
+		"keybindings:\n  skip: c":      "\"c\" is bound to both cancel and skip",
+		"base_dir: /tmp":               "field base_dir not found",
+		"keybindings:\n  run: \" , \"": "run has no keys",
+		"keybindings:\n  skip: j":      "\"j\" is reserved for step navigation and cannot be bound to skip",
+		"keybindings:\n  run: enter,y": "\"y\" is reserved for confirmation prompts",
+	}
+	for content, expected := range cases {
+		_, err := Load(writeConfig(t, content))
+		assert.ErrorContains(t, err, expected, content)
+	}
+
+	// An explicitly requested file must exist
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read config")
+}
+
+func TestLoadRoots(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := writeConfig(t, `
+roots:
+  - name: personal
+    path: ~/.opsy/sops
+  - name: team
+    path: /srv/runbooks
+    read_only: true
+`)
+
+	cfg, err := Load(path)
+
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Roots, 2)
+	assert.Equal(t, filepath.Join(home, ".opsy", "sops"), cfg.BaseDirectory) // First root
+	assert.True(t, cfg.IsReadOnly("/srv/runbooks/db/restore.md"))
+	assert.False(t, cfg.IsReadOnly(filepath.Join(home, ".opsy", "sops", "a.md")))
+	assert.False(t, cfg.IsReadOnly("/srv/runbooks-old/a.md"))
+
+	root, ok := cfg.RootFor("/srv/runbooks/db/restore.md")
+	assert.True(t, ok)
+	assert.Equal(t, "team", root.Name)
+
+	// Without roots, the base directory is the only root
+	cfg, err = Load(writeConfig(t, "base_directory: /srv/sops"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Root{{Name: DefaultRootName, Path: "/srv/sops"}}, cfg.Roots)
+
+	_, err = Load(writeConfig(t, "roots:\n  - name: a\n    path: /x\n  - name: a\n    path: /y"))
+	assert.ErrorContains(t, err, "duplicate name \"a\"")
+	_, err = Load(writeConfig(t, "base_directory: /x\nroots:\n  - name: a\n    path: /y"))
+	assert.ErrorContains(t, err, "either base_directory or roots")
+}
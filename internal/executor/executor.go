@@ -6,57 +6,75 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
+	"opsy/internal/config"
+	"opsy/internal/policy"
 	"opsy/internal/types"
 )
 
+// waitDelay bounds how long to wait for output pipes after a command is killed
+const waitDelay = 2 * time.Second
+
 // Executor handles the execution of commands from SOP steps
 type Executor struct {
-	Timeout time.Duration // Maximum time to wait for command execution
+	Timeout      time.Duration     // Maximum time to wait for command execution
+	Shell        string            // Shell used to run commands, e.g. sh or bash
+	Interpreters map[string]string // Code block language -> interpreter command
+	Policy       *policy.Policy    // Command policy; nil means the built-in rules
 }
 
-// NewExecutor creates a new executor with default timeout
-func NewExecutor() *Executor {
+// NewExecutor creates a new executor using the configured shell, interpreters
+// and timeout
+func NewExecutor(cfg *config.Config) *Executor {
 	return &Executor{
-		Timeout: 30 * time.Second, // Default 30 second timeout
+		Timeout:      cfg.Timeout,
+		Shell:        cfg.Shell,
+		Interpreters: cfg.Interpreters,
 	}
 }
 
 // ExecuteStep executes a single SOP step and returns the execution result
 func (e *Executor) ExecuteStep(step types.Step) (*types.ExecutionResult, error) {
+	return e.ExecuteStepStream(context.Background(), step, nil)
+}
+
+// ExecuteStepStream executes a single SOP step with the interpreter for its
+// language (see interpreterFor), passing each line of combined
+// stdout/stderr to onOutput as soon as it is produced. The full output is
+// still returned in the execution result once the command exits, after the
+// step's expectations have been checked against it.
+// Cancelling ctx kills the whole process group and yields a "cancelled" result.
+func (e *Executor) ExecuteStepStream(ctx context.Context, step types.Step, onOutput func(line string)) (*types.ExecutionResult, error) {
 	if step.Command == "" {
 		return nil, fmt.Errorf("step has no command to execute")
 	}
 
 	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, e.timeoutFor(step))
 	defer cancel()
 
-	// Create the command
-	cmd := exec.CommandContext(ctx, "sh", "-c", step.Command)
-	
-	// Capture stdout and stderr
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
+	// Create the command in its own process group
+	cmd, cleanup, err := e.command(ctx, step)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// Capture stdout and stderr in the order they are written
+	output := &streamWriter{onLine: onOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
 
 	// Execute the command
-	err := cmd.Run()
+	err = cmd.Run()
 	endTime := time.Now()
-
-	// Build the output string from both stdout and stderr
-	output := stdoutBuf.String()
-	if stderrBuf.Len() > 0 {
-		if output != "" {
-			output += "\n"
-		}
-		output += stderrBuf.String()
-	}
+	output.Flush()
 
 	result := &types.ExecutionResult{
 		ExecutedAt: endTime,
-		Output:     strings.TrimSpace(output),
+		Output:     strings.TrimSpace(output.String()),
 	}
 
 	// Determine status based on execution result
@@ -64,36 +82,63 @@ func (e *Executor) ExecuteStep(step types.Step) (*types.ExecutionResult, error)
 		result.Status = "timeout"
 		result.Error = "Command timed out"
 		result.ExitCode = -1
+	} else if ctx.Err() == context.Canceled {
+		result.Status = "cancelled"
+		result.Error = "Command cancelled"
+		result.ExitCode = -1
 	} else if err != nil {
 		result.Status = "error"
 		result.Error = err.Error()
 		if exitError, ok := err.(*exec.ExitError); ok {
 			result.ExitCode = exitError.ExitCode()
+			result.Exited = exitError.Exited()
 		} else {
 			result.ExitCode = 1 // Generic error code
 		}
 	} else {
 		result.Status = "success"
 		result.ExitCode = 0
+		result.Exited = true
 	}
+	checkExpectations(step, result)
 
 	return result, nil
 }
 
+// shell returns the configured shell, defaulting to sh
+func (e *Executor) shell() string {
+	if e.Shell == "" {
+		return "sh"
+	}
+	return e.Shell
+}
+
+// timeoutFor returns the timeout for a step, preferring the step's own timeout
+func (e *Executor) timeoutFor(step types.Step) time.Duration {
+	if step.Timeout > 0 {
+		return step.Timeout
+	}
+	return e.Timeout
+}
+
 // ExecuteWithInput executes a command with provided input
 func (e *Executor) ExecuteWithInput(step types.Step, input string) (*types.ExecutionResult, error) {
 	if step.Command == "" {
 		return nil, fmt.Errorf("step has no command to execute")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeoutFor(step))
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "sh", "-c", step.Command)
-	
+	cmd, cleanup, err := e.command(ctx, step)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
 	var stdinBuf bytes.Buffer
 	var stdoutBuf, stderrBuf bytes.Buffer
-	
+
 	// Set up input if provided
 	if input != "" {
 		stdinBuf.WriteString(input)
@@ -102,7 +147,7 @@ func (e *Executor) ExecuteWithInput(step types.Step, input string) (*types.Execu
 	cmd.Stdout = &stdoutBuf
 	cmd.Stderr = &stderrBuf
 
-	err := cmd.Run()
+	err = cmd.Run()
 	endTime := time.Now()
 
 	output := stdoutBuf.String()
@@ -127,34 +172,82 @@ func (e *Executor) ExecuteWithInput(step types.Step, input string) (*types.Execu
 		result.Error = err.Error()
 		if exitError, ok := err.(*exec.ExitError); ok {
 			result.ExitCode = exitError.ExitCode()
+			result.Exited = exitError.Exited()
 		} else {
 			result.ExitCode = 1
 		}
 	} else {
 		result.Status = "success"
 		result.ExitCode = 0
+		result.Exited = true
 	}
+	checkExpectations(step, result)
 
 	return result, nil
 }
 
-// ValidateCommand checks if a command is safe to execute
-// This is a basic safety check - more sophisticated validation can be added
-func (e *Executor) ValidateCommand(command string) error {
-	// Check for potentially dangerous commands
-	blockedCommands := []string{
-		"rm -rf /",      // Delete entire filesystem
-		"rm -rf /*",     // Delete entire root
-		":(){:|:&};:",   // Fork bomb
-		"mkfs.",         // File system creation/formatting commands
-	}
-	
-	for _, blocked := range blockedCommands {
-		if strings.Contains(command, blocked) {
-			return fmt.Errorf("command contains potentially dangerous pattern: %s", blocked)
+// streamWriter collects command output and forwards complete lines to a callback
+type streamWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	pending []byte // Partial line not yet passed to onLine
+	onLine  func(line string)
+}
+
+// Write implements io.Writer
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	if w.onLine == nil {
+		return len(p), nil
+	}
+
+	w.pending = append(w.pending, p...)
+	for {
+		idx := bytes.IndexByte(w.pending, '\n')
+		if idx < 0 {
+			break
 		}
+		w.onLine(strings.TrimRight(string(w.pending[:idx]), "\r"))
+		w.pending = w.pending[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes any trailing partial line to the callback
+func (w *streamWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.onLine != nil && len(w.pending) > 0 {
+		w.onLine(strings.TrimRight(string(w.pending), "\r"))
+		w.pending = nil
+	}
+}
+
+// String returns everything written so far
+func (w *streamWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// CheckCommand evaluates a command against the command policy
+// Callers must do this before every execution and honour the decision.
+func (e *Executor) CheckCommand(command string) policy.Decision {
+	if e.Policy == nil {
+		return policy.Default().Evaluate(command)
+	}
+	return e.Policy.Evaluate(command)
+}
+
+// ValidateCommand returns an error if the command policy denies a command
+func (e *Executor) ValidateCommand(command string) error {
+	if decision := e.CheckCommand(command); decision.Action == policy.ActionDeny {
+		return fmt.Errorf("command denied by %s", decision.Reason())
 	}
-	
 	return nil
 }
 
@@ -164,6 +257,6 @@ func (e *Executor) ExecuteCommand(command string) (*types.ExecutionResult, error
 	step := types.Step{
 		Command: command,
 	}
-	
+
 	return e.ExecuteStep(step)
-}
\ No newline at end of file
+}
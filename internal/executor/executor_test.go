@@ -1,24 +1,27 @@
 package executor
 
 import (
+	"context"
+	"os/exec"
 	"testing"
 	"time"
 
+	"opsy/internal/config"
 	"opsy/internal/types"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestExecuteStep(t *testing.T) {
-	executor := NewExecutor()
-	
+	executor := NewExecutor(config.Default())
+
 	step := types.Step{
 		ID:      1,
 		Command: "echo 'hello world'",
 	}
-	
+
 	result, err := executor.ExecuteStep(step)
-	
+
 	assert.NoError(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, "success", result.Status)
@@ -28,14 +31,14 @@ func TestExecuteStep(t *testing.T) {
 
 func TestExecuteStepWithTimeout(t *testing.T) {
 	executor := &Executor{Timeout: 100 * time.Millisecond} // Very short timeout
-	
+
 	step := types.Step{
 		ID:      1,
 		Command: "sleep 1", // This will take longer than our timeout
 	}
-	
+
 	result, err := executor.ExecuteStep(step)
-	
+
 	assert.NoError(t, err) // No error from ExecuteStep, timeout is handled internally
 	assert.NotNil(t, result)
 	assert.Equal(t, "timeout", result.Status)
@@ -44,32 +47,166 @@ func TestExecuteStepWithTimeout(t *testing.T) {
 }
 
 func TestExecuteStepWithError(t *testing.T) {
-	executor := NewExecutor()
-	
+	executor := NewExecutor(config.Default())
+
 	step := types.Step{
 		ID:      1,
 		Command: "exit 1", // Command that exits with error
 	}
-	
+
 	result, err := executor.ExecuteStep(step)
-	
+
 	assert.NoError(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, "error", result.Status)
 	assert.Equal(t, 1, result.ExitCode)
 }
 
+func TestExecuteStepInterpreters(t *testing.T) {
+	cfg := config.Default()
+	cfg.Interpreters["text"] = "cat"
+	executor := NewExecutor(cfg)
+
+	// The step's code is passed to the interpreter as a script file
+	result, err := executor.ExecuteStep(types.Step{Command: "line 1\nline 2", CommandType: "text"})
+	assert.NoError(t, err)
+	assert.Equal(t, "success", result.Status)
+	assert.Equal(t, "line 1\nline 2", result.Output)
+
+	// Each shell language runs in its own shell, not the configured one
+	if _, err := exec.LookPath("bash"); err == nil {
+		result, err = executor.ExecuteStep(types.Step{Command: "[[ -n $BASH_VERSION ]] && echo bash", CommandType: "bash"})
+		assert.NoError(t, err)
+		assert.Equal(t, "bash", result.Output)
+	}
+	if _, err := exec.LookPath("python3"); err == nil {
+		result, err = executor.ExecuteStep(types.Step{Command: "import sys\nprint(sys.version_info[0])", CommandType: "python"})
+		assert.NoError(t, err)
+		assert.Equal(t, "3", result.Output)
+	}
+
+	_, err = executor.ExecuteStep(types.Step{Command: "PROGRAM-ID. HELLO.", CommandType: "cobol"})
+	assert.ErrorContains(t, err, "no interpreter configured for cobol code blocks")
+}
+
+func TestExecuteStepExpectations(t *testing.T) {
+	executor := NewExecutor(config.Default())
+	run := func(command string, expectations ...types.Expectation) *types.ExecutionResult {
+		result, err := executor.ExecuteStep(types.Step{Command: command, Expectations: expectations})
+		assert.NoError(t, err)
+		return result
+	}
+
+	// A zero exit code is not enough when the output is wrong
+	result := run("echo '-rw-r--r-- 1 root root 0 backup.sql.gz'",
+		types.Expectation{Kind: types.ExpectContains, Value: "backup.sql.gz"},
+		types.Expectation{Kind: types.ExpectMatches, Value: `root [1-9][0-9]* `},
+		types.Expectation{Kind: types.ExpectNotContains, Value: "root 0"})
+	assert.Equal(t, "error", result.Status)
+	assert.Equal(t, []string{
+		"output does not match /root [1-9][0-9]* /",
+		"output contains \"root 0\"",
+	}, result.Mismatches)
+	assert.Equal(t, "Expectations not met: output does not match /root [1-9][0-9]* /; output contains \"root 0\"", result.Error)
+
+	// An expected exit code replaces the default rule
+	result = run("exit 3", types.Expectation{Kind: types.ExpectExitCode, Value: "3"})
+	assert.Equal(t, "success", result.Status)
+	assert.Empty(t, result.Error)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.True(t, result.Exited)
+
+	result = run("exit 3", types.Expectation{Kind: types.ExpectContains, Value: "ok"})
+	assert.Equal(t, []string{"exit code 3, expected 0", "output does not contain \"ok\""}, result.Mismatches)
+
+	// JSON paths can test for a value or only for existence
+	status := `echo '{"status": "ok", "items": [{"size": 42}]}'`
+	result = run(status,
+		types.Expectation{Kind: types.ExpectJSON, Path: ".status", Value: `"ok"`},
+		types.Expectation{Kind: types.ExpectJSON, Path: ".items[0].size", Value: "42"},
+		types.Expectation{Kind: types.ExpectJSON, Path: ".items"})
+	assert.Equal(t, "success", result.Status)
+	assert.Empty(t, result.Mismatches)
+
+	result = run(status,
+		types.Expectation{Kind: types.ExpectJSON, Path: ".items[0].size", Value: "0"},
+		types.Expectation{Kind: types.ExpectJSON, Path: ".items[1]"},
+		types.Expectation{Kind: types.ExpectJSON, Path: ".owner"})
+	assert.Equal(t, []string{
+		".items[0].size is 42, expected 0",
+		".items[1] not found: array has 1 elements",
+		".owner not found",
+	}, result.Mismatches)
+
+	result = run("echo not json", types.Expectation{Kind: types.ExpectJSON, Path: "."})
+	assert.Len(t, result.Mismatches, 1)
+	assert.Contains(t, result.Mismatches[0], "output is not JSON")
+
+	// A command killed by a signal did not exit, so it is not checked
+	result = run("kill -9 $$", types.Expectation{Kind: types.ExpectExitCode, Value: "-1"})
+	assert.Equal(t, "error", result.Status)
+	assert.False(t, result.Exited)
+	assert.Empty(t, result.Mismatches)
+	assert.Equal(t, "signal: killed", result.Error)
+
+	// Timeouts are reported as such, not as unmet expectations
+	executor.Timeout = 100 * time.Millisecond
+	result = run("sleep 5", types.Expectation{Kind: types.ExpectExitCode, Value: "0"})
+	assert.Equal(t, "timeout", result.Status)
+	assert.Empty(t, result.Mismatches)
+}
+
 func TestValidateCommand(t *testing.T) {
-	executor := NewExecutor()
-	
+	executor := NewExecutor(config.Default())
+
 	// Valid command should pass
 	err := executor.ValidateCommand("echo hello")
 	assert.NoError(t, err)
-	
+
 	// Dangerous command should fail
 	err = executor.ValidateCommand("rm -rf /")
 	assert.Error(t, err)
-	
+
 	err = executor.ValidateCommand(":(){:|:&};:")
 	assert.Error(t, err)
-}
\ No newline at end of file
+}
+
+func TestExecuteStepStream(t *testing.T) {
+	executor := NewExecutor(config.Default())
+
+	step := types.Step{
+		ID:      1,
+		Command: "echo first; echo second >&2; printf third",
+	}
+
+	var lines []string
+	result, err := executor.ExecuteStepStream(context.Background(), step, func(line string) {
+		lines = append(lines, line)
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "success", result.Status)
+	assert.Equal(t, []string{"first", "second", "third"}, lines)
+	assert.Equal(t, "first\nsecond\nthird", result.Output)
+}
+
+func TestExecuteStepStreamCancel(t *testing.T) {
+	executor := NewExecutor(config.Default())
+
+	// The background sleep must be killed along with the shell
+	step := types.Step{
+		ID:      1,
+		Command: "sleep 10 & sleep 10; wait",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	result, err := executor.ExecuteStepStream(ctx, step, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", result.Status)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,137 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"opsy/internal/types"
+)
+
+// checkExpectations evaluates the expectations of a step against its result
+// once the command has finished. An exit_code expectation replaces the rule
+// that only exit code 0 succeeds. When any expectation is not met the step
+// fails with the list of mismatches; timeouts, cancellations and commands
+// that could not be started are left alone
+func checkExpectations(step types.Step, result *types.ExecutionResult) {
+	if len(step.Expectations) == 0 || !result.Exited {
+		return
+	}
+
+	var mismatches []string
+	exitCodeChecked := false
+	for _, expectation := range step.Expectations {
+		if expectation.Kind == types.ExpectExitCode {
+			exitCodeChecked = true
+		}
+		if mismatch := checkExpectation(expectation, result); mismatch != "" {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	if !exitCodeChecked && result.ExitCode != 0 {
+		mismatches = append([]string{fmt.Sprintf("exit code %d, expected 0", result.ExitCode)}, mismatches...)
+	}
+
+	result.Mismatches = mismatches
+	if len(mismatches) == 0 {
+		result.Status = "success"
+		result.Error = ""
+		return
+	}
+	result.Status = "error"
+	result.Error = "Expectations not met: " + strings.Join(mismatches, "; ")
+}
+
+// checkExpectation returns why result does not meet expectation, or "" if it does
+func checkExpectation(expectation types.Expectation, result *types.ExecutionResult) string {
+	switch expectation.Kind {
+	case types.ExpectExitCode:
+		want, err := strconv.Atoi(expectation.Value)
+		if err != nil {
+			return fmt.Sprintf("invalid exit code %q", expectation.Value)
+		}
+		if result.ExitCode != want {
+			return fmt.Sprintf("exit code %d, expected %d", result.ExitCode, want)
+		}
+	case types.ExpectContains:
+		if !strings.Contains(result.Output, expectation.Value) {
+			return fmt.Sprintf("output does not contain %q", expectation.Value)
+		}
+	case types.ExpectNotContains:
+		if strings.Contains(result.Output, expectation.Value) {
+			return fmt.Sprintf("output contains %q", expectation.Value)
+		}
+	case types.ExpectMatches:
+		pattern, err := regexp.Compile("(?m)" + expectation.Value)
+		if err != nil {
+			return fmt.Sprintf("invalid pattern %q", expectation.Value)
+		}
+		if !pattern.MatchString(result.Output) {
+			return fmt.Sprintf("output does not match /%s/", expectation.Value)
+		}
+	case types.ExpectJSON:
+		return checkJSON(expectation, result.Output)
+	default:
+		return fmt.Sprintf("unknown expectation %q", expectation.Kind)
+	}
+	return ""
+}
+
+// checkJSON checks that output is a JSON document with a value at the
+// expectation's path, equal to the expected value if there is one
+func checkJSON(expectation types.Expectation, output string) string {
+	var document any
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		return fmt.Sprintf("output is not JSON: %v", err)
+	}
+	actual, err := lookupJSON(document, expectation.Path)
+	if err != nil {
+		return err.Error()
+	}
+	if expectation.Value == "" {
+		return ""
+	}
+
+	var want any
+	if err := json.Unmarshal([]byte(expectation.Value), &want); err != nil {
+		return fmt.Sprintf("invalid expected value %s", expectation.Value)
+	}
+	if !reflect.DeepEqual(actual, want) {
+		got, _ := json.Marshal(actual)
+		return fmt.Sprintf("%s is %s, expected %s", expectation.Path, got, expectation.Value)
+	}
+	return ""
+}
+
+// jsonPathToken matches one key (.name) or index ([0]) of a JSON path
+var jsonPathToken = regexp.MustCompile(`\.([\w-]+)|\[(\d+)\]`)
+
+// lookupJSON returns the value at path in a decoded JSON document
+func lookupJSON(document any, path string) (any, error) {
+	value := document
+	for _, token := range jsonPathToken.FindAllStringSubmatch(path, -1) {
+		if key := token[1]; key != "" {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s not found: not an object", path)
+			}
+			if value, ok = object[key]; !ok {
+				return nil, fmt.Errorf("%s not found", path)
+			}
+			continue
+		}
+		index, _ := strconv.Atoi(token[2])
+		array, ok := value.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s not found: not an array", path)
+		}
+		if index >= len(array) {
+			return nil, fmt.Errorf("%s not found: array has %d elements", path, len(array))
+		}
+		value = array[index]
+	}
+	return value, nil
+}
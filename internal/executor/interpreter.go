@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"opsy/internal/types"
+)
+
+// sourcedBy reports whether a persistent session running the shell for
+// session (see parser's sessionLanguage) can source code blocks in language:
+// blocks without a language, ```shell blocks, blocks in the session's own
+// language and ```sh blocks, which bash and zsh run as well
+func sourcedBy(session, language string) bool {
+	switch language = strings.ToLower(language); language {
+	case "", "shell", session:
+		return true
+	case "sh":
+		return session == "bash" || session == "zsh"
+	}
+	return false
+}
+
+// interpreterFor returns the interpreter command line for a code block
+// language. Steps without a language and ```shell blocks use the configured
+// shell unless "shell" has an interpreter of its own
+func (e *Executor) interpreterFor(language string) ([]string, error) {
+	language = strings.ToLower(language)
+	command, ok := e.Interpreters[language]
+	if !ok && (language == "" || language == "shell") {
+		command = e.shell()
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no interpreter configured for %s code blocks", language)
+	}
+	return fields, nil
+}
+
+// command builds the process that runs a step with the interpreter for its
+// language, in its own process group. The code is written to a temporary
+// script whose path is appended to the interpreter command; cleanup removes it
+func (e *Executor) command(ctx context.Context, step types.Step) (cmd *exec.Cmd, cleanup func(), err error) {
+	interpreter, err := e.interpreterFor(step.CommandType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	script, err := os.CreateTemp("", "opsy-step-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create step script: %w", err)
+	}
+	cleanup = func() { os.Remove(script.Name()) }
+	_, err = script.WriteString(step.Command + "\n")
+	if closeErr := script.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write step script: %w", err)
+	}
+
+	args := append(interpreter[1:], script.Name())
+	cmd = exec.CommandContext(ctx, interpreter[0], args...)
+	configureProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	return cmd, cleanup, nil
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package executor
+
+import (
+	"os/exec"
+)
+
+// configureProcessGroup kills the command process on cancellation
+// Process groups are not available on Windows, so only the shell itself is killed
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+}
+
+// setProcessGroup is a no-op on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command process
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,343 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"opsy/internal/types"
+)
+
+// ShellSession runs every step of a run in one long-lived shell process, so
+// the working directory, environment and shell functions carry over between
+// steps. Each step is written to a script file and sourced by the shell,
+// followed by a marker line carrying the step's exit code.
+type ShellSession struct {
+	executor *Executor
+	language string   // Language of the blocks the shell sources, "" for the configured shell
+	shell    []string // Shell command line
+	dir      string   // Temporary directory holding step scripts
+	marker   string   // Unique marker printed after each step
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	output  *os.File      // Read end of the shell's output pipe
+	lines   chan string   // Merged stdout/stderr lines from the shell
+	done    chan struct{} // Closed to stop the goroutine filling lines
+	exited  chan struct{} // Closed when the shell process exits
+	started bool          // A shell has been started before (for restart notices)
+}
+
+// drainDelay bounds how long to collect leftover output after the shell exits
+const drainDelay = 100 * time.Millisecond
+
+// NewShellSession starts a persistent shell session running the interpreter
+// for language, the SOP's session language; "" runs the configured shell
+func (e *Executor) NewShellSession(language string) (*ShellSession, error) {
+	shell, err := e.interpreterFor(language)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "opsy-session-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to generate session marker: %w", err)
+	}
+
+	s := &ShellSession{
+		executor: e,
+		language: strings.ToLower(language),
+		shell:    shell,
+		dir:      dir,
+		marker:   "__OPSY_DONE_" + hex.EncodeToString(nonce),
+	}
+	if err := s.start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return s, nil
+}
+
+// start launches the shell process and the goroutines reading its output
+func (s *ShellSession) start() error {
+	cmd := exec.Command(s.shell[0], s.shell[1:]...)
+	setProcessGroup(cmd)
+
+	// stdout and stderr share one pipe so their lines stay in order
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create output pipe: %w", err)
+	}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		reader.Close()
+		writer.Close()
+		return fmt.Errorf("failed to open shell input: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		reader.Close()
+		writer.Close()
+		return fmt.Errorf("failed to start shell: %w", err)
+	}
+	writer.Close() // The shell holds its own copy
+
+	lines := make(chan string, 256)
+	done := make(chan struct{})
+	go func() {
+		defer close(lines)
+		buffered := bufio.NewReader(reader)
+		for {
+			line, err := buffered.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- strings.TrimRight(line, "\r\n"):
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	s.cmd = cmd
+	s.stdin = stdin
+	s.output = reader
+	s.lines = lines
+	s.done = done
+	s.exited = exited
+	s.started = true
+	return nil
+}
+
+// alive reports whether the shell process is still running
+func (s *ShellSession) alive() bool {
+	if s.cmd == nil {
+		return false
+	}
+	select {
+	case <-s.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// kill terminates the shell and everything it started
+func (s *ShellSession) kill() {
+	if s.cmd == nil {
+		return
+	}
+	killProcessGroup(s.cmd)
+	select {
+	case <-s.exited:
+	case <-time.After(waitDelay):
+	}
+	s.teardown()
+}
+
+// teardown stops reading the output of a shell that has exited or been killed.
+// A background child that outlives the shell keeps the pipe open, so the read
+// end is closed rather than waiting for EOF.
+func (s *ShellSession) teardown() {
+	if s.done != nil {
+		close(s.done)
+		s.output.Close()
+		s.done = nil
+		s.output = nil
+	}
+	s.cmd = nil
+}
+
+// drain passes on output the shell wrote before it exited, for at most drainDelay
+func (s *ShellSession) drain(lines <-chan string, emit func(line string)) {
+	deadline := time.After(drainDelay)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if !strings.Contains(line, s.marker) {
+				emit(line)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// ExecuteStepStream runs a step inside the session's shell, passing each line
+// of output to onOutput. A timeout or cancellation kills the shell; the next
+// step then starts a fresh shell and the previous state is lost.
+// Steps in languages the session's shell can't source run in a process of
+// their own.
+func (s *ShellSession) ExecuteStepStream(ctx context.Context, step types.Step, onOutput func(line string)) (*types.ExecutionResult, error) {
+	if step.Command == "" {
+		return nil, fmt.Errorf("step has no command to execute")
+	}
+	if !sourcedBy(s.language, step.CommandType) {
+		return s.executor.ExecuteStepStream(ctx, step, onOutput)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var output []string
+	emit := func(line string) {
+		output = append(output, line)
+		if onOutput != nil {
+			onOutput(line)
+		}
+	}
+
+	// Restart the shell if a previous step killed or exited it
+	if !s.alive() {
+		restarted := s.started
+		s.teardown()
+		if err := s.start(); err != nil {
+			return nil, err
+		}
+		if restarted {
+			emit("[opsy] shell session restarted; working directory and environment were reset")
+		}
+	}
+
+	script := filepath.Join(s.dir, "step.sh")
+	if err := os.WriteFile(script, []byte(step.Command+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write step script: %w", err)
+	}
+
+	// Check syntax first so a broken step can't take down the shell, then source
+	// the script with stdin detached so it can't consume the session's input.
+	// The marker is split in two so tracing (set -x) can't print it verbatim.
+	half := len(s.marker) / 2
+	input := fmt.Sprintf("{ %s -n %s && . %s </dev/null; }\nprintf '%%s%%s %%d\\n' %s %s \"$?\"\n",
+		shellQuote(s.shell[0]), shellQuote(script), shellQuote(script),
+		shellQuote(s.marker[:half]), shellQuote(s.marker[half:]))
+
+	ctx, cancel := context.WithTimeout(ctx, s.executor.timeoutFor(step))
+	defer cancel()
+
+	result := &types.ExecutionResult{}
+	finish := func() (*types.ExecutionResult, error) {
+		result.ExecutedAt = time.Now()
+		result.Output = strings.TrimSpace(strings.Join(output, "\n"))
+		checkExpectations(step, result)
+		return result, nil
+	}
+
+	if _, err := io.WriteString(s.stdin, input); err != nil {
+		s.kill()
+		result.Status = "error"
+		result.Error = fmt.Sprintf("shell session is not accepting input: %v", err)
+		result.ExitCode = 1
+		return finish()
+	}
+
+	lines := s.lines
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				// The shell closed its output; wait for it to exit
+				lines = nil
+				continue
+			}
+
+			idx := strings.Index(line, s.marker)
+			if idx < 0 {
+				emit(line)
+				continue
+			}
+
+			// Output without a trailing newline ends up in front of the marker
+			if idx > 0 {
+				emit(line[:idx])
+			}
+			code, _ := strconv.Atoi(strings.TrimSpace(line[idx+len(s.marker):]))
+			result.ExitCode = code
+			result.Exited = true
+			if code == 0 {
+				result.Status = "success"
+			} else {
+				result.Status = "error"
+				result.Error = fmt.Sprintf("exit status %d", code)
+			}
+			return finish()
+
+		case <-s.exited:
+			// The step exited the shell itself (e.g. with "exit"). A background
+			// child may still hold the output pipe, so don't wait for EOF.
+			s.drain(lines, emit)
+			result.Status = "error"
+			result.ExitCode = s.cmd.ProcessState.ExitCode()
+			result.Error = "shell session exited; state will be reset for the next step"
+			s.teardown()
+			return finish()
+
+		case <-ctx.Done():
+			s.kill()
+			result.ExitCode = -1
+			if ctx.Err() == context.DeadlineExceeded {
+				result.Status = "timeout"
+				result.Error = "Command timed out"
+			} else {
+				result.Status = "cancelled"
+				result.Error = "Command cancelled"
+			}
+			return finish()
+		}
+	}
+}
+
+// Close ends the shell session and removes its temporary files
+func (s *ShellSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.alive() {
+		// Closing stdin lets the shell exit on its own; kill it if it doesn't
+		s.stdin.Close()
+		select {
+		case <-s.exited:
+		case <-time.After(waitDelay):
+			s.kill()
+		}
+	}
+	s.teardown()
+	return os.RemoveAll(s.dir)
+}
+
+// shellQuote quotes a string for safe use as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
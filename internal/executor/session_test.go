@@ -0,0 +1,148 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"opsy/internal/config"
+	"opsy/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellSessionKeepsState(t *testing.T) {
+	session, err := NewExecutor(config.Default()).NewShellSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	steps := []types.Step{
+		{ID: 1, Command: "cd /tmp\nexport OPSY_TEST=carried\ngreet() { echo \"hi $1\"; }"},
+		{ID: 2, Command: "pwd; echo $OPSY_TEST; greet there"},
+	}
+
+	result, err := session.ExecuteStepStream(context.Background(), steps[0], nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "success", result.Status)
+
+	var lines []string
+	result, err = session.ExecuteStepStream(context.Background(), steps[1], func(line string) {
+		lines = append(lines, line)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "success", result.Status)
+	assert.Equal(t, []string{"/tmp", "carried", "hi there"}, lines)
+	assert.Equal(t, "/tmp\ncarried\nhi there", result.Output)
+
+	// Other languages run on their own, leaving the shell's state alone
+	session.executor.Interpreters = map[string]string{"text": "cat"}
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "$OPSY_TEST", CommandType: "text"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "$OPSY_TEST", result.Output)
+	result, err = session.ExecuteStepStream(context.Background(), steps[1], nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp\ncarried\nhi there", result.Output)
+}
+
+func TestShellSessionErrors(t *testing.T) {
+	session, err := NewExecutor(config.Default()).NewShellSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// A failing command reports its exit code and keeps the session
+	result, err := session.ExecuteStepStream(context.Background(), types.Step{Command: "export KEEP=1; printf partial; (exit 3)"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", result.Status)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.True(t, result.Exited)
+	assert.Equal(t, "partial", result.Output)
+
+	// A syntax error does not take down the shell
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "if then fi"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", result.Status)
+
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "echo $KEEP"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", result.Output)
+
+	// Expectations are checked against the session's exit code and output
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{
+		Command:      "echo $KEEP; (exit 3)",
+		Expectations: []types.Expectation{{Kind: types.ExpectExitCode, Value: "3"}, {Kind: types.ExpectContains, Value: "2"}},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", result.Status)
+	assert.Equal(t, []string{"output does not contain \"2\""}, result.Mismatches)
+
+	// A timeout kills the shell; the next step gets a fresh one
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "sleep 5", Timeout: 100 * time.Millisecond}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "timeout", result.Status)
+
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "echo ${KEEP:-unset}"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "success", result.Status)
+	assert.Contains(t, result.Output, "shell session restarted")
+	assert.Contains(t, result.Output, "unset")
+
+	// Exiting the shell is reported as an error
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "exit 4"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", result.Status)
+	assert.Equal(t, 4, result.ExitCode)
+	assert.False(t, result.Exited)
+
+	// A background child holding the output open doesn't hide the shell's exit
+	started := time.Now()
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "echo bye; sleep 5 & exit 3", Timeout: 4 * time.Second}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", result.Status)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Contains(t, result.Output, "bye")
+	assert.Less(t, time.Since(started), 2*time.Second)
+
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "echo again"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "success", result.Status)
+	assert.Contains(t, result.Output, "again")
+}
+
+func TestShellSessionLanguages(t *testing.T) {
+	cfg := config.Default()
+	cfg.Shell = "sh"
+
+	// A session for bash blocks runs bash, whatever the configured shell, and
+	// sources sh blocks as well
+	session, err := NewExecutor(cfg).NewShellSession("bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	result, err := session.ExecuteStepStream(context.Background(), types.Step{Command: "[[ -n $BASH_VERSION ]] && cd /tmp && export X=1", CommandType: "bash"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "success", result.Status)
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "pwd; echo $X", CommandType: "sh"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp\n1", result.Output)
+
+	// A session for the configured shell can't source bash, so bash blocks
+	// run in a bash process of their own
+	session, err = NewExecutor(cfg).NewShellSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	_, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "export X=1"}, nil)
+	assert.NoError(t, err)
+	result, err = session.ExecuteStepStream(context.Background(), types.Step{Command: "[[ -n $BASH_VERSION ]] && echo ${X:-unset}", CommandType: "bash"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "success", result.Status)
+	assert.Equal(t, "unset", result.Output)
+}
@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"opsy/internal/types"
+	"opsy/internal/version"
+)
+
+// gitTimeout bounds the git lookups so a slow repository can't delay a run
+const gitTimeout = 2 * time.Second
+
+// CollectRunContext gathers the operator identity, host and SOP revision
+// for a run of the SOP at sopPath, whose content the parser hashed to checksum
+// (types.SOP.Checksum). Anything that can't be determined is left empty.
+func CollectRunContext(sopPath, checksum string) types.RunContext {
+	runCtx := types.RunContext{
+		OpsyVersion: version.String(),
+	}
+
+	if current, err := user.Current(); err == nil {
+		runCtx.User = current.Username
+		// GECOS fields look like "Jane Doe,Room,Phone,..."; keep the name
+		runCtx.RealName, _, _ = strings.Cut(current.Name, ",")
+		if runCtx.RealName == runCtx.User {
+			runCtx.RealName = ""
+		}
+	}
+	if runCtx.User == "" {
+		runCtx.User = os.Getenv("USER")
+	}
+
+	runCtx.Hostname, _ = os.Hostname()
+	runCtx.WorkingDir, _ = os.Getwd()
+
+	if checksum != "" {
+		runCtx.SOPChecksum = "sha256:" + checksum
+	}
+
+	runCtx.GitCommit, runCtx.GitDirty = gitRevision(sopPath)
+	return runCtx
+}
+
+// gitRevision returns the HEAD commit of the repository containing path and
+// whether the file has uncommitted changes; empty if it is not in a repository
+func gitRevision(path string) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	dir := filepath.Dir(path)
+	commit, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+
+	status, err := exec.CommandContext(ctx, "git", "-C", dir, "status", "--porcelain", "--", filepath.Base(path)).Output()
+	dirty := err == nil && len(strings.TrimSpace(string(status))) > 0
+	return strings.TrimSpace(string(commit)), dirty
+}
+
+// OperatorLabel formats the operator for the log header, e.g. "jdoe (Jane Doe)"
+func OperatorLabel(executedBy string, runCtx types.RunContext) string {
+	if runCtx.RealName != "" {
+		return executedBy + " (" + runCtx.RealName + ")"
+	}
+	return executedBy
+}
+
+// RevisionLabel formats the SOP revision for the log header
+func RevisionLabel(runCtx types.RunContext) string {
+	if runCtx.GitCommit == "" {
+		return ""
+	}
+	if runCtx.GitDirty {
+		return runCtx.GitCommit + " (uncommitted changes)"
+	}
+	return runCtx.GitCommit
+}
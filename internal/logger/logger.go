@@ -1,9 +1,13 @@
 package logger
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -11,62 +15,106 @@ import (
 	"opsy/internal/types"
 )
 
-func init() {
-	// Ensure time package is considered used
-	_ = time.Now()
-}
-
 // Logger handles logging of SOP executions
 type Logger struct {
 	logDirectory string
 }
 
-// NewLogger creates a new logger with the default or configured log directory
-func NewLogger() (*Logger, error) {
-	cfg := config.GetConfig()
-	
+// NewLogger creates a new logger writing to the configured log directory
+func NewLogger(cfg *config.Config) (*Logger, error) {
+
 	// Create the log directory if it doesn't exist
 	if err := os.MkdirAll(cfg.LogDirectory, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
-	
+
 	return &Logger{
 		logDirectory: cfg.LogDirectory,
 	}, nil
 }
 
-// LogExecution saves the execution results of an SOP to a log file
-func (l *Logger) LogExecution(execution types.SOPExecution) (string, error) {
+// NewRunID generates the ID for a run started at the given time
+// The random suffix tells apart runs of the same SOP started in the same second
+func NewRunID(startedAt time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("run-%d-%s", startedAt.Unix(), hex.EncodeToString(suffix))
+}
+
+// LogPath returns the log file path for an execution
+// The path only depends on the SOP path, the run start time and the run ID's
+// suffix, so saving the same run repeatedly rewrites one file
+func (l *Logger) LogPath(execution types.SOPExecution) string {
 	// Create subdirectory for the SOP's folder first
 	sopDir := filepath.Base(filepath.Dir(execution.SOPPath))
 	if sopDir == "." || sopDir == "/" {
 		sopDir = "default" // Use default if SOP is in root
 	}
 	sopLogDir := filepath.Join(l.logDirectory, sopDir)
-	
-	if err := os.MkdirAll(sopLogDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create SOP log directory: %w", err)
-	}
-	
-	// Create filename with date and timestamp (e.g., deploy-nginx_09-10-2025_22-37-14.log.md)
+
+	// Create filename with date and timestamp (e.g., deploy-nginx_09-10-2025_22-37-14-3f9a1c.log.md)
 	sopName := strings.TrimSuffix(filepath.Base(execution.SOPPath), ".md")
 	dateStr := execution.StartedAt.Format("02-01-2006") // DD-MM-YYYY format
 	timestamp := execution.StartedAt.Format("15-04-05") // HH-MM-SS format
+	// Older run IDs have no suffix and keep their original file name
+	if id, ok := strings.CutPrefix(execution.ID, "run-"); ok {
+		if _, suffix, ok := strings.Cut(id, "-"); ok {
+			timestamp += "-" + suffix
+		}
+	}
 	filename := fmt.Sprintf("%s_%s_%s.log.md", sopName, dateStr, timestamp)
-	logPath := filepath.Join(sopLogDir, filename)
-	
+	return filepath.Join(sopLogDir, filename)
+}
+
+// LogExecution saves the execution results of an SOP to a log file
+// Calling it again for the same run replaces the previous contents
+func (l *Logger) LogExecution(execution types.SOPExecution) (string, error) {
+	logPath := l.LogPath(execution)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create SOP log directory: %w", err)
+	}
+
 	// Convert execution to log file format
 	logFile := l.executionToLogFile(execution)
-	
+
 	// Write the log file
 	content := l.formatLogContent(logFile)
 	if err := os.WriteFile(logPath, []byte(content), 0644); err != nil {
 		return "", fmt.Errorf("failed to write log file: %w", err)
 	}
-	
+
+	// Write the machine-readable sidecar next to it
+	data, err := json.MarshalIndent(logFile, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode log file: %w", err)
+	}
+	if err := os.WriteFile(JSONPath(logPath), append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write JSON log file: %w", err)
+	}
+
 	return logPath, nil
 }
 
+// JSONPath returns the path of the JSON sidecar of a markdown log
+// e.g. deploy-nginx_09-10-2025_22-37-14.log.md -> deploy-nginx_09-10-2025_22-37-14.log.json
+func JSONPath(logPath string) string {
+	return strings.TrimSuffix(logPath, ".md") + ".json"
+}
+
+// ReadLogFile reads the JSON sidecar of a markdown log
+// Logs written before sidecars existed return an error wrapping os.ErrNotExist
+func ReadLogFile(logPath string) (*types.LogFile, error) {
+	data, err := os.ReadFile(JSONPath(logPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON log file: %w", err)
+	}
+	var logFile types.LogFile
+	if err := json.Unmarshal(data, &logFile); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON log file: %w", err)
+	}
+	return &logFile, nil
+}
+
 // executionToLogFile converts an SOP execution to the log file format
 func (l *Logger) executionToLogFile(execution types.SOPExecution) types.LogFile {
 	logFile := types.LogFile{
@@ -77,94 +125,269 @@ func (l *Logger) executionToLogFile(execution types.SOPExecution) types.LogFile
 		StartedAt:   execution.StartedAt,
 		EndedAt:     execution.EndedAt,
 		Status:      execution.Status,
+		Variables:   execution.Variables,
+		Context:     execution.Context,
 		Steps:       []types.LogStep{},
 	}
-	
+
 	// Convert execution steps to log steps
 	for _, execStep := range execution.ExecutionLog {
-		logStep := types.LogStep{
-			StepID:       execStep.StepID,
-			Command:      execStep.OriginalStep.Command,
-			OriginalStep: execStep.OriginalStep,
-		}
-		
-		if execStep.ExecutionResult != nil {
-			logStep.ExecutedAt = execStep.ExecutionResult.ExecutedAt
-			logStep.ResultStatus = execStep.ExecutionResult.Status
-			logStep.Output = execStep.ExecutionResult.Output
-			logStep.ExecutionResult = execStep.ExecutionResult
-		}
-		
-		logFile.Steps = append(logFile.Steps, logStep)
+		logFile.Steps = append(logFile.Steps, toLogStep(execStep))
 	}
-	
+	for _, execStep := range execution.Rollbacks {
+		logFile.Rollbacks = append(logFile.Rollbacks, toLogStep(execStep))
+	}
+	logFile.Deviations = Deviations(execution.ExecutionLog)
+
 	return logFile
 }
 
+// toLogStep converts an executed step to the log file format
+func toLogStep(execStep types.ExecutionStep) types.LogStep {
+	logStep := types.LogStep{
+		StepID:       execStep.StepID,
+		Command:      execStep.OriginalStep.Command,
+		OriginalStep: execStep.OriginalStep,
+	}
+
+	if execStep.ExecutionResult != nil {
+		logStep.ExecutedAt = execStep.ExecutionResult.ExecutedAt
+		logStep.ResultStatus = execStep.ExecutionResult.Status
+		logStep.Output = execStep.ExecutionResult.Output
+		logStep.ExecutionResult = execStep.ExecutionResult
+	}
+	if isModified(execStep.OriginalStep) {
+		logStep.OriginalCommand = execStep.OriginalStep.OriginalCommand
+		logStep.Modified = true
+	}
+	return logStep
+}
+
 // formatLogContent formats the log file content according to the PRD specification
 func (l *Logger) formatLogContent(logFile types.LogFile) string {
 	var content strings.Builder
-	
+
 	// Write the header
 	content.WriteString(fmt.Sprintf("# %s\n\n", logFile.Title))
 	content.WriteString("> **SOP Run ID:** " + logFile.SOPRunID + "  \n")
 	content.WriteString("> **Original SOP:** " + logFile.OriginalSOP + "  \n")
-	content.WriteString("> **Executed by:** " + logFile.ExecutedBy + "  \n")
+	content.WriteString("> **Executed by:** " + OperatorLabel(logFile.ExecutedBy, logFile.Context) + "  \n")
+	if logFile.Context.Hostname != "" {
+		content.WriteString("> **Host:** " + logFile.Context.Hostname + "  \n")
+	}
+	if logFile.Context.WorkingDir != "" {
+		content.WriteString("> **Working directory:** " + logFile.Context.WorkingDir + "  \n")
+	}
+	if logFile.Context.OpsyVersion != "" {
+		content.WriteString("> **opsy version:** " + logFile.Context.OpsyVersion + "  \n")
+	}
+	if logFile.Context.SOPChecksum != "" {
+		content.WriteString("> **SOP checksum:** " + logFile.Context.SOPChecksum + "  \n")
+	}
+	if revision := RevisionLabel(logFile.Context); revision != "" {
+		content.WriteString("> **SOP revision:** " + revision + "  \n")
+	}
 	content.WriteString("> **Started at:** " + logFile.StartedAt.Format("2006-01-02 15:04:05") + "  \n")
-	content.WriteString("> **Ended at:** " + logFile.EndedAt.Format("2006-01-02 15:04:05") + "  \n")
-	
-	// Convert status to emoji
-	statusEmoji := "✅ Completed Successfully"
-	if logFile.Status == "failed" {
-		statusEmoji = "❌ Failed"
-	} else if logFile.Status == "interrupted" {
-		statusEmoji = "⚠️ Interrupted"
-	}
-	content.WriteString("> **Status:** " + statusEmoji + "\n\n")
-	
+	if !logFile.EndedAt.IsZero() { // Runs still in progress have no end time yet
+		content.WriteString("> **Ended at:** " + logFile.EndedAt.Format("2006-01-02 15:04:05") + "  \n")
+	}
+	if len(logFile.Variables) > 0 {
+		content.WriteString("> **Variables:** " + FormatVariables(logFile.Variables) + "  \n")
+	}
+
+	content.WriteString("> **Status:** " + RunStatusLabel(logFile.Status) + "\n\n")
+
 	// Write each step
 	for _, step := range logFile.Steps {
 		content.WriteString(fmt.Sprintf("## Step %d: %s\n", step.StepID, step.OriginalStep.Title))
-		
-		// Write the command
-		content.WriteString("```bash\n")
+		writeLogStep(&content, step)
+	}
+
+	// Rollback blocks run after a failure, in the order they ran
+	for _, step := range logFile.Rollbacks {
+		content.WriteString(fmt.Sprintf("## Rollback of Step %d: %s\n", step.StepID, step.OriginalStep.Title))
+		writeLogStep(&content, step)
+	}
+
+	// Summarise where the operator diverged from the procedure
+	if len(logFile.Deviations) > 0 {
+		content.WriteString("## Deviations\n\n")
+		for _, deviation := range logFile.Deviations {
+			content.WriteString("- " + DeviationLabel(deviation) + "\n")
+		}
+		content.WriteString("\n")
+	}
+
+	return content.String()
+}
+
+// writeLogStep writes the command and result of a step below its header
+func writeLogStep(content *strings.Builder, step types.LogStep) {
+	// Write the command, fenced in the language it ran as; manual steps
+	// have no command
+	language := step.OriginalStep.CommandType
+	if language == "" {
+		language = "bash"
+	}
+	if step.OriginalStep.Manual {
+		content.WriteString("> **Manual step:** ✋ Performed by the operator  \n")
+	} else {
+		content.WriteString("```" + language + "\n")
 		content.WriteString(step.Command + "\n")
 		content.WriteString("```\n\n")
-		
-		if step.ExecutionResult != nil {
-			// Write execution details
-			content.WriteString("> **Executed:** " + step.ExecutedAt.Format("2006-01-02 15:04:05") + "  \n")
-			
-			// Convert result status to emoji
-			resultEmoji := "✅ Success"
-			if step.ResultStatus == "error" {
-				resultEmoji = "❌ Error"
-			} else if step.ResultStatus == "timeout" {
-				resultEmoji = "⏰ Timeout"
-			} else if step.ResultStatus == "skipped" {
-				resultEmoji = "⏭️ Skipped"
-			}
-			content.WriteString("> **Result:** " + resultEmoji + "  \n")
-			
-			// Write output if available
-			if step.Output != "" {
-				content.WriteString("> **Output:**\n")
-				content.WriteString("> ```\n")
-				// Ensure output is properly formatted with > prefix for each line
-				for _, line := range strings.Split(step.Output, "\n") {
-					content.WriteString("> " + line + "\n")
-				}
-				content.WriteString("> ```\n")
+	}
+
+	// Steps spliced in by an include directive name the file they come from
+	if source := step.OriginalStep.Source; source != "" {
+		content.WriteString(fmt.Sprintf("> **Included from:** %s:%d  \n", source, step.OriginalStep.LineNumber))
+	}
+
+	// Edited commands keep the SOP's version next to what actually ran
+	if step.Modified {
+		content.WriteString("> **Modified:** ⚠️ Command edited during the run  \n")
+		content.WriteString("> **Original command:**\n")
+		content.WriteString("> ```" + language + "\n")
+		for _, line := range strings.Split(step.OriginalCommand, "\n") {
+			content.WriteString("> " + line + "\n")
+		}
+		content.WriteString("> ```\n")
+	}
+
+	if step.ExecutionResult != nil {
+		// Write execution details
+		content.WriteString("> **Executed:** " + step.ExecutedAt.Format("2006-01-02 15:04:05") + "  \n")
+
+		content.WriteString("> **Result:** " + ResultLabel(step.OriginalStep, step.ResultStatus) + "  \n")
+		if step.ExecutionResult.PolicyRule != "" {
+			content.WriteString("> **Policy:** " + step.ExecutionResult.Error + "  \n")
+		}
+		for _, mismatch := range step.ExecutionResult.Mismatches {
+			content.WriteString("> **Expectation failed:** " + mismatch + "  \n")
+		}
+		if n := len(step.OriginalStep.Expectations); n > 0 && step.ResultStatus == "success" {
+			content.WriteString(fmt.Sprintf("> **Expectations:** ✅ All %d met  \n", n))
+		}
+		if step.ExecutionResult.Note != "" {
+			content.WriteString("> **Note:** " + step.ExecutionResult.Note + "  \n")
+		}
+
+		// Write output if available
+		if step.Output != "" {
+			content.WriteString("> **Output:**\n")
+			content.WriteString("> ```\n")
+			// Ensure output is properly formatted with > prefix for each line
+			for _, line := range strings.Split(step.Output, "\n") {
+				content.WriteString("> " + line + "\n")
 			}
+			content.WriteString("> ```\n")
 		}
-		
-		content.WriteString("\n")
 	}
-	
-	return content.String()
+
+	content.WriteString("\n")
+}
+
+// isModified reports whether a step's command was edited away from the SOP
+func isModified(step types.Step) bool {
+	return step.OriginalCommand != "" && step.OriginalCommand != step.Command
+}
+
+// Deviations lists the steps of a run that diverged from the SOP: steps that
+// were skipped and steps whose command was edited before it ran
+func Deviations(steps []types.ExecutionStep) []types.Deviation {
+	var deviations []types.Deviation
+	for _, step := range steps {
+		result := step.ExecutionResult
+		deviation := types.Deviation{StepID: step.StepID, Title: step.OriginalStep.Title}
+		switch {
+		case result != nil && result.Status == "skipped":
+			deviation.Kind = types.DeviationSkipped
+		case result != nil && isModified(step.OriginalStep):
+			deviation.Kind = types.DeviationModified
+		default:
+			continue
+		}
+		deviations = append(deviations, deviation)
+	}
+	return deviations
+}
+
+// DeviationLabel describes a deviation, e.g. "Step 2 (Dump database): command modified"
+func DeviationLabel(deviation types.Deviation) string {
+	what := "skipped"
+	if deviation.Kind == types.DeviationModified {
+		what = "command modified"
+	}
+	if deviation.Title == "" {
+		return fmt.Sprintf("Step %d: %s", deviation.StepID, what)
+	}
+	return fmt.Sprintf("Step %d (%s): %s", deviation.StepID, deviation.Title, what)
+}
+
+// RunStatusLabel converts a run status to the label shown in logs
+func RunStatusLabel(status string) string {
+	switch status {
+	case "failed":
+		return "❌ Failed"
+	case "interrupted":
+		return "⚠️ Interrupted"
+	case "rolled_back":
+		return "↩️ Rolled Back"
+	case "running":
+		return "🔄 In Progress"
+	default:
+		return "✅ Completed Successfully"
+	}
+}
+
+// StepResultLabel converts a step result status to the label shown in logs
+func StepResultLabel(status string) string {
+	switch status {
+	case "error":
+		return "❌ Error"
+	case "timeout":
+		return "⏰ Timeout"
+	case "skipped":
+		return "⏭️ Skipped"
+	case "cancelled":
+		return "🛑 Cancelled"
+	case "denied":
+		return "⛔ Denied by policy"
+	default:
+		return "✅ Success"
+	}
+}
+
+// ResultLabel converts the result status of step to the label shown in logs
+// Manual steps read as the operator's answer, e.g. "✅ Done"
+func ResultLabel(step types.Step, status string) string {
+	if !step.Manual {
+		return StepResultLabel(status)
+	}
+	switch status {
+	case "success":
+		return "✅ Done"
+	case "error":
+		return "❌ Failed"
+	}
+	return StepResultLabel(status)
+}
+
+// FormatVariables formats variable values as "KEY=VALUE" pairs sorted by name
+func FormatVariables(values map[string]string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+values[name])
+	}
+	return strings.Join(pairs, ", ")
 }
 
 // GetLogDirectory returns the logger's log directory
 func (l *Logger) GetLogDirectory() string {
 	return l.logDirectory
-}
\ No newline at end of file
+}
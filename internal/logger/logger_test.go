@@ -3,9 +3,11 @@ package logger
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"opsy/internal/config"
 	"opsy/internal/types"
 
 	"github.com/stretchr/testify/assert"
@@ -18,12 +20,12 @@ func TestLogExecution(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tmpDir) // Clean up after test
-	
+
 	// Create a logger with the test directory
 	logger := &Logger{
 		logDirectory: tmpDir,
 	}
-	
+
 	// Create a sample execution
 	execution := types.SOPExecution{
 		ID:         "2025-10-09_22-37-14",
@@ -37,8 +39,8 @@ func TestLogExecution(t *testing.T) {
 			{
 				StepID: 1,
 				OriginalStep: types.Step{
-					ID:    1,
-					Title: "Test Step",
+					ID:      1,
+					Title:   "Test Step",
 					Command: "echo 'hello world'",
 				},
 				ExecutionResult: &types.ExecutionResult{
@@ -50,21 +52,21 @@ func TestLogExecution(t *testing.T) {
 			},
 		},
 	}
-	
+
 	// Log the execution
 	logPath, err := logger.LogExecution(execution)
-	
+
 	assert.NoError(t, err)
 	assert.NotEmpty(t, logPath)
-	
+
 	// Check if the log file was created
 	_, err = os.Stat(logPath)
 	assert.NoError(t, err)
-	
+
 	// Check if the content is correct by reading the file
 	content, err := os.ReadFile(logPath)
 	assert.NoError(t, err)
-	
+
 	// Verify the content contains expected elements
 	contentStr := string(content)
 	assert.Contains(t, contentStr, "# Test SOP")
@@ -73,11 +75,20 @@ func TestLogExecution(t *testing.T) {
 	assert.Contains(t, contentStr, "```bash\necho 'hello world'\n```")
 	assert.Contains(t, contentStr, "**Result:** ✅ Success")
 	assert.Contains(t, contentStr, "hello world")
+
+	// The JSON sidecar holds the same run
+	logFile, err := ReadLogFile(logPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-10-09_22-37-14", logFile.SOPRunID)
+	assert.Equal(t, "testuser", logFile.ExecutedBy)
+	assert.Len(t, logFile.Steps, 1)
+	assert.Equal(t, "success", logFile.Steps[0].ResultStatus)
+	assert.Equal(t, "hello world", logFile.Steps[0].Output)
 }
 
 func TestFormatLogContent(t *testing.T) {
 	logger := &Logger{}
-	
+
 	logFile := types.LogFile{
 		Title:       "Test SOP",
 		SOPRunID:    "2025-10-09_22-37-14",
@@ -86,6 +97,16 @@ func TestFormatLogContent(t *testing.T) {
 		StartedAt:   time.Date(2025, 10, 9, 22, 37, 14, 0, time.UTC),
 		EndedAt:     time.Date(2025, 10, 9, 22, 39, 01, 0, time.UTC),
 		Status:      "completed",
+		Variables:   map[string]string{"DB_NAME": "production", "DB_HOST": "localhost"},
+		Context: types.RunContext{
+			RealName:    "Test User",
+			Hostname:    "db-admin-01",
+			WorkingDir:  "/srv/ops",
+			OpsyVersion: "v1.2.0",
+			SOPChecksum: "sha256:abc123",
+			GitCommit:   "0123456789abcdef",
+			GitDirty:    true,
+		},
 		Steps: []types.LogStep{
 			{
 				StepID:  1,
@@ -106,14 +127,20 @@ func TestFormatLogContent(t *testing.T) {
 			},
 		},
 	}
-	
+
 	content := logger.formatLogContent(logFile)
-	
+
 	assert.Contains(t, content, "# Test SOP")
 	assert.Contains(t, content, "**SOP Run ID:** 2025-10-09_22-37-14")
-	assert.Contains(t, content, "**Executed by:** testuser")
+	assert.Contains(t, content, "**Executed by:** testuser (Test User)")
+	assert.Contains(t, content, "**Host:** db-admin-01")
+	assert.Contains(t, content, "**Working directory:** /srv/ops")
+	assert.Contains(t, content, "**opsy version:** v1.2.0")
+	assert.Contains(t, content, "**SOP checksum:** sha256:abc123")
+	assert.Contains(t, content, "**SOP revision:** 0123456789abcdef (uncommitted changes)")
 	assert.Contains(t, content, "Started at:** 2025-10-09 22:37:14")
 	assert.Contains(t, content, "Ended at:** 2025-10-09 22:39:01")
+	assert.Contains(t, content, "**Variables:** DB_HOST=localhost, DB_NAME=production")
 	assert.Contains(t, content, "## Step 1: Test Step")
 	assert.Contains(t, content, "```bash\necho 'hello world'\n```")
 	assert.Contains(t, content, "**Result:** ✅ Success")
@@ -127,19 +154,285 @@ func TestNewLogger(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tmpDir) // Clean up after test
-	
+
 	// Temporarily override the default log directory
 	origLogDir := os.Getenv("HOME")
 	os.Setenv("HOME", tmpDir)
 	defer os.Setenv("HOME", origLogDir)
-	
-	logger, err := NewLogger()
-	
+
+	logger, err := NewLogger(config.Default())
+
 	assert.NoError(t, err)
 	assert.NotNil(t, logger)
-	
+
 	// Check if the default log directory was created
 	expectedLogDir := filepath.Join(tmpDir, ".opsy", "logs")
 	_, err = os.Stat(expectedLogDir)
 	assert.NoError(t, err)
-}
\ No newline at end of file
+}
+
+func TestLogExecutionRewritesSameRun(t *testing.T) {
+	logger := &Logger{
+		logDirectory: t.TempDir(),
+	}
+
+	execution := types.SOPExecution{
+		ID:        NewRunID(time.Date(2025, 10, 9, 22, 37, 14, 0, time.Local)),
+		SOPName:   "Test SOP",
+		SOPPath:   "/home/user/.opsy/sops/test/test-sop.md",
+		StartedAt: time.Date(2025, 10, 9, 22, 37, 14, 0, time.Local),
+		Status:    "running",
+	}
+
+	firstPath, err := logger.LogExecution(execution)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(firstPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "**Status:** 🔄 In Progress")
+	assert.NotContains(t, string(content), "**Ended at:**")
+
+	// Finishing the run rewrites the same file
+	execution.Status = "completed"
+	execution.EndedAt = execution.StartedAt.Add(time.Minute)
+	secondPath, err := logger.LogExecution(execution)
+	assert.NoError(t, err)
+	assert.Equal(t, firstPath, secondPath)
+
+	entries, err := os.ReadDir(filepath.Dir(firstPath))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2) // The markdown log and its JSON sidecar
+
+	content, err = os.ReadFile(secondPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "**Ended at:** 2025-10-09 22:38:14")
+	assert.Contains(t, string(content), "**Status:** ✅ Completed Successfully")
+}
+
+func TestLogExecutionSameSecond(t *testing.T) {
+	logger := &Logger{
+		logDirectory: t.TempDir(),
+	}
+
+	// Two runs of one SOP started in the same second get their own files
+	startedAt := time.Date(2025, 10, 9, 22, 37, 14, 0, time.Local)
+	var paths []string
+	for range 2 {
+		path, err := logger.LogExecution(types.SOPExecution{
+			ID:        NewRunID(startedAt),
+			SOPName:   "Test SOP",
+			SOPPath:   "/home/user/.opsy/sops/test/test-sop.md",
+			StartedAt: startedAt,
+			Status:    "completed",
+		})
+		assert.NoError(t, err)
+		assert.Contains(t, filepath.Base(path), "test-sop_09-10-2025_22-37-14-")
+		paths = append(paths, path)
+	}
+	assert.NotEqual(t, paths[0], paths[1])
+
+	entries, err := os.ReadDir(filepath.Dir(paths[0]))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	// Run IDs without a suffix keep the original file name
+	path := logger.LogPath(types.SOPExecution{
+		ID:        "run-1760042234",
+		SOPPath:   "/home/user/.opsy/sops/test/test-sop.md",
+		StartedAt: startedAt,
+	})
+	assert.Equal(t, "test-sop_09-10-2025_22-37-14.log.md", filepath.Base(path))
+}
+
+func TestCollectRunContext(t *testing.T) {
+	sopPath := filepath.Join(t.TempDir(), "sop.md")
+	assert.NoError(t, os.WriteFile(sopPath, []byte("hello\n"), 0644))
+
+	runCtx := CollectRunContext(sopPath, "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03")
+	assert.NotEmpty(t, runCtx.User)
+	assert.NotEmpty(t, runCtx.OpsyVersion)
+	assert.Equal(t, "sha256:5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03", runCtx.SOPChecksum)
+	assert.Empty(t, runCtx.GitCommit) // Temp dirs are not in a repository
+
+	// A missing SOP still yields the operator and host
+	runCtx = CollectRunContext(filepath.Join(t.TempDir(), "missing.md"), "")
+	assert.NotEmpty(t, runCtx.User)
+	assert.Empty(t, runCtx.SOPChecksum)
+}
+
+func TestLogDeviations(t *testing.T) {
+	logger := &Logger{
+		logDirectory: t.TempDir(),
+	}
+
+	executedAt := time.Date(2025, 10, 9, 22, 37, 21, 0, time.Local)
+	logPath, err := logger.LogExecution(types.SOPExecution{
+		ID:        NewRunID(executedAt),
+		SOPName:   "Deploy",
+		SOPPath:   "/home/user/.opsy/sops/deploy.md",
+		StartedAt: executedAt,
+		EndedAt:   executedAt,
+		Status:    "completed",
+		ExecutionLog: []types.ExecutionStep{
+			{StepID: 1, OriginalStep: types.Step{ID: 1, Title: "Build", Command: "make"},
+				ExecutionResult: &types.ExecutionResult{Status: "success", ExecutedAt: executedAt}},
+			{StepID: 2, OriginalStep: types.Step{ID: 2, Title: "Migrate", Command: "migrate --force", OriginalCommand: "migrate"},
+				ExecutionResult: &types.ExecutionResult{Status: "success", ExecutedAt: executedAt}},
+			{StepID: 3, OriginalStep: types.Step{ID: 3, Title: "Notify", Command: "notify"},
+				ExecutionResult: &types.ExecutionResult{Status: "skipped", ExecutedAt: executedAt}},
+		},
+	})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	content := string(data)
+	assert.Contains(t, content, "```bash\nmigrate --force\n```")
+	assert.Contains(t, content, "> **Modified:**")
+	assert.Contains(t, content, "> **Original command:**\n> ```bash\n> migrate\n> ```")
+	assert.Contains(t, content, "## Deviations\n\n- Step 2 (Migrate): command modified\n- Step 3 (Notify): skipped\n")
+	assert.Equal(t, 1, strings.Count(content, "**Modified:**"))
+
+	logFile, err := ReadLogFile(logPath)
+	assert.NoError(t, err)
+	assert.False(t, logFile.Steps[0].Modified)
+	assert.True(t, logFile.Steps[1].Modified)
+	assert.Equal(t, "migrate", logFile.Steps[1].OriginalCommand)
+	assert.Equal(t, []types.Deviation{
+		{StepID: 2, Title: "Migrate", Kind: types.DeviationModified},
+		{StepID: 3, Title: "Notify", Kind: types.DeviationSkipped},
+	}, logFile.Deviations)
+}
+
+func TestLogManualSteps(t *testing.T) {
+	logger := &Logger{
+		logDirectory: t.TempDir(),
+	}
+
+	executedAt := time.Date(2025, 10, 9, 22, 37, 21, 0, time.Local)
+	logPath, err := logger.LogExecution(types.SOPExecution{
+		ID:        NewRunID(executedAt),
+		SOPName:   "Failover",
+		SOPPath:   "/home/user/.opsy/sops/failover.md",
+		StartedAt: executedAt,
+		EndedAt:   executedAt,
+		Status:    "failed",
+		ExecutionLog: []types.ExecutionStep{
+			{StepID: 1, OriginalStep: types.Step{ID: 1, Title: "Notify #incidents", Manual: true},
+				ExecutionResult: &types.ExecutionResult{Status: "success", ExecutedAt: executedAt, Note: "posted by alice"}},
+			{StepID: 2, OriginalStep: types.Step{ID: 2, Title: "Verify dashboard is green", Manual: true},
+				ExecutionResult: &types.ExecutionResult{Status: "error", ExecutedAt: executedAt, Note: "p99 latency is red"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	content := string(data)
+	assert.NotContains(t, content, "```bash")
+	assert.Contains(t, content, "## Step 1: Notify #incidents\n> **Manual step:**")
+	assert.Contains(t, content, "> **Result:** ✅ Done  \n> **Note:** posted by alice  \n")
+	assert.Contains(t, content, "> **Result:** ❌ Failed  \n> **Note:** p99 latency is red  \n")
+
+	logFile, err := ReadLogFile(logPath)
+	assert.NoError(t, err)
+	assert.True(t, logFile.Steps[0].OriginalStep.Manual)
+	assert.Equal(t, "p99 latency is red", logFile.Steps[1].ExecutionResult.Note)
+}
+
+func TestLogExpectations(t *testing.T) {
+	logger := &Logger{
+		logDirectory: t.TempDir(),
+	}
+
+	executedAt := time.Date(2025, 10, 9, 22, 37, 21, 0, time.Local)
+	expectations := []types.Expectation{{Kind: types.ExpectContains, Value: ".sql.gz"}, {Kind: types.ExpectExitCode, Value: "0"}}
+	logPath, err := logger.LogExecution(types.SOPExecution{
+		ID:        NewRunID(executedAt),
+		SOPName:   "Backup",
+		SOPPath:   "/home/user/.opsy/sops/backup.md",
+		StartedAt: executedAt,
+		EndedAt:   executedAt,
+		Status:    "failed",
+		ExecutionLog: []types.ExecutionStep{
+			{StepID: 1, OriginalStep: types.Step{ID: 1, Title: "ls", Command: "ls", Expectations: expectations},
+				ExecutionResult: &types.ExecutionResult{Status: "success", ExecutedAt: executedAt}},
+			{StepID: 2, OriginalStep: types.Step{ID: 2, Title: "ls", Command: "ls", Expectations: expectations},
+				ExecutionResult: &types.ExecutionResult{Status: "error", ExecutedAt: executedAt,
+					Mismatches: []string{"exit code 2, expected 0", "output does not contain \".sql.gz\""}}},
+		},
+	})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	content := string(data)
+	assert.Contains(t, content, "> **Result:** ✅ Success  \n> **Expectations:** ✅ All 2 met  \n")
+	assert.Contains(t, content, "> **Result:** ❌ Error  \n"+
+		"> **Expectation failed:** exit code 2, expected 0  \n"+
+		"> **Expectation failed:** output does not contain \".sql.gz\"  \n")
+
+	logFile, err := ReadLogFile(logPath)
+	assert.NoError(t, err)
+	assert.Len(t, logFile.Steps[1].ExecutionResult.Mismatches, 2)
+	assert.Equal(t, expectations, logFile.Steps[1].OriginalStep.Expectations)
+}
+
+func TestLogRollbacks(t *testing.T) {
+	logger := &Logger{
+		logDirectory: t.TempDir(),
+	}
+
+	executedAt := time.Date(2025, 10, 9, 22, 37, 21, 0, time.Local)
+	rollback := &types.Step{ID: 1, Title: "Stop app", Command: "systemctl start app", CommandType: "bash"}
+	logPath, err := logger.LogExecution(types.SOPExecution{
+		ID:        NewRunID(executedAt),
+		SOPName:   "Deploy",
+		SOPPath:   "/home/user/.opsy/sops/deploy.md",
+		StartedAt: executedAt,
+		EndedAt:   executedAt,
+		Status:    "rolled_back",
+		ExecutionLog: []types.ExecutionStep{
+			{StepID: 1, OriginalStep: types.Step{ID: 1, Title: "Stop app", Command: "systemctl stop app", Rollback: rollback},
+				ExecutionResult: &types.ExecutionResult{Status: "success", ExecutedAt: executedAt}},
+			{StepID: 2, OriginalStep: types.Step{ID: 2, Title: "Migrate", Command: "./migrate"},
+				ExecutionResult: &types.ExecutionResult{Status: "error", Error: "exit status 1", ExecutedAt: executedAt}},
+		},
+		Rollbacks: []types.ExecutionStep{
+			{StepID: 1, OriginalStep: *rollback,
+				ExecutionResult: &types.ExecutionResult{Status: "success", Output: "started", ExecutedAt: executedAt}},
+		},
+	})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	content := string(data)
+	assert.Contains(t, content, "> **Status:** ↩️ Rolled Back\n")
+	assert.Contains(t, content, "## Rollback of Step 1: Stop app\n```bash\nsystemctl start app\n```\n\n"+
+		"> **Executed:** 2025-10-09 22:37:21  \n> **Result:** ✅ Success  \n")
+	assert.Less(t, strings.Index(content, "## Step 2: Migrate"), strings.Index(content, "## Rollback of Step 1"))
+
+	logFile, err := ReadLogFile(logPath)
+	assert.NoError(t, err)
+	assert.Len(t, logFile.Rollbacks, 1)
+	assert.Equal(t, "systemctl start app", logFile.Rollbacks[0].Command)
+	assert.Equal(t, "systemctl start app", logFile.Steps[0].OriginalStep.Rollback.Command)
+}
+
+func TestLogIncludedSteps(t *testing.T) {
+	logger := &Logger{}
+
+	executedAt := time.Date(2025, 10, 9, 22, 37, 21, 0, time.Local)
+	content := logger.formatLogContent(types.LogFile{
+		Title:     "Deploy",
+		StartedAt: executedAt,
+		Status:    "completed",
+		Steps: []types.LogStep{
+			{StepID: 1, Command: "lb drain web-1", ResultStatus: "success", ExecutedAt: executedAt,
+				OriginalStep:    types.Step{ID: 1, Title: "lb", Command: "lb drain web-1", LineNumber: 12, Source: "/sops/common/maintenance-on.md"},
+				ExecutionResult: &types.ExecutionResult{Status: "success"}},
+		},
+	})
+	assert.Contains(t, content, "```\n\n> **Included from:** /sops/common/maintenance-on.md:12  \n> **Executed:**")
+}
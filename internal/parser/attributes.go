@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"opsy/internal/types"
+)
+
+// fenceInfo holds the annotations written after the language on an opening
+// code fence, e.g. ```bash {timeout=5m confirm=true id=dump}
+type fenceInfo struct {
+	attrs map[string]string // key=value attributes
+	flags map[string]bool   // Bare words, e.g. "confirm"
+}
+
+// parseFenceInfo parses the info string following the fence language
+// Attributes may be wrapped in braces and values may be double-quoted
+func parseFenceInfo(info string) (fenceInfo, error) {
+	parsed := fenceInfo{
+		attrs: make(map[string]string),
+		flags: make(map[string]bool),
+	}
+
+	tokens, err := splitFenceInfo(info)
+	if err != nil {
+		return parsed, err
+	}
+
+	for _, token := range tokens {
+		key, value, hasValue := strings.Cut(token, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			return parsed, fmt.Errorf("invalid step attribute %q", token)
+		}
+		if hasValue {
+			parsed.attrs[key] = value
+		} else {
+			parsed.flags[key] = true
+		}
+	}
+	return parsed, nil
+}
+
+// splitFenceInfo splits an info string into tokens, dropping braces and
+// keeping double-quoted values (which may contain spaces) together
+func splitFenceInfo(info string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range info {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+			current.WriteRune(r)
+		case r == '{' || r == '}' || r == ',' || r == ' ' || r == '\t':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in %q", info)
+	}
+	flush()
+	return tokens, nil
+}
+
+// applyStepAttributes copies fence annotations onto a step
+func applyStepAttributes(step *types.Step, info fenceInfo) error {
+	for flag := range info.flags {
+		switch flag {
+		case "confirm":
+			step.Confirm = true
+		case "continue_on_error":
+			step.ContinueOnError = true
+		case "rollback":
+			// Attached to the step before it by ParseSOP
+		default:
+			return fmt.Errorf("unknown step attribute %q", flag)
+		}
+	}
+
+	for key, value := range info.attrs {
+		switch key {
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid timeout %q", value)
+			}
+			step.Timeout = timeout
+		case "confirm", "continue_on_error":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %q", key, value)
+			}
+			if key == "confirm" {
+				step.Confirm = enabled
+			} else {
+				step.ContinueOnError = enabled
+			}
+		case "id":
+			step.Name = value
+		case "rollback":
+			// Validated by isRollback
+		default:
+			return fmt.Errorf("unknown step attribute %q", key)
+		}
+	}
+	return nil
+}
+
+// isRollback reports whether a block is marked rollback: it undoes the step
+// before it instead of being a step of its own
+func (f fenceInfo) isRollback() (bool, error) {
+	if f.flags["rollback"] {
+		return true, nil
+	}
+	value, ok := f.attrs["rollback"]
+	if !ok {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for rollback: %q", value)
+	}
+	return enabled, nil
+}
@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"opsy/internal/types"
+)
+
+// expectLanguage is the code block language holding assertions on the step
+// before it
+const expectLanguage = "expect"
+
+// jsonPathPattern matches the JSON paths expectations can use: "." for the
+// whole document, else keys and array indexes such as .items[0].status
+var jsonPathPattern = regexp.MustCompile(`^(\.|(\.[\w-]+|\[\d+\])+)$`)
+
+// parseExpectations parses the body of an ```expect block, one "kind: value"
+// assertion per line. Blank lines and lines starting with # are ignored.
+// firstLine is the line number of the block's first line, for errors
+func parseExpectations(body string, firstLine int) ([]types.Expectation, error) {
+	var expectations []types.Expectation
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		expectation, err := parseExpectation(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", firstLine+i, err)
+		}
+		expectations = append(expectations, expectation)
+	}
+	return expectations, nil
+}
+
+// parseExpectation parses a single assertion, e.g. "contains: backup.sql.gz"
+// or "json: .status == \"ok\""
+func parseExpectation(line string) (types.Expectation, error) {
+	kind, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return types.Expectation{}, fmt.Errorf("expected kind: value, got %q", line)
+	}
+	expectation := types.Expectation{
+		Kind:  strings.ToLower(strings.TrimSpace(kind)),
+		Value: strings.TrimSpace(value),
+	}
+
+	switch expectation.Kind {
+	case types.ExpectExitCode:
+		if _, err := strconv.Atoi(expectation.Value); err != nil {
+			return expectation, fmt.Errorf("invalid exit code %q", expectation.Value)
+		}
+	case types.ExpectContains, types.ExpectNotContains:
+		if expectation.Value == "" {
+			return expectation, fmt.Errorf("%s needs the text to look for", expectation.Kind)
+		}
+	case types.ExpectMatches:
+		if _, err := regexp.Compile(expectation.Value); err != nil {
+			return expectation, fmt.Errorf("invalid pattern %q: %w", expectation.Value, err)
+		}
+	case types.ExpectJSON:
+		// A path alone asserts that it exists; "path == value" compares the value
+		path, expected, hasValue := strings.Cut(expectation.Value, "==")
+		expectation.Path = strings.TrimSpace(path)
+		expectation.Value = ""
+		if !jsonPathPattern.MatchString(expectation.Path) {
+			return expectation, fmt.Errorf("invalid JSON path %q", expectation.Path)
+		}
+		if hasValue {
+			expected = strings.TrimSpace(expected)
+			if !json.Valid([]byte(expected)) {
+				// Bare words compare as strings, e.g. .status == ok
+				encoded, _ := json.Marshal(expected)
+				expected = string(encoded)
+			}
+			expectation.Value = expected
+		}
+	default:
+		return expectation, fmt.Errorf("unknown expectation %q", expectation.Kind)
+	}
+	return expectation, nil
+}
+
+// FormatExpectation formats an expectation the way it is written in an
+// ```expect block
+func FormatExpectation(expectation types.Expectation) string {
+	if expectation.Kind != types.ExpectJSON {
+		return expectation.Kind + ": " + expectation.Value
+	}
+	if expectation.Value == "" {
+		return expectation.Kind + ": " + expectation.Path
+	}
+	return expectation.Kind + ": " + expectation.Path + " == " + expectation.Value
+}
@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"opsy/internal/types"
+)
+
+// frontMatter holds the metadata fields supported in an SOP's YAML front matter
+type frontMatter struct {
+	Title         string           `yaml:"title"`
+	Description   string           `yaml:"description"`
+	Owner         string           `yaml:"owner"`
+	Tags          []string         `yaml:"tags"`
+	Version       string           `yaml:"version"`
+	RequiredTools []string         `yaml:"required_tools"`
+	Timeout       string           `yaml:"timeout"` // Go duration, e.g. "5m"
+	Severity      string           `yaml:"severity"`
+	Vars          []types.Variable `yaml:"vars"`
+	Execution     string           `yaml:"execution"` // "isolated" or "persistent"
+}
+
+// splitFrontMatter finds a YAML front matter block delimited by "---" lines
+// at the top of the file. Returns the raw YAML and the index of the first
+// body line; bodyStart is 0 when there is no front matter.
+func splitFrontMatter(lines []string) (raw string, bodyStart int) {
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return "", 0
+	}
+
+	for i := 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "---" || trimmed == "..." {
+			return strings.Join(lines[1:i], "\n"), i + 1
+		}
+	}
+
+	// No closing delimiter - treat the file as plain markdown
+	return "", 0
+}
+
+// applyFrontMatter parses the raw YAML front matter and copies it onto the SOP
+func applyFrontMatter(sop *types.SOP, raw string) error {
+	var fm frontMatter
+	if err := yaml.Unmarshal([]byte(raw), &fm); err != nil {
+		return fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	if fm.Timeout != "" {
+		timeout, err := time.ParseDuration(fm.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid front matter timeout %q: %w", fm.Timeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("invalid front matter timeout %q: must be positive", fm.Timeout)
+		}
+		sop.Timeout = timeout
+	}
+
+	switch fm.Execution {
+	case "", types.ExecutionIsolated, types.ExecutionPersistent:
+	default:
+		return fmt.Errorf("invalid front matter execution %q: must be %q or %q",
+			fm.Execution, types.ExecutionIsolated, types.ExecutionPersistent)
+	}
+
+	if err := validateDeclarations(fm.Vars); err != nil {
+		return fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	sop.Title = strings.TrimSpace(fm.Title)
+	sop.Description = strings.TrimSpace(fm.Description)
+	sop.Owner = fm.Owner
+	sop.Tags = fm.Tags
+	sop.Version = fm.Version
+	sop.RequiredTools = fm.RequiredTools
+	sop.Severity = strings.ToLower(fm.Severity)
+	sop.Variables = fm.Vars
+	sop.ExecutionMode = fm.Execution
+	return nil
+}
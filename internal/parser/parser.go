@@ -2,23 +2,76 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 
+	"opsy/internal/config"
 	"opsy/internal/types"
 )
 
 // ParseSOP parses a markdown file and extracts executable command blocks
-func ParseSOP(filePath string) (*types.SOP, error) {
-	file, err := os.Open(filePath)
+// Code blocks in a language without an entry in interpreters (nil means the
+// built-in ones) are kept as reference content instead of steps.
+// Include directives splice in the steps of other files
+func ParseSOP(filePath string, interpreters map[string]string) (*types.SOP, error) {
+	if interpreters == nil {
+		interpreters = config.DefaultInterpreters()
+	}
+	sop, err := parseSOP(filePath, interpreters, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sop.ExecutionMode == types.ExecutionPersistent {
+		if sop.SessionLanguage, err = sessionLanguage(sop.Steps); err != nil {
+			return nil, err
+		}
+	}
+	return sop, nil
+}
+
+// sessionLanguage returns the shell a persistent session must run for steps
+// to share it: the one of bash and zsh their blocks use, else sh if they use
+// it, else "" for the configured shell. ```sh blocks run in bash and zsh too
+func sessionLanguage(steps []types.Step) (string, error) {
+	language := ""
+	for _, step := range steps {
+		blocks := []types.Step{step}
+		if step.Rollback != nil {
+			blocks = append(blocks, *step.Rollback)
+		}
+		for _, block := range blocks {
+			switch lang := strings.ToLower(block.CommandType); lang {
+			case "sh":
+				if language == "" {
+					language = lang
+				}
+			case "bash", "zsh":
+				if language != "" && language != "sh" && language != lang {
+					return "", fmt.Errorf("execution: persistent runs every step in one shell, "+
+						"but step %d is a %s block and others are %s blocks", block.ID, lang, language)
+				}
+				language = lang
+			}
+		}
+	}
+	return language, nil
+}
+
+// parseSOP parses filePath; chain lists the files whose include directives
+// led to it, outermost first
+func parseSOP(filePath string, interpreters map[string]string, chain []string) (*types.SOP, error) {
+	chain = append(chain[:len(chain):len(chain)], filePath)
+
+	content, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
 	var lines []string
 	for scanner.Scan() {
 		lines = append(lines, scanner.Text())
@@ -29,49 +82,86 @@ func ParseSOP(filePath string) (*types.SOP, error) {
 	}
 
 	sop := &types.SOP{
-		Name: filePath,
-		Path: filePath,
-		Steps: []types.Step{},
+		Name:     filePath,
+		Path:     filePath,
+		Steps:    []types.Step{},
+		Checksum: checksum(content),
 	}
 
-	// Extract title from the first H1 header if available
-	title := extractTitle(lines)
-	if title != "" {
-		sop.Title = title
+	// Read metadata from the YAML front matter, if any
+	rawFrontMatter, bodyStart := splitFrontMatter(lines)
+	if bodyStart > 0 {
+		if err := applyFrontMatter(sop, rawFrontMatter); err != nil {
+			return nil, err
+		}
+	}
+	body := lines[bodyStart:]
+
+	// Extract title from the first H1 header if not set in front matter
+	if sop.Title == "" {
+		sop.Title = extractTitle(body)
+	}
+
+	// Fall back to the first paragraph of the document for the description
+	if sop.Description == "" {
+		sop.Description = extractDescription(body)
 	}
 
 	stepID := 1
 	inCodeBlock := false
+	reference := false // The open code block is not executable
 	var currentCodeBlock strings.Builder
 	currentCodeType := ""
 	currentStepLineNumber := 0
+	var currentInfo fenceInfo
+
+	// Headings whose section is only prose are manual steps
+	manualHeadings := findManualHeadings(lines, bodyStart)
+	currentHeading := ""
 
-	// Pattern to match code fences like ```bash or ```sh
-	codeFenceStart := regexp.MustCompile("^```(\\w+)")
-	
 	for i, line := range lines {
-		// Check if we're starting a code block
-		startMatches := codeFenceStart.FindStringSubmatch(line)
-		if len(startMatches) > 1 {
-			lang := strings.ToLower(startMatches[1])
-			// Only process bash/shell code blocks
-			if lang == "bash" || lang == "sh" || lang == "shell" {
-				inCodeBlock = true
-				currentCodeType = lang
-				currentCodeBlock.Reset()
-				currentStepLineNumber = i + 1 // Line numbers start from 1
-				continue
-			}
+		// Front matter is not part of the document body
+		if i < bodyStart {
+			continue
 		}
 
 		// If in a code block and encounter closing fence
-		if inCodeBlock && strings.HasPrefix(line, "```") {
+		if inCodeBlock && isClosingFence(line) {
 			inCodeBlock = false
+
+			// Expect blocks hold assertions on the step before them
+			if currentCodeType == expectLanguage {
+				previous := len(sop.Steps) - 1
+				if previous < 0 || sop.Steps[previous].Manual {
+					return nil, fmt.Errorf("line %d: expect block does not follow a command", currentStepLineNumber)
+				}
+				expectations, err := parseExpectations(currentCodeBlock.String(), currentStepLineNumber+1)
+				if err != nil {
+					return nil, err
+				}
+				sop.Steps[previous].Expectations = append(sop.Steps[previous].Expectations, expectations...)
+				continue
+			}
+
+			// Reference blocks are shown with the step that follows them
+			if reference {
+				content := strings.Trim(currentCodeBlock.String(), "\n")
+				if strings.TrimSpace(content) != "" {
+					sop.References = append(sop.References, types.CodeBlock{
+						Language:   currentCodeType,
+						Content:    content,
+						LineNumber: currentStepLineNumber,
+						BeforeStep: len(sop.Steps),
+					})
+				}
+				continue
+			}
+
 			command := strings.TrimSpace(currentCodeBlock.String())
 			if command != "" {
 				// Find the description/title for this step
 				stepDescription := findStepDescription(lines, currentStepLineNumber)
-				
+
 				step := types.Step{
 					ID:          stepID,
 					Title:       extractTitleFromCommand(command), // Use first few words as title
@@ -79,6 +169,31 @@ func ParseSOP(filePath string) (*types.SOP, error) {
 					Command:     command,
 					CommandType: currentCodeType,
 					LineNumber:  currentStepLineNumber,
+					Timeout:     sop.Timeout, // SOP default from front matter
+				}
+				// Fence annotations override the SOP defaults
+				if err := applyStepAttributes(&step, currentInfo); err != nil {
+					return nil, fmt.Errorf("line %d: %w", currentStepLineNumber, err)
+				}
+
+				// A rollback block undoes the step before it
+				rollback, err := currentInfo.isRollback()
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", currentStepLineNumber, err)
+				}
+				if rollback {
+					previous := len(sop.Steps) - 1
+					if previous < 0 || sop.Steps[previous].Manual {
+						return nil, fmt.Errorf("line %d: rollback block does not follow a command", currentStepLineNumber)
+					}
+					if sop.Steps[previous].Rollback != nil {
+						return nil, fmt.Errorf("line %d: step %d already has a rollback block", currentStepLineNumber, sop.Steps[previous].ID)
+					}
+					step.ID = sop.Steps[previous].ID
+					step.Title = sop.Steps[previous].Title
+					step.Description = ""
+					sop.Steps[previous].Rollback = &step
+					continue
 				}
 				sop.Steps = append(sop.Steps, step)
 				stepID++
@@ -92,6 +207,61 @@ func ParseSOP(filePath string) (*types.SOP, error) {
 			currentCodeBlock.WriteString("\n")
 			continue
 		}
+
+		if heading := headingPattern.FindStringSubmatch(line); heading != nil {
+			currentHeading = strings.TrimSpace(manualMarker.ReplaceAllString(heading[2], ""))
+			if description, ok := manualHeadings[i]; ok {
+				sop.Steps = append(sop.Steps, types.Step{
+					ID:          stepID,
+					Title:       currentHeading,
+					Description: description,
+					LineNumber:  i + 1,
+					Manual:      true,
+				})
+				stepID++
+			}
+			continue
+		}
+
+		// Include directives splice in the steps of another file
+		if include := includePattern.FindStringSubmatch(line); include != nil {
+			included, err := includeSOP(filePath, include[1], interpreters, chain)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			stepID = spliceSOP(sop, included, stepID)
+			continue
+		}
+
+		// Task list items, e.g. "- [ ] Notify #incidents", are manual steps
+		if item := taskItemPattern.FindStringSubmatch(line); item != nil {
+			sop.Steps = append(sop.Steps, types.Step{
+				ID:          stepID,
+				Title:       strings.TrimSpace(item[1]),
+				Description: currentHeading,
+				LineNumber:  i + 1,
+				Manual:      true,
+			})
+			stepID++
+			continue
+		}
+
+		// Check if we're starting a code block
+		if startMatches := codeFenceStart.FindStringSubmatch(line); startMatches != nil {
+			lang := strings.ToLower(startMatches[1])
+			reference = !executable(lang, interpreters) || lang == expectLanguage
+			if !reference {
+				info, err := parseFenceInfo(startMatches[2])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", i+1, err)
+				}
+				currentInfo = info
+			}
+			inCodeBlock = true
+			currentCodeType = lang
+			currentCodeBlock.Reset()
+			currentStepLineNumber = i + 1 // Line numbers start from 1
+		}
 	}
 
 	// Set the modification time
@@ -102,6 +272,175 @@ func ParseSOP(filePath string) (*types.SOP, error) {
 	return sop, nil
 }
 
+var (
+	// Code fences like ```bash, ```sh {timeout=5m} or ```
+	codeFenceStart = regexp.MustCompile("^```([\\w+-]*)(.*)$")
+	// ATX headings; the level is the number of #
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	// Marker making a heading a manual step: ## Announce the failover {manual}
+	manualMarker = regexp.MustCompile(`\s*\{manual\}$`)
+	// Markdown task list items: - [ ] text, * [x] text
+	taskItemPattern = regexp.MustCompile(`^\s*[-*+]\s+\[[ xX]\]\s+(.+)$`)
+	// Include directives: <!-- opsy:include ../common/maintenance-on.md -->
+	includePattern = regexp.MustCompile(`^\s*<!--\s*opsy:include\s+(\S+)\s*-->\s*$`)
+)
+
+// includeSOP parses the file named by an include directive in filePath,
+// relative to the directory of filePath. A file that is already being parsed
+// further up the chain is an include cycle
+func includeSOP(filePath, target string, interpreters map[string]string, chain []string) (*types.SOP, error) {
+	path := target
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(filePath), target)
+	}
+	for i, parent := range chain {
+		if sameFile(parent, path) {
+			cycle := append(append([]string{}, chain[i:]...), path)
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	included, err := parseSOP(path, interpreters, chain)
+	if err != nil {
+		return nil, fmt.Errorf("include %s: %w", target, err)
+	}
+	return included, nil
+}
+
+// sameFile reports whether two paths name the same file
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// spliceSOP appends the steps and reference blocks of an included SOP to sop,
+// numbering the steps from stepID, and returns the next step ID. Each step
+// keeps the file and line it was read from. The variables and required tools
+// the included file declares are added to those of sop
+func spliceSOP(sop, included *types.SOP, stepID int) int {
+	offset := len(sop.Steps)
+	for _, step := range included.Steps {
+		step.ID = stepID
+		if step.Source == "" {
+			step.Source = included.Path
+		}
+		if step.Timeout == 0 {
+			step.Timeout = sop.Timeout
+		}
+		if step.Rollback != nil {
+			rollback := *step.Rollback
+			rollback.ID = stepID
+			rollback.Source = step.Source
+			if rollback.Timeout == 0 {
+				rollback.Timeout = sop.Timeout
+			}
+			step.Rollback = &rollback
+		}
+		sop.Steps = append(sop.Steps, step)
+		stepID++
+	}
+	for _, block := range included.References {
+		block.BeforeStep += offset
+		if block.Source == "" {
+			block.Source = included.Path
+		}
+		sop.References = append(sop.References, block)
+	}
+
+	for _, variable := range included.Variables {
+		declared := false
+		for _, v := range sop.Variables {
+			declared = declared || v.Name == variable.Name
+		}
+		if !declared {
+			sop.Variables = append(sop.Variables, variable)
+		}
+	}
+	for _, tool := range included.RequiredTools {
+		required := false
+		for _, t := range sop.RequiredTools {
+			required = required || t == tool
+		}
+		if !required {
+			sop.RequiredTools = append(sop.RequiredTools, tool)
+		}
+	}
+	return stepID
+}
+
+// findManualHeadings returns the H2 and lower headings marked {manual},
+// mapped to the first paragraph of their section. Lines are indexes into lines
+func findManualHeadings(lines []string, bodyStart int) map[int]string {
+	manual := make(map[int]string)
+	heading := -1 // Line of the heading of the open section
+	var paragraph []string
+	paragraphDone := false
+
+	closeSection := func() {
+		if heading >= 0 {
+			manual[heading] = strings.Join(paragraph, " ")
+		}
+		heading = -1
+	}
+
+	inCodeBlock := false
+	for i := bodyStart; i < len(lines); i++ {
+		line := lines[i]
+		if inCodeBlock {
+			inCodeBlock = !isClosingFence(line)
+			continue
+		}
+		if codeFenceStart.MatchString(line) {
+			inCodeBlock = true
+			paragraphDone = len(paragraph) > 0
+			continue
+		}
+		if match := headingPattern.FindStringSubmatch(line); match != nil {
+			closeSection()
+			if len(match[1]) > 1 && manualMarker.MatchString(match[2]) {
+				heading = i
+				paragraph = nil
+				paragraphDone = false
+			}
+			continue
+		}
+		if taskItemPattern.MatchString(line) || includePattern.MatchString(line) {
+			paragraphDone = len(paragraph) > 0
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			paragraphDone = len(paragraph) > 0
+		} else if !paragraphDone {
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	closeSection()
+	return manual
+}
+
+// isClosingFence reports whether line closes a code block: only backticks,
+// so a fence with a language inside a block does not end it
+func isClosingFence(line string) bool {
+	line = strings.TrimRight(line, " \t\r\n")
+	return strings.HasPrefix(line, "```") && strings.Trim(line, "`") == ""
+}
+
+// executable reports whether code blocks in lang become steps
+// ```shell blocks always run, in the configured shell
+func executable(lang string, interpreters map[string]string) bool {
+	if lang == "shell" {
+		return true
+	}
+	_, ok := interpreters[lang]
+	return ok && lang != ""
+}
+
 // extractTitle extracts the title from the first H1 header
 func extractTitle(lines []string) string {
 	for _, line := range lines {
@@ -119,6 +458,40 @@ func extractTitle(lines []string) string {
 	return ""
 }
 
+// extractDescription returns the first paragraph following the H1 title
+// Stops at the first section header or code block
+func extractDescription(lines []string) string {
+	start := 0
+	for i, line := range lines {
+		if strings.HasPrefix(line, "# ") {
+			start = i + 1
+			break
+		}
+		// Setext-style H1 (underlined with ===)
+		if i < len(lines)-1 && strings.TrimSpace(line) != "" &&
+			strings.HasPrefix(lines[i+1], "=") && isAllEqualSigns(lines[i+1]) {
+			start = i + 2
+			break
+		}
+	}
+
+	var paragraph []string
+	for _, line := range lines[start:] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			if len(paragraph) > 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "```") || includePattern.MatchString(line) {
+			break
+		}
+		paragraph = append(paragraph, trimmed)
+	}
+	return strings.Join(paragraph, " ")
+}
+
 func indexOf(slice []string, item string) int {
 	for i, v := range slice {
 		if v == item {
@@ -142,19 +515,19 @@ func findStepDescription(lines []string, codeLineNumber int) string {
 	// Look backwards from the code block to find the description
 	for i := codeLineNumber - 2; i >= 0; i-- { // -2 to skip the code fence line and start from one before the code
 		line := lines[i]
-		// Skip empty lines
-		if strings.TrimSpace(line) == "" {
+		// Skip empty lines and checklist items, which are steps of their own
+		if strings.TrimSpace(line) == "" || taskItemPattern.MatchString(line) {
 			continue
 		}
 		// If it's a header, return it as the description
 		if strings.HasPrefix(line, "#") {
 			return strings.TrimSpace(strings.TrimLeft(line, "# "))
 		}
-		// If it's not another code block, return it as description
-		if !strings.HasPrefix(line, "```") {
+		// If it's not another code block or an include, return it as description
+		if !strings.HasPrefix(line, "```") && !includePattern.MatchString(line) {
 			return strings.TrimSpace(line)
 		}
-		// If we hit another code block, stop searching
+		// If we hit another code block or the included steps, stop searching
 		break
 	}
 	return ""
@@ -167,4 +540,4 @@ func extractTitleFromCommand(command string) string {
 		return cmd + "..." // Truncate if too long
 	}
 	return cmd
-}
\ No newline at end of file
+}
@@ -2,7 +2,13 @@ package parser
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"opsy/internal/config"
+	"opsy/internal/types"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -45,7 +51,7 @@ Done with the SOP.
 	}
 
 	// Test the parser
-	sop, err := ParseSOP(tmpfile.Name())
+	sop, err := ParseSOP(tmpfile.Name(), nil)
 	if assert.NoError(t, err) {
 		assert.Equal(t, "Deploy Nginx", sop.Title)
 		assert.Equal(t, tmpfile.Name(), sop.Path)
@@ -55,10 +61,588 @@ Done with the SOP.
 			assert.Equal(t, "curl", sop.Steps[0].Title)
 			assert.Equal(t, "bash", sop.Steps[0].CommandType)
 			assert.Equal(t, "curl -I localhost", sop.Steps[0].Command)
-			
+
 			assert.Equal(t, "sudo", sop.Steps[1].Title)
 			assert.Equal(t, "bash", sop.Steps[1].CommandType)
 			assert.Equal(t, "sudo systemctl restart nginx", sop.Steps[1].Command)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestParseSOPFrontMatter(t *testing.T) {
+	testContent := `---
+description: Nightly backup of the production database
+owner: dba-team
+tags: [database, backup]
+version: 1.2
+required_tools:
+  - pg_dump
+  - gzip
+timeout: 5m
+severity: High
+---
+# Backup Database
+
+` + "```bash" + `
+pg_dump production
+` + "```" + `
+`
+
+	path := filepath.Join(t.TempDir(), "backup.md")
+	if err := os.WriteFile(path, []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := ParseSOP(path, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Backup Database", sop.Title)
+		assert.Equal(t, "Nightly backup of the production database", sop.Description)
+		assert.Equal(t, "dba-team", sop.Owner)
+		assert.Equal(t, []string{"database", "backup"}, sop.Tags)
+		assert.Equal(t, "1.2", sop.Version)
+		assert.Equal(t, []string{"pg_dump", "gzip"}, sop.RequiredTools)
+		assert.Equal(t, 5*time.Minute, sop.Timeout)
+		assert.Equal(t, "high", sop.Severity)
+
+		if assert.Len(t, sop.Steps, 1) {
+			assert.Equal(t, 5*time.Minute, sop.Steps[0].Timeout)
+			assert.Equal(t, 14, sop.Steps[0].LineNumber)
+		}
+	}
+}
+
+func TestParseSOPInvalidFrontMatter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.md")
+	content := "---\ntimeout: soon\n---\n# Bad\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ParseSOP(path, nil)
+	assert.ErrorContains(t, err, "invalid front matter timeout")
+}
+
+func TestParseSOPDescriptionFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.md")
+	content := "# Plain\n\nFirst paragraph\ncontinues here.\n\nSecond paragraph.\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := ParseSOP(path, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "First paragraph continues here.", sop.Description)
+	}
+}
+
+func TestParseSOPVariables(t *testing.T) {
+	testContent := `---
+vars:
+  - name: DB_NAME
+    description: Database to back up
+    default: production
+  - name: DB_HOST
+    required: true
+    pattern: '^[a-z.]+$'
+---
+# Backup
+
+` + "```bash" + `
+pg_dump -h ${DB_HOST} -d {{ .DB_NAME }} -f ${BACKUP_DIR}/out.sql
+` + "```" + `
+`
+
+	path := filepath.Join(t.TempDir(), "backup.md")
+	if err := os.WriteFile(path, []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, sop.Variables, 2)
+	assert.Equal(t, "production", sop.Variables[0].Default)
+	assert.True(t, sop.Variables[1].Required)
+
+	// Missing required value
+	_, err = ResolveVariables(sop.Variables, map[string]string{})
+	assert.ErrorContains(t, err, "DB_HOST is required")
+
+	// Pattern mismatch
+	_, err = ResolveVariables(sop.Variables, map[string]string{"DB_HOST": "Bad Host"})
+	assert.ErrorContains(t, err, "DB_HOST must match")
+
+	// Undeclared variable
+	_, err = ResolveVariables(sop.Variables, map[string]string{"DB_HOST": "localhost", "DB_USER": "x"})
+	assert.ErrorContains(t, err, "unknown variable(s): DB_USER")
+
+	values, err := ResolveVariables(sop.Variables, map[string]string{"DB_HOST": "localhost"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "production", values["DB_NAME"])
+
+		// Undeclared ${BACKUP_DIR} is left for the shell
+		ApplyVariables(sop, values)
+		assert.Equal(t, "pg_dump -h localhost -d production -f ${BACKUP_DIR}/out.sql", sop.Steps[0].Command)
+	}
+}
+
+func TestParseSOPExecutionMode(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "persistent.md")
+	if err := os.WriteFile(path, []byte("---\nexecution: persistent\n---\n# Persistent\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sop, err := ParseSOP(path, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, types.ExecutionPersistent, sop.ExecutionMode)
+	}
+
+	path = filepath.Join(dir, "invalid.md")
+	if err := os.WriteFile(path, []byte("---\nexecution: forever\n---\n# Invalid\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseSOP(path, nil)
+	assert.ErrorContains(t, err, "invalid front matter execution")
+
+	// The session runs the shell the blocks are written for
+	for _, tt := range []struct {
+		blocks   string
+		language string
+		err      string
+	}{
+		{"```\nls\n```\n", "", ""},
+		{"```sh\ncd /tmp\n```\n\n```\nls\n```\n", "sh", ""},
+		{"```sh\ncd /tmp\n```\n\n```bash\n[[ -d . ]]\n```\n\n```bash rollback\ncd -\n```\n", "bash", ""},
+		{"```bash\ncd /tmp\n```\n\n```zsh\nls\n```\n", "", "step 2 is a zsh block and others are bash blocks"},
+	} {
+		path = filepath.Join(dir, "session.md")
+		if err := os.WriteFile(path, []byte("---\nexecution: persistent\n---\n# Session\n\n"+tt.blocks), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sop, err = ParseSOP(path, nil)
+		if tt.err != "" {
+			assert.ErrorContains(t, err, tt.err)
+		} else if assert.NoError(t, err) {
+			assert.Equal(t, tt.language, sop.SessionLanguage)
+		}
+	}
+}
+
+func TestParseSOPStepAttributes(t *testing.T) {
+	testContent := "---\ntimeout: 1m\n---\n# Attributes\n\n" +
+		"```bash {timeout=5m confirm=true continue_on_error=true id=dump}\npg_dump production\n```\n\n" +
+		"```sh {confirm, id=\"clean up\"}\nrm -f /tmp/dump\n```\n\n" +
+		"```bash\necho done\n```\n"
+
+	path := filepath.Join(t.TempDir(), "attrs.md")
+	if err := os.WriteFile(path, []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, sop.Steps, 3) {
+		return
+	}
+
+	assert.Equal(t, 5*time.Minute, sop.Steps[0].Timeout)
+	assert.True(t, sop.Steps[0].Confirm)
+	assert.True(t, sop.Steps[0].ContinueOnError)
+	assert.Equal(t, "dump", sop.Steps[0].Name)
+
+	assert.True(t, sop.Steps[1].Confirm)
+	assert.False(t, sop.Steps[1].ContinueOnError)
+	assert.Equal(t, "clean up", sop.Steps[1].Name)
+	assert.Equal(t, time.Minute, sop.Steps[1].Timeout)
+
+	assert.False(t, sop.Steps[2].Confirm)
+	assert.Equal(t, time.Minute, sop.Steps[2].Timeout)
+}
+
+func TestParseSOPInvalidStepAttributes(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"unknown step attribute \"retries\"": "```bash {retries=3}\necho\n```\n",
+		"invalid timeout":                    "```bash {timeout=soon}\necho\n```\n",
+		"invalid value for confirm":          "```bash {confirm=maybe}\necho\n```\n",
+	}
+
+	for expected, content := range cases {
+		path := filepath.Join(dir, "bad.md")
+		if err := os.WriteFile(path, []byte("# Bad\n\n"+content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ParseSOP(path, nil)
+		assert.ErrorContains(t, err, expected)
+		assert.ErrorContains(t, err, "line 3")
+	}
+}
+
+func TestStepEdit(t *testing.T) {
+	original := "---\nowner: ops\n---\r\n# Deploy\r\n\r\n" +
+		"```bash {confirm}\r\nsystemctl stop web\r\n```\r\n\r\n" +
+		"Then restart it.  \r\n\r\n" +
+		"```sh\r\nsystemctl start web\r\n```\r\n"
+	path := filepath.Join(t.TempDir(), "deploy.md")
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, sop.Steps, 2) {
+		return
+	}
+
+	edit, err := PrepareStepEdit(sop, 0, "systemctl stop web", "systemctl stop web\nsleep 5")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "systemctl stop web", edit.Old)
+	assert.Equal(t, "systemctl stop web\nsleep 5", edit.New)
+
+	// Only the code block changes, keeping the file's line endings
+	assert.NoError(t, edit.Apply(sop))
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, strings.Replace(original, "systemctl stop web\r\n", "systemctl stop web\r\nsleep 5\r\n", 1), string(content))
+
+	// Later steps follow the shifted lines, so they can be saved in turn
+	reparsed, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, reparsed.Steps[1].LineNumber, sop.Steps[1].LineNumber)
+	assert.Equal(t, reparsed.Checksum, sop.Checksum)
+	edit, err = PrepareStepEdit(sop, 1, "systemctl start web", "systemctl restart web")
+	if assert.NoError(t, err) {
+		assert.NoError(t, edit.Apply(sop))
+	}
+	content, _ = os.ReadFile(path)
+	assert.Contains(t, string(content), "```sh\r\nsystemctl restart web\r\n```\r\n")
+
+	// The block must still hold the command the edit started from
+	_, err = PrepareStepEdit(sop, 1, "systemctl start web", "systemctl reload web")
+	assert.ErrorContains(t, err, "code block does not match step 2")
+
+	// Edits are refused once the file changed on disk
+	edit, err = PrepareStepEdit(sop, 1, "systemctl restart web", "systemctl reload web")
+	if assert.NoError(t, err) {
+		assert.NoError(t, os.WriteFile(path, append(content, "\r\nDone.\r\n"...), 0644))
+		assert.ErrorContains(t, edit.Apply(sop), "changed on disk since it was loaded")
+	}
+	_, err = PrepareStepEdit(sop, 1, "systemctl restart web", "systemctl reload web")
+	assert.ErrorContains(t, err, "changed on disk since it was loaded")
+}
+
+func TestStepEditVariables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.md")
+	content := "---\nvars:\n  - name: DB\n---\n# Backup\n\n```bash\npg_dump {{ .DB }}\n```\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ApplyVariables(sop, map[string]string{"DB": "prod"})
+
+	// Substituted values are never written over the variable references
+	_, err = PrepareStepEdit(sop, 0, "pg_dump prod", "pg_dump -Fc prod")
+	assert.ErrorContains(t, err, "step 1 uses variables")
+	_, err = PrepareStepEdit(sop, 0, "pg_dump prod", "  ")
+	assert.ErrorContains(t, err, "command is empty")
+}
+
+func TestParseSOPLanguages(t *testing.T) {
+	testContent := "# Languages\n\n" +
+		"Apply this manifest:\n\n" +
+		"```yaml\nkind: ConfigMap\ndata:\n  key: value\n```\n\n" +
+		"```bash {confirm}\nkubectl apply -f cm.yaml\n```\n\n" +
+		"```python\nprint('checked')\n```\n\n" +
+		"```ruby\nputs 'hi'\n```\n\n" +
+		"```\n```bash\nnot a step\n```\n\n" +
+		"```json\n{\"done\": true}\n```\n"
+
+	path := filepath.Join(t.TempDir(), "languages.md")
+	if err := os.WriteFile(path, []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, sop.Steps, 2) {
+		return
+	}
+	assert.Equal(t, "bash", sop.Steps[0].CommandType)
+	assert.True(t, sop.Steps[0].Confirm)
+	assert.Equal(t, "python", sop.Steps[1].CommandType)
+	assert.Equal(t, 2, sop.Steps[1].ID)
+
+	// Blocks without an interpreter are kept, in place, as reference content
+	assert.Equal(t, []types.CodeBlock{
+		{Language: "yaml", Content: "kind: ConfigMap\ndata:\n  key: value", LineNumber: 5, BeforeStep: 0},
+		{Language: "ruby", Content: "puts 'hi'", LineNumber: 19, BeforeStep: 2},
+		{Language: "", Content: "```bash\nnot a step", LineNumber: 23, BeforeStep: 2},
+		{Language: "json", Content: "{\"done\": true}", LineNumber: 28, BeforeStep: 2},
+	}, sop.References)
+
+	// Configured interpreters decide which languages run
+	interpreters := config.DefaultInterpreters()
+	interpreters["ruby"] = "ruby"
+	sop, err = ParseSOP(path, interpreters)
+	if assert.NoError(t, err) && assert.Len(t, sop.Steps, 3) {
+		assert.Equal(t, "ruby", sop.Steps[2].CommandType)
+		assert.Len(t, sop.References, 3)
+	}
+}
+
+func TestParseSOPManualSteps(t *testing.T) {
+	testContent := "# Failover\n\nFail the database over.\n\n" +
+		"## Announce {manual}\n\nPost in #incidents that the failover\nis starting.\n\nLink the ticket.\n\n" +
+		"## Prepare\n\n- [ ] Verify the dashboard is green\n- [x] Page the DB owner\n\n" +
+		"```bash\npg_ctl promote\n```\n\n" +
+		"## Aftercare\n\n### Notify {{ .TEAM }} {manual}\n\nTell the {{ .TEAM }} team it is done.\n\n" +
+		"### Manifest\n\nKeep this around:\n\n```yaml\nkind: Note\n```\n\n" +
+		"## Notes\n\nProse without the marker is documentation.\n"
+
+	path := filepath.Join(t.TempDir(), "manual.md")
+	if err := os.WriteFile(path, []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, sop.Steps, 5) {
+		return
+	}
+
+	// Headings marked {manual} become manual steps described by their first paragraph
+	assert.Equal(t, types.Step{
+		ID: 1, Title: "Announce", Description: "Post in #incidents that the failover is starting.",
+		LineNumber: 5, Manual: true,
+	}, sop.Steps[0])
+
+	// Task list items become manual steps, checked or not
+	assert.True(t, sop.Steps[1].Manual)
+	assert.Equal(t, "Verify the dashboard is green", sop.Steps[1].Title)
+	assert.Equal(t, "Prepare", sop.Steps[1].Description)
+	assert.Equal(t, 14, sop.Steps[1].LineNumber)
+	assert.Equal(t, "Page the DB owner", sop.Steps[2].Title)
+
+	// Code blocks still make steps, described by their heading
+	assert.False(t, sop.Steps[3].Manual)
+	assert.Equal(t, "pg_ctl promote", sop.Steps[3].Command)
+	assert.Equal(t, "Prepare", sop.Steps[3].Description)
+
+	// Unmarked headings are not steps, however much prose they have
+	assert.Equal(t, "Notify {{ .TEAM }}", sop.Steps[4].Title)
+	assert.True(t, sop.Steps[4].Manual)
+
+	// Variables are substituted into the instructions of manual steps
+	ApplyVariables(sop, map[string]string{"TEAM": "dba"})
+	assert.Equal(t, "Notify dba", sop.Steps[4].Title)
+	assert.Equal(t, "Tell the dba team it is done.", sop.Steps[4].Description)
+}
+
+func TestParseSOPExpectations(t *testing.T) {
+	testContent := "# Backup\n\n" +
+		"```bash\nls -lh /backups | tail -1\n```\n\n" +
+		"```expect\n# The newest backup is not empty\nexit_code: 0\ncontains: .sql.gz\nmatches: ^-\\S+ +\\d+ \\S+ \\S+ +[1-9]\n```\n\n" +
+		"```bash\ncurl -s localhost/health\n```\n" +
+		"```expect\njson: .status == ok\njson: .checks[0].latency_ms\nnot_contains: degraded\n```\n"
+
+	path := filepath.Join(t.TempDir(), "expect.md")
+	if err := os.WriteFile(path, []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, sop.Steps, 2) {
+		return
+	}
+	assert.Empty(t, sop.References)
+	assert.Equal(t, []types.Expectation{
+		{Kind: types.ExpectExitCode, Value: "0"},
+		{Kind: types.ExpectContains, Value: ".sql.gz"},
+		{Kind: types.ExpectMatches, Value: `^-\S+ +\d+ \S+ \S+ +[1-9]`},
+	}, sop.Steps[0].Expectations)
+	assert.Equal(t, []types.Expectation{
+		{Kind: types.ExpectJSON, Path: ".status", Value: `"ok"`},
+		{Kind: types.ExpectJSON, Path: ".checks[0].latency_ms"},
+		{Kind: types.ExpectNotContains, Value: "degraded"},
+	}, sop.Steps[1].Expectations)
+	assert.Equal(t, "json: .status == \"ok\"", FormatExpectation(sop.Steps[1].Expectations[0]))
+
+	// Invalid assertions and expect blocks without a command are errors
+	for content, message := range map[string]string{
+		"```bash\ntrue\n```\n```expect\nmatches: (\n```\n":      "line 5: invalid pattern",
+		"```bash\ntrue\n```\n```expect\nexit_code: zero\n```\n": "line 5: invalid exit code",
+		"```bash\ntrue\n```\n```expect\nsize: 10\n```\n":        "line 5: unknown expectation",
+		"```bash\ntrue\n```\n```expect\njson: status\n```\n":    "line 5: invalid JSON path",
+		"# Title\n\n```expect\ncontains: ok\n```\n":             "line 3: expect block does not follow a command",
+		"- [ ] Check by hand\n```expect\ncontains: ok\n```\n":   "line 2: expect block does not follow a command",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ParseSOP(path, nil)
+		assert.ErrorContains(t, err, message)
+	}
+}
+
+func TestParseSOPRollback(t *testing.T) {
+	testContent := "# Deploy\n\n" +
+		"## Stop traffic\n\n```bash\nlb drain web-1\n```\n\n" +
+		"```bash rollback\nlb undrain web-1\n```\n\n" +
+		"## Deploy\n\n```bash {timeout=10m}\ndeploy v2\n```\n\n" +
+		"```bash {rollback timeout=2m confirm}\ndeploy v1\n```\n" +
+		"```expect\ncontains: v1\n```\n\n" +
+		"## Smoke test\n\n```bash\ncurl -f localhost\n```\n"
+
+	path := filepath.Join(t.TempDir(), "rollback.md")
+	if err := os.WriteFile(path, []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, sop.Steps, 3) {
+		return
+	}
+
+	// Rollback blocks are not steps; they belong to the step before them
+	assert.Equal(t, &types.Step{
+		ID: 1, Title: "lb", Command: "lb undrain web-1", CommandType: "bash", LineNumber: 9,
+	}, sop.Steps[0].Rollback)
+	assert.Equal(t, 2, sop.Steps[1].ID)
+	assert.Equal(t, 3, sop.Steps[2].ID)
+	assert.Nil(t, sop.Steps[2].Rollback)
+
+	// They take their own attributes
+	rollback := sop.Steps[1].Rollback
+	if assert.NotNil(t, rollback) {
+		assert.Equal(t, "deploy v1", rollback.Command)
+		assert.Equal(t, "deploy", rollback.Title)
+		assert.Equal(t, 2*time.Minute, rollback.Timeout)
+		assert.True(t, rollback.Confirm)
+	}
+	assert.Len(t, sop.Steps[1].Expectations, 1)
+
+	// Variables are substituted into rollback blocks like into steps
+	variables := "---\nvars:\n  - name: APP\n---\n" +
+		"```bash\nkubectl scale deploy {{ .APP }} --replicas=0\n```\n" +
+		"```bash rollback\nkubectl scale deploy {{ .APP }} --replicas=1\nrm -rf /srv/${APP}/tmp\n```\n"
+	if err := os.WriteFile(path, []byte(variables), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sop, err = ParseSOP(path, nil)
+	if assert.NoError(t, err) && assert.NotNil(t, sop.Steps[0].Rollback) {
+		ApplyVariables(sop, map[string]string{"APP": "web"})
+		assert.Equal(t, "kubectl scale deploy web --replicas=0", sop.Steps[0].Command)
+		assert.Equal(t, "kubectl scale deploy web --replicas=1\nrm -rf /srv/web/tmp", sop.Steps[0].Rollback.Command)
+	}
+
+	for content, message := range map[string]string{
+		"# Title\n\n```bash rollback\nundo\n```\n":                                "line 3: rollback block does not follow a command",
+		"- [ ] By hand\n```bash rollback\nundo\n```\n":                            "line 2: rollback block does not follow a command",
+		"```bash\ndo\n```\n```bash rollback\nundo\n```\n```sh rollback\nx\n```\n": "line 7: step 1 already has a rollback block",
+		"```bash\ndo\n```\n```bash {rollback=maybe}\nundo\n```\n":                 "line 4: invalid value for rollback",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ParseSOP(path, nil)
+		assert.ErrorContains(t, err, message)
+	}
+}
+
+func TestParseSOPIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	write("common/maintenance-on.md", "---\n"+
+		"required_tools: [lb]\n"+
+		"vars:\n  - name: HOST\n    required: true\n  - name: POOL\n    default: web\n"+
+		"---\n# Maintenance on\n\n"+
+		"```yaml\npool: web\n```\n\n"+
+		"```bash\nlb drain {{ .HOST }}\n```\n"+
+		"```bash rollback\nlb undrain {{ .HOST }}\n```\n\n"+
+		"<!-- opsy:include check-db.md -->\n")
+	write("common/check-db.md", "# Check DB\n\n- [ ] Check the replication lag\n")
+	path := write("infra/deploy.md", "---\n"+
+		"timeout: 5m\n"+
+		"vars:\n  - name: HOST\n    default: web-1\n"+
+		"---\n# Deploy\n\n"+
+		"```bash\necho start\n```\n\n"+
+		"## Maintenance\n\n<!-- opsy:include ../common/maintenance-on.md -->\n\n"+
+		"```bash\ndeploy {{ .HOST }}\n```\n")
+
+	sop, err := ParseSOP(path, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, sop.Steps, 4) {
+		return
+	}
+
+	// Included steps are numbered in place and keep their file and line
+	assert.Equal(t, []int{1, 2, 3, 4}, []int{sop.Steps[0].ID, sop.Steps[1].ID, sop.Steps[2].ID, sop.Steps[3].ID})
+	assert.Empty(t, sop.Steps[0].Source)
+	assert.Equal(t, filepath.Join(dir, "common/maintenance-on.md"), sop.Steps[1].Source)
+	assert.Equal(t, 15, sop.Steps[1].LineNumber)
+	assert.Equal(t, 5*time.Minute, sop.Steps[1].Timeout)
+	if assert.NotNil(t, sop.Steps[1].Rollback) {
+		assert.Equal(t, 2, sop.Steps[1].Rollback.ID)
+		assert.Equal(t, sop.Steps[1].Source, sop.Steps[1].Rollback.Source)
+	}
+	assert.True(t, sop.Steps[2].Manual)
+	assert.Equal(t, filepath.Join(dir, "common/check-db.md"), sop.Steps[2].Source)
+	assert.Equal(t, 3, sop.Steps[2].LineNumber)
+	assert.Equal(t, "deploy {{ .HOST }}", sop.Steps[3].Command)
+	assert.Empty(t, sop.Steps[3].Description)
+	assert.Empty(t, sop.Steps[3].Source)
+
+	// The heading holding only the directive is not a manual step
+	for _, step := range sop.Steps {
+		assert.NotEqual(t, "Maintenance", step.Title)
+	}
+
+	if assert.Len(t, sop.References, 1) {
+		assert.Equal(t, 1, sop.References[0].BeforeStep)
+		assert.Equal(t, sop.Steps[1].Source, sop.References[0].Source)
+	}
+
+	// Declarations of the included file are added unless already declared
+	assert.Equal(t, []string{"lb"}, sop.RequiredTools)
+	if assert.Len(t, sop.Variables, 2) {
+		assert.Equal(t, "web-1", sop.Variables[0].Default)
+		assert.Equal(t, "POOL", sop.Variables[1].Name)
+	}
+
+	// Included steps cannot be written back to the SOP file
+	_, err = PrepareStepEdit(sop, 1, sop.Steps[1].Command, "lb drain web-2")
+	assert.ErrorContains(t, err, "step 2 is included from")
+
+	// Missing files and cycles are errors
+	write("a.md", "<!-- opsy:include b.md -->\n")
+	write("b.md", "```bash\necho b\n```\n\n<!-- opsy:include a.md -->\n")
+	write("self.md", "<!--opsy:include ./self.md-->\n")
+	write("missing.md", "# Missing\n\n<!-- opsy:include nope.md -->\n")
+	for name, message := range map[string]string{
+		"a.md":       "line 1: include b.md: line 5: include cycle: " + filepath.Join(dir, "a.md") + " -> " + filepath.Join(dir, "b.md") + " -> " + filepath.Join(dir, "a.md"),
+		"self.md":    "line 1: include cycle",
+		"missing.md": "line 3: include nope.md: failed to open file",
+	} {
+		_, err := ParseSOP(filepath.Join(dir, name), nil)
+		assert.ErrorContains(t, err, message, name)
+	}
+
+	// Directives inside code blocks are left alone
+	plain := write("plain.md", "```bash\necho '<!-- opsy:include nope.md -->'\n```\n")
+	sop, err = ParseSOP(plain, nil)
+	if assert.NoError(t, err) {
+		assert.Len(t, sop.Steps, 1)
+	}
+}
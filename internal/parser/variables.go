@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"opsy/internal/types"
+)
+
+// Variable references inside code blocks: {{ .NAME }} or ${NAME}
+var (
+	templateVarPattern = regexp.MustCompile(`\{\{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	shellVarPattern    = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	variableNameRegex  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// validateDeclarations checks variable declarations from the front matter
+func validateDeclarations(vars []types.Variable) error {
+	seen := make(map[string]bool)
+	for _, v := range vars {
+		if !variableNameRegex.MatchString(v.Name) {
+			return fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("variable %s is declared more than once", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Pattern != "" {
+			if _, err := regexp.Compile(v.Pattern); err != nil {
+				return fmt.Errorf("invalid pattern for variable %s: %w", v.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateVariable checks a single value against its declaration
+// An empty value is valid if the variable has a default or is optional
+func ValidateVariable(v types.Variable, value string) error {
+	if value == "" {
+		value = v.Default
+	}
+	if value == "" {
+		if v.Required {
+			return fmt.Errorf("%s is required", v.Name)
+		}
+		return nil
+	}
+	if v.Pattern != "" {
+		re, err := regexp.Compile(v.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for %s: %w", v.Name, err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("%s must match %s", v.Name, v.Pattern)
+		}
+	}
+	return nil
+}
+
+// ResolveVariables validates the provided values against the declarations and
+// returns the complete set of values, with defaults filled in for missing ones
+func ResolveVariables(vars []types.Variable, values map[string]string) (map[string]string, error) {
+	declared := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		declared[v.Name] = true
+	}
+
+	// Reject values for undeclared variables so typos don't go unnoticed
+	var unknown []string
+	for name := range values {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown variable(s): %s", strings.Join(unknown, ", "))
+	}
+
+	resolved := make(map[string]string, len(vars))
+	for _, v := range vars {
+		value := values[v.Name]
+		if err := ValidateVariable(v, value); err != nil {
+			return nil, err
+		}
+		if value == "" {
+			value = v.Default
+		}
+		resolved[v.Name] = value
+	}
+	return resolved, nil
+}
+
+// ApplyVariables substitutes resolved values into every step and rollback
+// command, and into the instructions of manual steps
+// Only declared variables are replaced, so other ${...} shell expansions are left alone
+func ApplyVariables(sop *types.SOP, values map[string]string) {
+	for i := range sop.Steps {
+		sop.Steps[i].Command = SubstituteVariables(sop.Steps[i].Command, values)
+		if rollback := sop.Steps[i].Rollback; rollback != nil {
+			rollback.Command = SubstituteVariables(rollback.Command, values)
+		}
+		if sop.Steps[i].Manual {
+			sop.Steps[i].Title = SubstituteVariables(sop.Steps[i].Title, values)
+			sop.Steps[i].Description = SubstituteVariables(sop.Steps[i].Description, values)
+		}
+	}
+}
+
+// SubstituteVariables replaces {{ .NAME }} and ${NAME} references in text
+func SubstituteVariables(text string, values map[string]string) string {
+	replace := func(pattern *regexp.Regexp) {
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			name := pattern.FindStringSubmatch(match)[1]
+			if value, ok := values[name]; ok {
+				return value
+			}
+			return match
+		})
+	}
+	replace(templateVarPattern)
+	replace(shellVarPattern)
+	return text
+}
@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"opsy/internal/types"
+)
+
+// StepEdit is a pending rewrite of one step's code block in its SOP file
+type StepEdit struct {
+	Index int    // Index of the step in the SOP
+	Old   string // Code block body currently in the file
+	New   string // Code block body after the edit
+
+	path     string
+	checksum string // Checksum of the file the edit was prepared against
+	content  []byte // File content with the block replaced
+	delta    int    // Change in the number of lines in the file
+}
+
+// PrepareStepEdit prepares replacing the code block of step index with
+// command. The file must be unchanged since sop was parsed and the block must
+// still hold expected, the command the operator started editing from.
+// Nothing is written until Apply
+func PrepareStepEdit(sop *types.SOP, index int, expected, command string) (*StepEdit, error) {
+	if index < 0 || index >= len(sop.Steps) {
+		return nil, fmt.Errorf("step %d does not exist", index+1)
+	}
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("step %d: command is empty", index+1)
+	}
+	if source := sop.Steps[index].Source; source != "" {
+		return nil, fmt.Errorf("step %d is included from %s, edit that file directly", index+1, source)
+	}
+
+	content, err := os.ReadFile(sop.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SOP: %w", err)
+	}
+	sum := checksum(content)
+	if sop.Checksum != "" && sum != sop.Checksum {
+		return nil, fmt.Errorf("%s changed on disk since it was loaded", sop.Path)
+	}
+
+	// Keep the file's line endings; lines include their terminator
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	start := sop.Steps[index].LineNumber - 1 // Opening fence
+	if start < 0 || start >= len(lines) || !bytes.HasPrefix(lines[start], []byte("```")) {
+		return nil, fmt.Errorf("line %d: no code block for step %d", start+1, index+1)
+	}
+	end := -1 // Closing fence
+	for i := start + 1; i < len(lines); i++ {
+		if isClosingFence(string(lines[i])) {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("line %d: code block is not closed", start+1)
+	}
+
+	var old strings.Builder
+	for _, line := range lines[start+1 : end] {
+		old.WriteString(strings.TrimRight(string(line), "\r\n") + "\n")
+	}
+	body := strings.TrimSpace(old.String())
+	if body != strings.TrimSpace(expected) {
+		if templateVarPattern.MatchString(body) || shellVarPattern.MatchString(body) {
+			return nil, fmt.Errorf("line %d: step %d uses variables, edit the SOP file directly", start+1, index+1)
+		}
+		return nil, fmt.Errorf("line %d: code block does not match step %d", start+1, index+1)
+	}
+
+	eol := "\n"
+	if bytes.HasSuffix(lines[start], []byte("\r\n")) {
+		eol = "\r\n"
+	}
+	newLines := strings.Split(strings.TrimSpace(command), "\n")
+
+	var updated bytes.Buffer
+	for _, line := range lines[:start+1] {
+		updated.Write(line)
+	}
+	for _, line := range newLines {
+		updated.WriteString(strings.TrimRight(line, "\r") + eol)
+	}
+	for _, line := range lines[end:] {
+		updated.Write(line)
+	}
+
+	return &StepEdit{
+		Index:    index,
+		Old:      body,
+		New:      strings.TrimSpace(command),
+		path:     sop.Path,
+		checksum: sum,
+		content:  updated.Bytes(),
+		delta:    len(newLines) - (end - start - 1),
+	}, nil
+}
+
+// Apply writes the edit to the SOP file and updates sop to match it, so that
+// further edits can be prepared against the new content. It refuses to write
+// if the file changed on disk after the edit was prepared
+func (e *StepEdit) Apply(sop *types.SOP) error {
+	content, err := os.ReadFile(e.path)
+	if err != nil {
+		return fmt.Errorf("failed to read SOP: %w", err)
+	}
+	if checksum(content) != e.checksum {
+		return fmt.Errorf("%s changed on disk since it was loaded", e.path)
+	}
+
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat SOP: %w", err)
+	}
+	if err := os.WriteFile(e.path, e.content, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write SOP: %w", err)
+	}
+
+	// Code blocks after the edited one moved by the change in line count;
+	// line numbers of included files are unchanged
+	line := sop.Steps[e.Index].LineNumber
+	for i := range sop.Steps {
+		if sop.Steps[i].Source != "" {
+			continue
+		}
+		if sop.Steps[i].LineNumber > line {
+			sop.Steps[i].LineNumber += e.delta
+		}
+		if rollback := sop.Steps[i].Rollback; rollback != nil && rollback.LineNumber > line {
+			rollback.LineNumber += e.delta
+		}
+	}
+	for i := range sop.References {
+		if sop.References[i].Source == "" && sop.References[i].LineNumber > line {
+			sop.References[i].LineNumber += e.delta
+		}
+	}
+	sop.Checksum = checksum(e.content)
+	if info, err := os.Stat(e.path); err == nil {
+		sop.Modified = info.ModTime()
+	}
+	return nil
+}
+
+// checksum returns the hex encoded SHA-256 of content
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,259 @@
+// Package policy decides whether a step's command may run
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Actions a rule can take on a matching command
+const (
+	ActionAllow   = "allow"   // Run without asking, overriding later rules
+	ActionDeny    = "deny"    // Never run; the step is recorded as denied
+	ActionConfirm = "confirm" // Run only after the operator confirms
+)
+
+// Rule matches commands by regular expression and/or command name
+// When Command is set, Match and Unless apply to each simple command with
+// that name (e.g. the "kubectl ..." part of "cd x && kubectl ..."),
+// otherwise they apply to the whole step command.
+type Rule struct {
+	Name    string `yaml:"name"`
+	Action  string `yaml:"action"`
+	Command string `yaml:"command"` // Command name, e.g. kubectl; sudo and env assignments are skipped
+	Match   string `yaml:"match"`   // Regex the command must match
+	Unless  string `yaml:"unless"`  // Regex that exempts an otherwise matching command
+	Message string `yaml:"message"` // Explanation shown when the rule applies
+
+	match  *regexp.Regexp
+	unless *regexp.Regexp
+}
+
+// Decision is the outcome of evaluating a command
+type Decision struct {
+	Action string
+	Rule   *Rule // The rule that decided; nil when no rule matched
+}
+
+// Reason describes the decision for status lines and logs
+func (d Decision) Reason() string {
+	if d.Rule == nil {
+		return ""
+	}
+	if d.Rule.Message != "" {
+		return fmt.Sprintf("policy rule %s: %s", d.Rule.Name, d.Rule.Message)
+	}
+	return "policy rule " + d.Rule.Name
+}
+
+// RuleName returns the name of the deciding rule, if any
+func (d Decision) RuleName() string {
+	if d.Rule == nil {
+		return ""
+	}
+	return d.Rule.Name
+}
+
+// Policy is an ordered list of rules; the first matching rule decides
+type Policy struct {
+	Rules []Rule
+}
+
+// policyFile mirrors policy.yaml
+type policyFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// DefaultRules blocks commands that destroy the machine they run on
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Name:    "rm-root",
+			Action:  ActionDeny,
+			Command: "rm",
+			Match:   `\s-[a-zA-Z]*[rR][a-zA-Z]*\s+(-\S+\s+)*/\*?(\s|$)`,
+			Message: "recursive delete of /",
+		},
+		{
+			Name:    "fork-bomb",
+			Action:  ActionDeny,
+			Match:   `:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`,
+			Message: "fork bomb",
+		},
+		{
+			Name:    "mkfs",
+			Action:  ActionDeny,
+			Match:   `(^|[\s;&|/])mkfs(\.\w+)?(\s|$)`,
+			Message: "formats a file system",
+		},
+	}
+}
+
+// Default returns the built-in policy
+func Default() *Policy {
+	p, err := New(DefaultRules())
+	if err != nil {
+		panic(err) // The built-in rules are constants
+	}
+	return p
+}
+
+// DefaultPath returns the default location of the policy file
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".opsy", "policy.yaml")
+}
+
+// New compiles rules into a policy
+func New(rules []Rule) (*Policy, error) {
+	p := &Policy{Rules: make([]Rule, len(rules))}
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rules[%d]: duplicate name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Action {
+		case ActionAllow, ActionDeny, ActionConfirm:
+		default:
+			return nil, fmt.Errorf("rule %s: action must be allow, deny or confirm, got %q", rule.Name, rule.Action)
+		}
+		if rule.Command == "" && rule.Match == "" {
+			return nil, fmt.Errorf("rule %s: set command, match or both", rule.Name)
+		}
+
+		var err error
+		if rule.Match != "" {
+			if rule.match, err = regexp.Compile(rule.Match); err != nil {
+				return nil, fmt.Errorf("rule %s: invalid match: %w", rule.Name, err)
+			}
+		}
+		if rule.Unless != "" {
+			if rule.unless, err = regexp.Compile(rule.Unless); err != nil {
+				return nil, fmt.Errorf("rule %s: invalid unless: %w", rule.Name, err)
+			}
+		}
+		p.Rules[i] = rule
+	}
+	return p, nil
+}
+
+// Load reads the policy file at path, or the default policy file when path
+// is empty. Only an explicitly requested file has to exist; without a file
+// the built-in rules apply. Rules from a file replace the built-in rules.
+func Load(path string) (*Policy, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath()
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return Default(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	var file policyFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+
+	p, err := New(file.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Evaluate returns the decision for a command
+// Commands that match no rule are allowed.
+func (p *Policy) Evaluate(command string) Decision {
+	if p == nil {
+		return Decision{Action: ActionAllow}
+	}
+	for i := range p.Rules {
+		if p.Rules[i].matches(command) {
+			return Decision{Action: p.Rules[i].Action, Rule: &p.Rules[i]}
+		}
+	}
+	return Decision{Action: ActionAllow}
+}
+
+// matches reports whether the rule applies to command
+func (r *Rule) matches(command string) bool {
+	if r.Command == "" {
+		return r.matchText(command)
+	}
+	for _, simple := range splitCommands(command) {
+		if commandName(simple) == r.Command && r.matchText(simple) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchText applies the rule's match and unless expressions to text
+func (r *Rule) matchText(text string) bool {
+	if r.match != nil && !r.match.MatchString(text) {
+		return false
+	}
+	return r.unless == nil || !r.unless.MatchString(text)
+}
+
+// commandSeparators split a script into simple commands
+var commandSeparators = regexp.MustCompile(`\|\||&&|[;|&\n]`)
+
+// splitCommands splits a shell script into its simple commands
+// This is a heuristic: quoting and subshells are not interpreted.
+func splitCommands(script string) []string {
+	var commands []string
+	for _, part := range commandSeparators.Split(script, -1) {
+		if part = strings.TrimSpace(part); part != "" {
+			commands = append(commands, part)
+		}
+	}
+	return commands
+}
+
+// commandName returns the program a simple command runs, skipping variable
+// assignments and wrappers like sudo, e.g. "FOO=1 sudo -u pg /sbin/mkfs.ext4 x" → "mkfs.ext4"
+func commandName(simple string) string {
+	fields := strings.Fields(simple)
+	for i := 0; i < len(fields); i++ {
+		word := strings.Trim(fields[i], `"'(`)
+		switch {
+		case strings.Contains(word, "=") && !strings.HasPrefix(word, "="):
+		case wrappers[word]:
+		case word == "-u" || word == "-g":
+			i++ // sudo -u USER, sudo -g GROUP
+		case strings.HasPrefix(word, "-"):
+		default:
+			return filepath.Base(word)
+		}
+	}
+	return ""
+}
+
+// wrappers run the command that follows them
+var wrappers = map[string]bool{
+	"sudo": true, "env": true, "exec": true, "command": true, "nohup": true, "time": true,
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writePolicy(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	p := Default()
+
+	cases := map[string]string{
+		"echo hello":                    ActionAllow,
+		"rm -rf /tmp/build":             ActionAllow,
+		"rm -rf /":                      ActionDeny,
+		"sudo rm -rf /*":                ActionDeny,
+		"cd / && rm -r -f /":            ActionDeny,
+		":(){:|:&};:":                   ActionDeny,
+		":() { : | : & }; :":            ActionDeny,
+		"mkfs.ext4 /dev/sdb1":           ActionDeny,
+		"sudo /sbin/mkfs -t xfs /dev/x": ActionDeny,
+		"grep mkfsopts notes.txt":       ActionAllow,
+	}
+	for command, expected := range cases {
+		assert.Equal(t, expected, p.Evaluate(command).Action, command)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writePolicy(t, `
+rules:
+  - name: tmp-ok
+    action: allow
+    command: rm
+    match: '\s/tmp/'
+  - name: rm-rf
+    action: deny
+    command: rm
+    match: '\s-\w*r'
+    message: recursive deletes outside /tmp
+  - name: drop-table
+    action: deny
+    match: '(?i)\bdrop\s+table\b'
+  - name: prod-delete
+    action: confirm
+    command: kubectl
+    match: '\bdelete\b'
+    unless: '--context[= ]staging'
+`)
+
+	p, err := Load(path)
+	assert.NoError(t, err)
+
+	cases := []struct {
+		command, action, rule string
+	}{
+		{"rm -rf /tmp/cache", ActionAllow, "tmp-ok"},
+		{"cd /srv && rm -rf data", ActionDeny, "rm-rf"},
+		{"rm notes.txt", ActionAllow, ""},
+		{"psql -c 'drop  TABLE users'", ActionDeny, "drop-table"},
+		{"kubectl delete pod web-1", ActionConfirm, "prod-delete"},
+		{"FOO=1 sudo -u ops kubectl delete pod web-1", ActionConfirm, "prod-delete"},
+		{"kubectl delete pod web-1 --context=staging", ActionAllow, ""},
+		{"kubectl get pods | grep delete", ActionAllow, ""},
+		{"echo kubectl delete", ActionAllow, ""},
+	}
+	for _, c := range cases {
+		decision := p.Evaluate(c.command)
+		assert.Equal(t, c.action, decision.Action, c.command)
+		assert.Equal(t, c.rule, decision.RuleName(), c.command)
+	}
+
+	decision := p.Evaluate("rm -rf data")
+	assert.Equal(t, "policy rule rm-rf: recursive deletes outside /tmp", decision.Reason())
+}
+
+func TestLoadDefaultPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// Without a policy file the built-in rules apply
+	p, err := Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, ActionDeny, p.Evaluate("rm -rf /").Action)
+
+	// A policy file replaces them
+	assert.NoError(t, os.MkdirAll(filepath.Join(home, ".opsy"), 0755))
+	assert.NoError(t, os.WriteFile(DefaultPath(), []byte("rules: []\n"), 0644))
+	p, err = Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, ActionAllow, p.Evaluate("rm -rf /").Action)
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := map[string]string{
+		"rules:\n  - {name: a, action: block, match: x}":                                        `action must be allow, deny or confirm, got "block"`,
+		"rules:\n  - {name: a, action: deny}":                                                   "set command, match or both",
+		"rules:\n  - {name: a, action: deny, match: '('}":                                       "invalid match",
+		"rules:\n  - {name: a, action: deny, match: x, unless: '['}":                            "invalid unless",
+		"rules:\n  - {name: a, action: deny, match: x}\n  - {name: a, action: allow, match: y}": `duplicate name "a"`,
+		"rule:\n  - {name: a}":                                                                  "field rule not found",
+	}
+	for content, expected := range cases {
+		_, err := Load(writePolicy(t, content))
+		assert.ErrorContains(t, err, expected, content)
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read policy")
+}
@@ -2,33 +2,42 @@ package tui
 
 // Mode constants
 const (
-	modeBrowse   = "browse"
-	modeExecute  = "execute"
-	modeLogs     = "logs"
-	modeLogView  = "logview"
-	modeEdit     = "edit"
+	modeBrowse  = "browse"
+	modeExecute = "execute"
+	modeLogs    = "logs"
+	modeLogView = "logview"
+	modeEdit    = "edit"
+	modeVars    = "variables"
 )
 
+// rootListPath is the browse path of the top level listing of SOP roots,
+// used when more than one root is configured
+const rootListPath = "[roots]"
+
 // UI layout constants
 const (
 	headerHeight  = 1
 	newlineHeight = 1
 	spacingHeight = 2
 	helpBarHeight = 1
-	
+
 	// Total UI chrome height
-	uiChromeHeight = headerHeight + newlineHeight // For browse/logs modes
+	uiChromeHeight        = headerHeight + newlineHeight                                 // For browse/logs modes
 	executeUIChromeHeight = headerHeight + newlineHeight + spacingHeight + helpBarHeight // For execute mode
-	
+
 	// Minimum content height
 	minContentHeight = 10
 )
 
 // Status constants
 const (
-	statusPending  = "pending"
-	statusSuccess  = "success"
-	statusError    = "error"
-	statusSkipped  = "skipped"
-	statusExecuted = "executed"
+	statusPending   = "pending"
+	statusSuccess   = "success"
+	statusError     = "error"
+	statusSkipped   = "skipped"
+	statusExecuted  = "executed"
+	statusRunning   = "running"
+	statusTimeout   = "timeout"
+	statusCancelled = "cancelled"
+	statusDenied    = "denied" // Blocked by the command policy
 )
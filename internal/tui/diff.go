@@ -0,0 +1,98 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// diffLine is one line of a line-based diff
+type diffLine struct {
+	op   byte // ' ' unchanged, '-' removed, '+' added
+	text string
+}
+
+// diffLines computes a line diff from a to b using the longest common
+// subsequence of lines. Commands are short, so the quadratic table is fine.
+func diffLines(a, b string) []diffLine {
+	from := strings.Split(a, "\n")
+	to := strings.Split(b, "\n")
+
+	// lcs[i][j] is the length of the LCS of from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			lines = append(lines, diffLine{' ', from[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', from[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		lines = append(lines, diffLine{'-', from[i]})
+	}
+	for ; j < len(to); j++ {
+		lines = append(lines, diffLine{'+', to[j]})
+	}
+	return lines
+}
+
+// renderCommandDiff renders an inline diff of an edited command against the
+// original, with removed lines in red and added lines in green
+func renderCommandDiff(original, edited string, width int) string {
+	var builder strings.Builder
+
+	labelStyle := lipgloss.NewStyle().
+		Foreground(colorAccent).
+		Bold(true).
+		PaddingLeft(4)
+	builder.WriteString(labelStyle.Render("Changes:") + "\n")
+
+	removedStyle := lipgloss.NewStyle().Foreground(colorError)
+	addedStyle := lipgloss.NewStyle().Foreground(colorSuccess)
+	sameStyle := lipgloss.NewStyle().Foreground(colorFaint)
+
+	var body []string
+	for _, line := range diffLines(original, edited) {
+		text := string(line.op) + " " + line.text
+		switch line.op {
+		case '-':
+			body = append(body, removedStyle.Render(text))
+		case '+':
+			body = append(body, addedStyle.Render(text))
+		default:
+			body = append(body, sameStyle.Render(text))
+		}
+	}
+
+	diffBoxStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(colorBorder).
+		Padding(0, 1).
+		MarginLeft(4).
+		Width(width - 12)
+	builder.WriteString(diffBoxStyle.Render(strings.Join(body, "\n")) + "\n")
+
+	return builder.String()
+}
@@ -0,0 +1,176 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"opsy/internal/parser"
+	"opsy/internal/types"
+)
+
+// editorCommand builds the command that opens path in editor (or $EDITOR,
+// falling back to vi) positioned on line when line is positive
+func editorCommand(editor, path string, line int) *exec.Cmd {
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	fields := strings.Fields(editor)
+	if len(fields) == 0 {
+		fields = []string{"vi"}
+	}
+
+	args := fields[1:]
+	switch {
+	case line <= 0:
+		args = append(args, path)
+	case isGotoEditor(fields[0]):
+		args = append(args, "--goto", fmt.Sprintf("%s:%d", path, line))
+	default:
+		args = append(args, fmt.Sprintf("+%d", line), path) // vi, emacs, nano, micro...
+	}
+	return exec.Command(fields[0], args...)
+}
+
+// isGotoEditor reports whether an editor takes --goto path:line instead of +line
+func isGotoEditor(name string) bool {
+	switch filepath.Base(name) {
+	case "code", "code-insiders", "codium", "cursor":
+		return true
+	}
+	return false
+}
+
+// openEditor suspends the TUI while the SOP at path is open in the editor
+func (m model) openEditor(path string, line int) tea.Cmd {
+	return tea.ExecProcess(editorCommand(m.config.Editor, path, line), func(err error) tea.Msg {
+		return editorFinishedMsg{path: path, err: err}
+	})
+}
+
+// isSOPFile reports whether path is the SOP or a file it includes
+func isSOPFile(sop *types.SOP, path string) bool {
+	if path == sop.Path {
+		return true
+	}
+	for _, step := range sop.Steps {
+		if step.Source == path {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadSOP re-parses the open SOP after it was changed in the editor
+// Steps whose command did not change keep their status, output and any
+// edit made during the run; the others start over as pending
+func (m *model) reloadSOP() {
+	sop, err := m.loadSOP(m.sop.Path)
+	if err != nil {
+		m.status = fmt.Sprintf("Cannot reload SOP: %v", err)
+		return
+	}
+
+	// Re-apply the run's variable values to the declarations now in the file
+	if m.session != nil {
+		values := make(map[string]string)
+		for _, v := range sop.Variables {
+			if value, ok := m.session.variables[v.Name]; ok {
+				values[v.Name] = value
+			}
+		}
+		resolved, err := parser.ResolveVariables(sop.Variables, values)
+		if err != nil {
+			m.status = fmt.Sprintf("Cannot reload SOP: %v", err)
+			return
+		}
+		parser.ApplyVariables(sop, resolved)
+		if len(sop.Variables) > 0 {
+			m.session.variables = resolved
+		}
+	}
+
+	matches := matchSteps(m.sop, m.steps, sop)
+	steps := newSOPSteps(sop)
+	kept := make(map[int]bool)
+	current := 0 // The current step, or the one after the last step before it
+	for j, i := range matches {
+		if i < 0 {
+			continue
+		}
+		kept[i] = true
+		if i < m.currentStep {
+			current = j + 1
+		} else if i == m.currentStep {
+			current = j
+		}
+
+		// Carry the operator's edit over to the re-parsed step
+		sop.Steps[j].OriginalCommand = m.sop.Steps[i].OriginalCommand
+		sop.Steps[j].Command = m.sop.Steps[i].Command
+
+		step := m.steps[i]
+		step.ID = steps[j].ID
+		step.Title = steps[j].Title
+		step.Description = steps[j].Description
+		steps[j] = step
+	}
+
+	reset := 0
+	for i, step := range m.steps {
+		if !kept[i] && step.Status != "" && step.Status != statusPending {
+			reset++
+		}
+	}
+
+	m.sop = sop
+	m.steps = steps
+	m.currentStep = min(current, max(len(steps)-1, 0))
+	m.confirmPending = false
+	m.manualPending = false
+	m.sopEdit = nil
+
+	m.status = fmt.Sprintf("Reloaded %s (%d steps)", filepath.Base(sop.Path), len(steps))
+	if reset > 0 {
+		m.status += fmt.Sprintf(", %d changed step(s) reset to pending", reset)
+	}
+}
+
+// matchSteps pairs the steps of a re-parsed SOP with those of the open one
+// by their command in the file (manual steps by their title), in order, so
+// that inserting or removing a step does not shift the others. For each new
+// step it returns the index of the matching old step, or -1
+func matchSteps(old *types.SOP, oldSteps []SOPStep, updated *types.SOP) []int {
+	matches := make([]int, len(updated.Steps))
+	next := 0
+	for j, step := range updated.Steps {
+		matches[j] = -1
+		for i := next; i < len(old.Steps) && i < len(oldSteps); i++ {
+			if old.Steps[i].Manual != step.Manual || (step.Manual && old.Steps[i].Title != step.Title) {
+				continue
+			}
+			if strings.TrimSpace(fileCommand(old.Steps[i], oldSteps[i])) == strings.TrimSpace(step.Command) {
+				matches[j] = i
+				next = i + 1
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// fileCommand returns the command of a step as it is written in the SOP file:
+// the last edit saved back to it, else the command before any edit
+func fileCommand(step types.Step, state SOPStep) string {
+	switch {
+	case state.SavedCommand != "":
+		return state.SavedCommand
+	case step.OriginalCommand != "":
+		return step.OriginalCommand
+	}
+	return step.Command
+}
@@ -3,8 +3,11 @@ package tui
 import (
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+
+	"opsy/internal/logger"
 )
 
 // renderExecutionContent generates the full content for the execution view
@@ -27,6 +30,11 @@ func (m model) renderExecutionContent() (content string, currentStepLine int) {
 	builder.WriteString(titleHeader)
 	lineCount += strings.Count(titleHeader, "\n")
 
+	// SOP metadata from front matter
+	metadata := renderSOPMetadata(m.sop, m.width)
+	builder.WriteString(metadata)
+	lineCount += strings.Count(metadata, "\n")
+
 	// Progress bar
 	totalSteps := len(m.steps)
 	completedSteps := 0
@@ -59,10 +67,52 @@ func (m model) renderExecutionContent() (content string, currentStepLine int) {
 		builder.WriteString(statusBadge + "\n\n")
 		lineCount += 2
 
+		// Steps from an included file name the file they come from
+		if i < len(m.sop.Steps) {
+			source := renderStepSource(m.sop.Steps[i], m.sop.Path)
+			builder.WriteString(source)
+			lineCount += strings.Count(source, "\n")
+		}
+
+		// Fence annotations (id, timeout override, confirm, continue_on_error)
+		if i < len(m.sop.Steps) {
+			attributes := renderStepAttributes(m.sop.Steps[i], m.sop.Timeout)
+			builder.WriteString(attributes)
+			lineCount += strings.Count(attributes, "\n")
+		}
+
+		// Waiting for the operator to confirm this step
+		if m.confirmPending && isCurrent {
+			prompt := renderConfirmPrompt(m.confirmReason, m.width)
+			builder.WriteString(prompt)
+			lineCount += strings.Count(prompt, "\n")
+		}
+
+		// Waiting for the operator's answer to a manual step
+		if m.manualPending && isCurrent {
+			prompt := renderManualPrompt(m.manualAnswer, m.manualNote.View(), m.width)
+			builder.WriteString(prompt)
+			lineCount += strings.Count(prompt, "\n")
+		}
+
+		// Edited command waiting to be written back to the SOP file
+		if m.sopEdit != nil && m.sopEdit.Index == i {
+			prompt := renderSavePrompt(m.sopEdit.Old, m.sopEdit.New, filepath.Base(m.sop.Path), m.width)
+			builder.WriteString(prompt)
+			lineCount += strings.Count(prompt, "\n")
+		}
+
+		// Spinner and elapsed time for the running step
+		if m.running && i == m.runningStep {
+			indicator := renderRunningIndicator(m.spinner.View(), time.Since(m.runStartedAt))
+			builder.WriteString(indicator + "\n\n")
+			lineCount += 2
+		}
+
 		// Description with better formatting
 		if step.Description != "" {
 			descStyle := lipgloss.NewStyle().
-				Foreground(lipgloss.Color("250")).
+				Foreground(colorMuted).
 				PaddingLeft(4).
 				Width(m.width - 8)
 
@@ -72,6 +122,12 @@ func (m model) renderExecutionContent() (content string, currentStepLine int) {
 			lineCount += descLines + 1
 		}
 
+		// Reference blocks (YAML, JSON...) written before the command
+		if references := renderReferences(m.sop.References, i, m.width); references != "" {
+			builder.WriteString(references)
+			lineCount += strings.Count(references, "\n")
+		}
+
 		// Command block
 		if step.Command != "" {
 			cmdBlock := renderCommandBlock(step.Command, m.width)
@@ -79,20 +135,63 @@ func (m model) renderExecutionContent() (content string, currentStepLine int) {
 			lineCount += strings.Count(cmdBlock, "\n")
 		}
 
-		// Output section
+		// Assertions checked once the command has finished
+		if i < len(m.sop.Steps) {
+			expectations := renderExpectations(m.sop.Steps[i].Expectations, m.width)
+			builder.WriteString(expectations)
+			lineCount += strings.Count(expectations, "\n")
+		}
+
+		// Rollback block, run when the operator rolls back a failed run
+		if i < len(m.sop.Steps) && m.sop.Steps[i].Rollback != nil {
+			rollback := renderRollback(m.sop.Steps[i].Rollback.Command, step.RollbackStatus, m.width)
+			builder.WriteString(rollback)
+			lineCount += strings.Count(rollback, "\n")
+		}
+
+		// Note recorded with the answer to a manual step
+		if note := renderNote(step.Note, m.width); note != "" {
+			builder.WriteString(note)
+			lineCount += strings.Count(note, "\n")
+		}
+
+		// Output section (a running step shows its latest lines)
 		if step.Output != "" {
-			outputBlock := renderOutputBlock(step.Output, m.width, 8)
+			output := step.Output
+			if m.running && i == m.runningStep {
+				output = tailOutput(output, 8)
+			}
+			outputBlock := renderOutputBlock(output, m.width, 8)
 			builder.WriteString(outputBlock)
 			lineCount += strings.Count(outputBlock, "\n")
 		}
 
-		// Error section
-		if step.Error != "" {
+		// Error section; unmet expectations are listed one per line
+		if mismatches := renderMismatches(step.Mismatches, m.width); mismatches != "" {
+			builder.WriteString(mismatches)
+			lineCount += strings.Count(mismatches, "\n")
+		} else if step.Error != "" {
 			errorBlock := renderErrorBlock(step.Error, m.width, 5)
 			builder.WriteString(errorBlock)
 			lineCount += strings.Count(errorBlock, "\n")
 		}
 
+		// Output and error of the rollback block
+		if step.RollbackOutput != "" {
+			output := step.RollbackOutput
+			if m.running && i == m.runningStep {
+				output = tailOutput(output, 8)
+			}
+			outputBlock := renderLabeledOutputBlock("Rollback output:", output, m.width, 8)
+			builder.WriteString(outputBlock)
+			lineCount += strings.Count(outputBlock, "\n")
+		}
+		if step.RollbackError != "" {
+			errorBlock := renderLabeledErrorBlock("Rollback error:", step.RollbackError, m.width, 5)
+			builder.WriteString(errorBlock)
+			lineCount += strings.Count(errorBlock, "\n")
+		}
+
 		// Step separator
 		if i < len(m.steps)-1 {
 			separator := renderStepSeparator(m.width)
@@ -101,6 +200,20 @@ func (m model) renderExecutionContent() (content string, currentStepLine int) {
 		}
 	}
 
+	// Reference blocks after the last step
+	if references := renderReferences(m.sop.References, len(m.steps), m.width); references != "" {
+		builder.WriteString("\n" + references)
+	}
+
+	// Summary of skipped and edited steps so far
+	var deviations []string
+	for _, deviation := range logger.Deviations(m.executionLog()) {
+		deviations = append(deviations, logger.DeviationLabel(deviation))
+	}
+	if summary := renderDeviations(deviations, m.width); summary != "" {
+		builder.WriteString("\n" + summary)
+	}
+
 	return builder.String(), currentStepLine
 }
 
@@ -133,3 +246,14 @@ func (m *model) updateViewportContent() {
 		m.viewport.SetYOffset(targetOffset)
 	}
 }
+
+// refreshViewportContent re-renders the execution view without moving the scroll position
+// Used for periodic redraws (spinner ticks) so they don't fight with user scrolling
+func (m *model) refreshViewportContent() {
+	if !m.viewportReady {
+		return
+	}
+
+	content, _ := m.renderExecutionContent()
+	m.viewport.SetContent(content)
+}
@@ -1,18 +1,211 @@
 package tui
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 
+	"opsy/internal/policy"
 	"opsy/internal/types"
 )
 
-// saveExecutionLog saves the current execution log
+// executeStepCmd runs a step in the background, streaming output lines as
+// stepOutputMsg and reporting the result with a stepFinishedMsg, so the UI
+// keeps redrawing while the command runs
+func (m model) executeStepCmd(ctx context.Context, runner StepRunner, index int, step types.Step) tea.Cmd {
+	seq := m.runSeq
+	output := make(chan string, 64)
+
+	run := func() tea.Msg {
+		result, err := runner.ExecuteStepStream(ctx, step, func(line string) {
+			output <- line
+		})
+		close(output)
+		return stepFinishedMsg{seq: seq, index: index, result: result, err: err}
+	}
+
+	return tea.Batch(run, waitForOutput(seq, index, output))
+}
+
+// startStep marks a step as running and returns the commands that execute it
+// Manual steps do not run; they prompt the operator for the outcome instead
+func (m *model) startStep(index int) []tea.Cmd {
+	if m.session == nil {
+		m.session = newRunSession(m.sop)
+	}
+	if m.sop.Steps[index].Manual {
+		m.promptManualStep(index)
+		return nil
+	}
+
+	runner, err := m.stepRunner()
+	if err != nil {
+		m.status = fmt.Sprintf("Error starting shell session: %v", err)
+		return nil
+	}
+
+	// Commands denied by the policy are recorded without running
+	if decision := m.executor.CheckCommand(m.sop.Steps[index].Command); decision.Action == policy.ActionDeny {
+		step := &m.steps[index]
+		step.Status = statusDenied
+		step.Output = ""
+		step.Error = "Denied by " + decision.Reason()
+		step.PolicyRule = decision.RuleName()
+		step.ExecutedAt = time.Now()
+		m.status = fmt.Sprintf("Step %d denied by %s", index+1, decision.Reason())
+		if m.runAll {
+			m.runAll = false
+			m.status = "Run all stopped: " + m.status
+		}
+		m.updateViewportContent()
+		return []tea.Cmd{m.saveExecutionLog(false)}
+	}
+
+	m.running = true
+	m.runningStep = index
+	m.runSeq++
+	m.runStartedAt = time.Now()
+	m.steps[index].Status = statusRunning
+	m.steps[index].Output = ""
+	m.steps[index].Error = ""
+	m.steps[index].PolicyRule = ""
+	m.steps[index].Mismatches = nil
+	m.status = fmt.Sprintf("Running step %d... (%s to cancel)", index+1, m.keys.Cancel.Help().Key)
+	if m.runAll {
+		m.status = fmt.Sprintf("Run all: running step %d/%d... (%s to cancel)", index+1, len(m.steps), m.keys.Cancel.Help().Key)
+	}
+	// Update viewport content to show the running indicator
+	m.updateViewportContent()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelRun = cancel
+	return []tea.Cmd{m.executeStepCmd(ctx, runner, index, m.sop.Steps[index]), m.spinner.Tick}
+}
+
+// confirmationReason returns why a step must be confirmed before it runs, or
+// "" if it needs no confirmation. Steps need it when the SOP marks them
+// confirm=true or when a command policy rule asks for it.
+// The step may also be a rollback block
+func (m model) confirmationReason(step types.Step) string {
+	if step.Manual {
+		return "" // Answering the step is the confirmation
+	}
+	if decision := m.executor.CheckCommand(step.Command); decision.Action == policy.ActionConfirm {
+		return "confirmation (" + decision.Reason() + ")"
+	}
+	if step.Confirm {
+		return "confirmation"
+	}
+	return ""
+}
+
+// stepRunner returns what should execute the open SOP's steps
+// SOPs with persistent execution share one shell, started on first use
+func (m *model) stepRunner() (StepRunner, error) {
+	if m.sop == nil || m.sop.ExecutionMode != types.ExecutionPersistent {
+		return m.executor, nil
+	}
+	if m.shell == nil {
+		starter, ok := m.executor.(ShellSessionStarter)
+		if !ok {
+			return m.executor, nil
+		}
+		shell, err := starter.NewShellSession(m.sop.SessionLanguage)
+		if err != nil {
+			return nil, err
+		}
+		m.shell = shell
+	}
+	return m.shell, nil
+}
+
+// endRun ends the open run before execute mode is left for another SOP or
+// the browser. A running step is cancelled and logged as cancelled, the log
+// is finalized and results still on their way are ignored
+func (m *model) endRun() tea.Cmd {
+	if m.cancelRun != nil {
+		m.cancelRun()
+		m.cancelRun = nil
+	}
+	if m.running && m.runningStep < len(m.steps) {
+		step := &m.steps[m.runningStep]
+		if m.rollingBack {
+			step.RollbackStatus = statusCancelled
+			step.RolledBackAt = time.Now()
+		} else {
+			step.Status = statusCancelled
+			step.ExecutedAt = time.Now()
+		}
+	}
+	m.running = false
+	m.runAll = false
+	m.rollingBack = false
+	m.runSeq++
+
+	var cmd tea.Cmd
+	if m.session != nil {
+		cmd = m.saveExecutionLog(true)
+	}
+	m.session = nil
+	m.closeShell()
+	return cmd
+}
+
+// closeShell ends the persistent shell session, if one is open
+func (m *model) closeShell() {
+	if m.shell != nil {
+		m.shell.Close()
+		m.shell = nil
+	}
+}
+
+// waitForOutput waits for the next line of output from a running step
+// Returns nil once the step has finished and the channel is closed
+func waitForOutput(seq, index int, output <-chan string) tea.Cmd {
+	return func() tea.Msg {
+		line, ok := <-output
+		if !ok {
+			return nil
+		}
+		return stepOutputMsg{seq: seq, index: index, line: line, output: output}
+	}
+}
+
+// runStatus derives the final run status from the step statuses
+// A cancelled or still running step, or steps left pending, mark the run as
+// interrupted
+func runStatus(steps []SOPStep) string {
+	failed, pending := false, false
+	for _, step := range steps {
+		switch step.Status {
+		case statusCancelled, statusRunning:
+			return "interrupted"
+		case statusError, statusTimeout, statusDenied:
+			failed = true
+		case "", statusPending:
+			pending = true
+		}
+	}
+	if failed {
+		return "failed"
+	}
+	if pending {
+		return "interrupted"
+	}
+	return "completed"
+}
+
+// saveExecutionLog saves the current execution log for the run session
 // Only saves if there are actually executed steps (not just opened for reading)
-func (m model) saveExecutionLog() tea.Cmd {
+// The final save sets the end time and overall status of the run
+func (m model) saveExecutionLog(final bool) tea.Cmd {
 	return func() tea.Msg {
+		if m.session == nil || m.sop == nil {
+			return logSavedMsg{}
+		}
+
 		// Check if any steps have been executed
 		hasExecutedSteps := false
 		for _, step := range m.steps {
@@ -21,57 +214,64 @@ func (m model) saveExecutionLog() tea.Cmd {
 				break
 			}
 		}
-		
+
 		// Only save log if steps have been executed
 		if !hasExecutedSteps {
 			// Don't save log for SOPs that were just opened for reading
 			// This is normal behavior when users just browse SOPs without executing
-			return enterModeMsg{
-				mode:   modeExecute,
-				status: "",
-			}
+			return logSavedMsg{}
 		}
-		
-		// Generate a unique ID based on timestamp
-		now := time.Now()
-		id := fmt.Sprintf("run-%d", now.Unix())
-		
+
+		// Every save of the run shares the session's ID and start time
+		runContext := m.session.runContext()
 		execution := types.SOPExecution{
-			ID:        id,
-			SOPName:   m.sop.Title,
-			SOPPath:   m.sop.Path,
-			ExecutedBy: "user", // TODO: Get actual user
-			StartedAt: now,
-			EndedAt:   now, // For logs, we set both to same time
-			Status:    "completed",
-			ExecutionLog: []types.ExecutionStep{},
+			ID:           m.session.id,
+			SOPName:      m.sop.Title,
+			SOPPath:      m.sop.Path,
+			ExecutedBy:   runContext.User,
+			Context:      runContext,
+			StartedAt:    m.session.startedAt,
+			Status:       "running",
+			Variables:    m.session.variables,
+			ExecutionLog: m.executionLog(),
+			Rollbacks:    m.rollbackLog(),
 		}
-
-		// Convert m.steps to execution log
-		for i, step := range m.steps {
-			execution.ExecutionLog = append(execution.ExecutionLog, types.ExecutionStep{
-				StepID:       step.ID,
-				OriginalStep: m.sop.Steps[i],
-				ExecutionResult: &types.ExecutionResult{
-					ExecutedAt: step.ExecutedAt,
-					Status:     step.Status,
-					Output:     step.Output,
-				},
-			})
+		if final {
+			execution.EndedAt = time.Now()
+			execution.Status = runStatus(m.steps)
+			if execution.Status != "completed" && m.rolledBack() {
+				execution.Status = "rolled_back"
+			}
 		}
 
 		_, err := m.logger.LogExecution(execution)
-		if err != nil {
-			return enterModeMsg{
-				mode:   modeExecute,
-				status: fmt.Sprintf("Error saving log: %v", err),
-			}
-		}
-		
+
 		// Silent success - no status message needed for incremental saves
-		return enterModeMsg{
-			mode:   modeExecute,
-			status: "",
+		return logSavedMsg{err: err}
+	}
+}
+
+// executionLog converts the step states of the open SOP into log entries
+func (m model) executionLog() []types.ExecutionStep {
+	log := make([]types.ExecutionStep, 0, len(m.steps))
+	for i, step := range m.steps {
+		execStep := types.ExecutionStep{
+			StepID:       step.ID,
+			OriginalStep: m.sop.Steps[i],
+		}
+		// Steps that were never run have no result
+		if step.Status != "" && step.Status != statusPending {
+			execStep.ExecutionResult = &types.ExecutionResult{
+				ExecutedAt: step.ExecutedAt,
+				Status:     step.Status,
+				Output:     step.Output,
+				Error:      step.Error,
+				PolicyRule: step.PolicyRule,
+				Note:       step.Note,
+				Mismatches: step.Mismatches,
+			}
 		}
+		log = append(log, execStep)
 	}
+	return log
 }
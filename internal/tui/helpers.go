@@ -6,18 +6,19 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/list"
 
-	"opsy/internal/config"
 	"opsy/internal/parser"
+	"opsy/internal/types"
 )
 
 // calculateViewportHeight calculates the available height for viewport content
 // All modes use: header(1) + newline(1) + spacing(2) + helpbar(1) = 5 lines
 func calculateViewportHeight(totalHeight int) int {
 	contentHeight := totalHeight - executeUIChromeHeight
-	
+
 	if contentHeight < minContentHeight {
 		contentHeight = minContentHeight
 	}
@@ -94,13 +95,142 @@ func truncateOutput(output string, maxLines int) string {
 	return strings.Join(truncated, "\n")
 }
 
+// tailOutput keeps only the last maxLines lines of output
+func tailOutput(output string, maxLines int) string {
+	lines := strings.Split(output, "\n")
+	if len(lines) <= maxLines {
+		return output
+	}
+
+	// Keep last maxLines-1 lines so the indicator fits within maxLines
+	tail := lines[len(lines)-maxLines+1:]
+	header := fmt.Sprintf("... (%d earlier lines)", len(lines)-len(tail))
+	return header + "\n" + strings.Join(tail, "\n")
+}
+
+// formatElapsed formats a duration for display next to a running step
+// Example: 1m5.2s → "1m5s"
+func formatElapsed(d time.Duration) string {
+	if d < time.Second {
+		return "0s"
+	}
+	return d.Truncate(time.Second).String()
+}
+
+// newSOPSteps creates the pending execution state for each step of an SOP
+func newSOPSteps(sop *types.SOP) []SOPStep {
+	steps := make([]SOPStep, len(sop.Steps))
+	for i, step := range sop.Steps {
+		steps[i] = SOPStep{
+			ID:          step.ID,
+			Title:       step.Title,
+			Description: step.Description,
+			Command:     step.Command,
+			Status:      statusPending,
+		}
+	}
+	return steps
+}
+
+// describeSOP builds the one-line browser description for an SOP
+// Example: "Backup Database · dba-team · v1.2 · #database #backup · high"
+func describeSOP(sop *types.SOP, fallback string) string {
+	parts := []string{fallback}
+	if sop.Title != "" {
+		parts[0] = sop.Title
+	}
+	if sop.Owner != "" {
+		parts = append(parts, sop.Owner)
+	}
+	if sop.Version != "" {
+		parts = append(parts, "v"+strings.TrimPrefix(sop.Version, "v"))
+	}
+	if len(sop.Tags) > 0 {
+		parts = append(parts, "#"+strings.Join(sop.Tags, " #"))
+	}
+	if sop.Severity != "" {
+		parts = append(parts, sop.Severity)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// homePath returns where browsing starts: the list of roots when several
+// are configured, otherwise the only root
+func (m model) homePath() string {
+	if len(m.config.Roots) > 1 {
+		return rootListPath
+	}
+	return m.config.BaseDirectory
+}
+
+// parentPath returns the directory above dir, without leaving the SOP roots
+// The top of a root leads back to the root list when there is one
+func (m model) parentPath(dir string) (string, bool) {
+	if dir == rootListPath {
+		return "", false
+	}
+	for _, root := range m.config.Roots {
+		if dir == root.Path {
+			if len(m.config.Roots) > 1 {
+				return rootListPath, true
+			}
+			return "", false
+		}
+	}
+	parent := filepath.Dir(dir)
+	if parent == dir { // Filesystem root
+		return "", false
+	}
+	return parent, true
+}
+
+// loadSOP parses an SOP and records which root it belongs to
+func (m model) loadSOP(path string) (*types.SOP, error) {
+	sop, err := parser.ParseSOP(path, m.config.Interpreters)
+	if err != nil {
+		return nil, err
+	}
+	if root, ok := m.config.RootFor(path); ok {
+		sop.Root = root.Name
+		sop.ReadOnly = root.ReadOnly
+	}
+	return sop, nil
+}
+
+// buildRootList builds the top level list of configured SOP roots
+func (m model) buildRootList() list.Model {
+	var items []list.Item
+	for _, root := range m.config.Roots {
+		desc := root.Path
+		if root.ReadOnly {
+			desc += " · read-only"
+		}
+		items = append(items, item{
+			title:    root.Name + "/",
+			desc:     desc,
+			filePath: root.Path,
+			isDir:    true,
+		})
+	}
+
+	l := list.New(items, list.NewDefaultDelegate(), 0, 10)
+	l.Title = ""
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	return l
+}
+
 // buildFileList builds a list of files and directories
 func (m model) buildFileList(dir string) list.Model {
+	if dir == rootListPath {
+		return m.buildRootList()
+	}
+
 	// Read directory contents
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		// If error, go back to default directory
-		dir = config.DefaultBaseDirectory()
+		dir = m.config.BaseDirectory
 		entries, err = os.ReadDir(dir)
 		if err != nil {
 			// Last resort - empty list
@@ -110,10 +240,8 @@ func (m model) buildFileList(dir string) list.Model {
 
 	var items []list.Item
 
-	// Add parent directory if not at the base directory
-	defaultDir := config.DefaultBaseDirectory()
-	if dir != defaultDir && dir != filepath.Dir(dir) { // Not at default or filesystem root
-		parentDir := filepath.Dir(dir)
+	// Add parent directory if not at the top of a root
+	if parentDir, ok := m.parentPath(dir); ok {
 		items = append(items, item{
 			title:    "../",
 			desc:     "Parent directory",
@@ -139,15 +267,15 @@ func (m model) buildFileList(dir string) list.Model {
 				isDir:    true,
 			})
 		} else if strings.HasSuffix(strings.ToLower(name), ".md") {
-			// Try to parse title from SOP
-			title := name
-			sop, err := parser.ParseSOP(path)
-			if err == nil && sop.Title != "" {
-				title = sop.Title
+			// Try to parse title and metadata from SOP
+			desc := name
+			sop, err := m.loadSOP(path)
+			if err == nil {
+				desc = describeSOP(sop, name)
 			}
 			items = append(items, item{
 				title:    name,
-				desc:     title,
+				desc:     desc,
 				filePath: path,
 				isDir:    false,
 			})
@@ -167,7 +295,7 @@ func (m model) buildLogList(dir string) list.Model {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		// If error, use log directory from config
-		cfg := config.GetConfig()
+		cfg := m.config
 		entries, err = os.ReadDir(cfg.LogDirectory)
 		if err != nil {
 			// Last resort - empty list
@@ -178,7 +306,7 @@ func (m model) buildLogList(dir string) list.Model {
 	var items []list.Item
 
 	// Add parent directory if not at the root log directory
-	cfg := config.GetConfig()
+	cfg := m.config
 	if dir != cfg.LogDirectory && dir != filepath.Dir(dir) { // Not at log root or filesystem root
 		parentDir := filepath.Dir(dir)
 		items = append(items, item{
@@ -238,10 +366,10 @@ func (m model) buildLogList(dir string) list.Model {
 	// Add directories first (sorted)
 	for _, dirEntry := range dirs {
 		name := dirEntry.entry.Name()
-		
+
 		// Better description for SOP directories
 		desc := "SOP directory"
-		
+
 		path := filepath.Join(dir, name)
 		items = append(items, item{
 			title:    name + "/",
@@ -254,7 +382,7 @@ func (m model) buildLogList(dir string) list.Model {
 	// Add files (sorted)
 	for _, fileEntry := range files {
 		name := fileEntry.entry.Name()
-		
+
 		// Extract date and timestamp from filename for better description
 		desc := "Execution log"
 		// Try to extract date and timestamp from filename like "sop-name_DD-MM-YYYY_HH-MM-SS.log.md"
@@ -262,16 +390,16 @@ func (m model) buildLogList(dir string) list.Model {
 		if len(parts) >= 3 {
 			// Format should be: sop-name_date_timestamp
 			if len(parts) >= 2 {
-				date := parts[len(parts)-2] // Second to last part should be the date
+				date := parts[len(parts)-2]      // Second to last part should be the date
 				timestamp := parts[len(parts)-1] // Last part should be the timestamp
 				if len(date) >= 10 && date[2] == '-' && date[5] == '-' &&
-				   len(timestamp) >= 8 && timestamp[2] == '-' && timestamp[5] == '-' {
-					// Looks like a date DD-MM-YYYY and timestamp HH-MM-SS
-					desc = fmt.Sprintf("Execution: %s %s", date, timestamp)
+					len(timestamp) >= 8 && timestamp[2] == '-' && timestamp[5] == '-' {
+					// Looks like a date DD-MM-YYYY and timestamp HH-MM-SS, maybe followed by the run ID's suffix
+					desc = fmt.Sprintf("Execution: %s %s", date, timestamp[:8])
 				}
 			}
 		}
-		
+
 		path := filepath.Join(dir, name)
 		items = append(items, item{
 			title:    name,
@@ -307,6 +435,8 @@ func (m model) getModeContext() string {
 		return "Logs"
 	case modeEdit:
 		return "Edit"
+	case modeVars:
+		return "Variables"
 	default:
 		return ""
 	}
@@ -316,6 +446,9 @@ func (m model) getModeContext() string {
 func (m model) getPathContext() string {
 	switch m.mode {
 	case modeBrowse:
+		if m.currentPath == rootListPath {
+			return "SOP roots"
+		}
 		return m.currentPath
 	case modeExecute:
 		if m.sop != nil {
@@ -334,6 +467,11 @@ func (m model) getPathContext() string {
 		return m.currentPath
 	case modeEdit:
 		return fmt.Sprintf("Step %d/%d", m.currentStep+1, len(m.steps))
+	case modeVars:
+		if m.varSOP != nil {
+			return m.varSOP.Title
+		}
+		return ""
 	default:
 		return ""
 	}
@@ -343,13 +481,15 @@ func (m model) getPathContext() string {
 func (m model) getHelpText() string {
 	switch m.mode {
 	case modeBrowse:
-		return "↑↓ nav · ←/bs back · enter select · h home · l logs · q quit"
+		return "↑↓ nav · ←/bs back · enter select · o open · h home · l logs · q quit"
 	case modeExecute:
-		return "↑↓ nav · enter run · e edit · s skip · l logs · q back"
+		return m.keys.executeHelp()
 	case modeLogs:
-		return "↑↓ nav · ←/bs back · enter select · q back"
+		return "↑↓ nav · ←/bs back · enter select · r resume · q back"
 	case modeEdit:
-		return "enter save · esc cancel"
+		return m.editHelp()
+	case modeVars:
+		return "tab/↑↓ field · enter next/run · esc cancel"
 	default:
 		return "q quit"
 	}
@@ -0,0 +1,67 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+
+	"opsy/internal/config"
+)
+
+// keyMap holds the configurable execute mode key bindings
+type keyMap struct {
+	Run      key.Binding
+	RunAll   key.Binding
+	Cancel   key.Binding
+	Edit     key.Binding
+	Skip     key.Binding
+	Logs     key.Binding
+	Back     key.Binding
+	Save     key.Binding
+	Open     key.Binding
+	Rollback key.Binding
+}
+
+// newKeyMap builds the key bindings from the configured action -> keys map
+// Actions missing from bindings keep their defaults
+func newKeyMap(bindings map[string]string) keyMap {
+	defaults := config.DefaultKeybindings()
+	binding := func(action, help string) key.Binding {
+		keys := bindings[action]
+		if keys == "" {
+			keys = defaults[action]
+		}
+		names := config.SplitKeys(keys)
+		for i, name := range names {
+			if name == "space" {
+				names[i] = " " // Bubble Tea reports the space bar as " "
+			}
+		}
+		return key.NewBinding(
+			key.WithKeys(names...),
+			key.WithHelp(config.SplitKeys(keys)[0], help),
+		)
+	}
+
+	return keyMap{
+		Run:      binding("run", "run"),
+		RunAll:   binding("run_all", "run all"),
+		Cancel:   binding("cancel", "cancel"),
+		Edit:     binding("edit", "edit"),
+		Skip:     binding("skip", "skip"),
+		Logs:     binding("logs", "logs"),
+		Back:     binding("back", "back"),
+		Save:     binding("save", "save to SOP"),
+		Open:     binding("open", "open SOP"),
+		Rollback: binding("rollback", "roll back"),
+	}
+}
+
+// executeHelp returns the execute mode help text for the current bindings
+func (k keyMap) executeHelp() string {
+	parts := []string{"↑↓ nav"}
+	for _, b := range []key.Binding{k.Run, k.RunAll, k.Cancel, k.Edit, k.Save, k.Open, k.Skip, k.Rollback, k.Logs, k.Back} {
+		parts = append(parts, b.Help().Key+" "+b.Help().Desc)
+	}
+	return strings.Join(parts, " · ")
+}
@@ -2,18 +2,29 @@ package tui
 
 import (
 	"fmt"
+	"os"
 	"strings"
+
+	"opsy/internal/logger"
+	"opsy/internal/types"
 )
 
 // LogStep represents a parsed step from a log file
 type LogStep struct {
-	StepNumber  int
-	Title       string
-	Command     string
-	Status      string
-	ExecutedAt  string
-	Output      string
-	HasOutput   bool
+	StepNumber      int
+	Title           string
+	Command         string
+	Status          string
+	ExecutedAt      string
+	Output          string
+	HasOutput       bool
+	Policy          string   // Why the command policy denied the step
+	Modified        bool     // The command was edited during the run
+	OriginalCommand string   // SOP command before the edit
+	Manual          bool     // Checklist item performed by the operator
+	Note            string   // Operator's note on a manual step
+	Mismatches      []string // Expectations the result did not meet
+	Rollback        bool     // Rollback block of the step, run after a failure
 }
 
 // LogMetadata represents the header metadata from a log file
@@ -25,38 +36,114 @@ type LogMetadata struct {
 	StartedAt  string
 	EndedAt    string
 	Status     string
+	Variables  string
+	Host       string
+	WorkingDir string
+	Version    string   // opsy version
+	Checksum   string   // SOP file checksum
+	Revision   string   // SOP git commit
+	Deviations []string // Where the run diverged from the SOP
+}
+
+// LoadLogFile reads a run log, preferring its JSON sidecar and falling back
+// to parsing the markdown for logs written before sidecars existed (or whose
+// sidecar cannot be read)
+func LoadLogFile(path string) (LogMetadata, []LogStep, error) {
+	if logFile, err := logger.ReadLogFile(path); err == nil {
+		metadata, steps := convertLogFile(logFile)
+		return metadata, steps, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return LogMetadata{}, nil, err
+	}
+	metadata, steps := ParseLogFile(string(content))
+	return metadata, steps, nil
+}
+
+// convertLogFile converts a structured log into the view model used for
+// markdown logs, with the same labels the markdown log shows
+func convertLogFile(logFile *types.LogFile) (LogMetadata, []LogStep) {
+	metadata := LogMetadata{
+		Title:      logFile.Title,
+		RunID:      logFile.SOPRunID,
+		SOPPath:    logFile.OriginalSOP,
+		ExecutedBy: logger.OperatorLabel(logFile.ExecutedBy, logFile.Context),
+		Host:       logFile.Context.Hostname,
+		WorkingDir: logFile.Context.WorkingDir,
+		Version:    logFile.Context.OpsyVersion,
+		Checksum:   logFile.Context.SOPChecksum,
+		Revision:   logger.RevisionLabel(logFile.Context),
+		StartedAt:  logFile.StartedAt.Format(logTimeFormat),
+		Status:     logger.RunStatusLabel(logFile.Status),
+		Variables:  logger.FormatVariables(logFile.Variables),
+	}
+	if !logFile.EndedAt.IsZero() {
+		metadata.EndedAt = logFile.EndedAt.Format(logTimeFormat)
+	}
+	for _, deviation := range logFile.Deviations {
+		metadata.Deviations = append(metadata.Deviations, logger.DeviationLabel(deviation))
+	}
+
+	steps := make([]LogStep, 0, len(logFile.Steps)+len(logFile.Rollbacks))
+	for i, step := range append(logFile.Steps, logFile.Rollbacks...) {
+		logStep := LogStep{
+			StepNumber:      step.StepID,
+			Title:           step.OriginalStep.Title,
+			Command:         step.Command,
+			Output:          step.Output,
+			HasOutput:       step.Output != "",
+			Modified:        step.Modified,
+			OriginalCommand: step.OriginalCommand,
+			Manual:          step.OriginalStep.Manual,
+			Rollback:        i >= len(logFile.Steps),
+		}
+		if step.ExecutionResult != nil {
+			logStep.Status = logger.ResultLabel(step.OriginalStep, step.ResultStatus)
+			logStep.ExecutedAt = step.ExecutedAt.Format(logTimeFormat)
+			logStep.Note = step.ExecutionResult.Note
+			logStep.Mismatches = step.ExecutionResult.Mismatches
+			if step.ExecutionResult.PolicyRule != "" {
+				logStep.Policy = step.ExecutionResult.Error
+			}
+		}
+		steps = append(steps, logStep)
+	}
+	return metadata, steps
 }
 
 // ParseLogFile parses a log markdown file into structured data
 func ParseLogFile(content string) (LogMetadata, []LogStep) {
 	lines := strings.Split(content, "\n")
-	
+
 	metadata := parseLogMetadata(lines)
+	metadata.Deviations = parseLogDeviations(lines)
 	steps := parseLogSteps(lines)
-	
+
 	return metadata, steps
 }
 
 // parseLogMetadata extracts metadata from the log file header
 func parseLogMetadata(lines []string) LogMetadata {
 	metadata := LogMetadata{}
-	
+
 	for i, line := range lines {
 		// Stop at first step header
 		if strings.HasPrefix(line, "## Step ") {
 			break
 		}
-		
+
 		// Parse title (# Title)
 		if strings.HasPrefix(line, "# ") {
 			metadata.Title = strings.TrimPrefix(line, "# ")
 			continue
 		}
-		
+
 		// Parse metadata lines (> **Key:** Value)
 		if strings.HasPrefix(line, "> **") {
 			metaLine := strings.TrimPrefix(line, "> ")
-			
+
 			if strings.Contains(metaLine, "**SOP Run ID:**") {
 				// Extract value after "**SOP Run ID:**"
 				value := strings.TrimPrefix(metaLine, "**SOP Run ID:**")
@@ -76,18 +163,52 @@ func parseLogMetadata(lines []string) LogMetadata {
 			} else if strings.Contains(metaLine, "**Status:**") {
 				value := strings.TrimPrefix(metaLine, "**Status:**")
 				metadata.Status = strings.TrimSpace(value)
+			} else if strings.Contains(metaLine, "**Variables:**") {
+				value := strings.TrimPrefix(metaLine, "**Variables:**")
+				metadata.Variables = strings.TrimSpace(value)
+			} else if strings.Contains(metaLine, "**Host:**") {
+				value := strings.TrimPrefix(metaLine, "**Host:**")
+				metadata.Host = strings.TrimSpace(value)
+			} else if strings.Contains(metaLine, "**Working directory:**") {
+				value := strings.TrimPrefix(metaLine, "**Working directory:**")
+				metadata.WorkingDir = strings.TrimSpace(value)
+			} else if strings.Contains(metaLine, "**opsy version:**") {
+				value := strings.TrimPrefix(metaLine, "**opsy version:**")
+				metadata.Version = strings.TrimSpace(value)
+			} else if strings.Contains(metaLine, "**SOP checksum:**") {
+				value := strings.TrimPrefix(metaLine, "**SOP checksum:**")
+				metadata.Checksum = strings.TrimSpace(value)
+			} else if strings.Contains(metaLine, "**SOP revision:**") {
+				value := strings.TrimPrefix(metaLine, "**SOP revision:**")
+				metadata.Revision = strings.TrimSpace(value)
 			}
 		}
-		
+
 		// Safety check to avoid infinite loop
 		if i > 50 {
 			break
 		}
 	}
-	
+
 	return metadata
 }
 
+// parseLogDeviations extracts the items of the "## Deviations" section
+func parseLogDeviations(lines []string) []string {
+	var deviations []string
+	inSection := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "## ") {
+			inSection = line == "## Deviations"
+			continue
+		}
+		if inSection && strings.HasPrefix(line, "- ") {
+			deviations = append(deviations, strings.TrimPrefix(line, "- "))
+		}
+	}
+	return deviations
+}
+
 // parseLogSteps extracts all steps from the log file
 func parseLogSteps(lines []string) []LogStep {
 	var steps []LogStep
@@ -95,27 +216,35 @@ func parseLogSteps(lines []string) []LogStep {
 	inCodeBlock := false
 	inOutputBlock := false
 	outputBlockStarted := false // Track if we've seen the opening ```
+	inOriginalBlock := false    // The quoted block holds the original command, not output
 	var outputLines []string
-	
+
 	for i := 0; i < len(lines); i++ {
 		line := lines[i]
-		
-		// Detect step header (## Step N: Title)
-		if strings.HasPrefix(line, "## Step ") {
+
+		// Detect step header (## Step N: Title) and rollback header
+		// (## Rollback of Step N: Title); other sections such as
+		// "## Deviations" end the steps
+		if strings.HasPrefix(line, "## ") {
 			// Save previous step if exists (including any remaining output)
 			if currentStep != nil {
 				// Save any remaining output that wasn't closed properly
-				if len(outputLines) > 0 && !currentStep.HasOutput {
+				if len(outputLines) > 0 && !currentStep.HasOutput && !inOriginalBlock {
 					currentStep.Output = strings.Join(outputLines, "\n")
 					currentStep.HasOutput = true
 				}
 				steps = append(steps, *currentStep)
+				currentStep = nil
+			}
+			rollback := strings.HasPrefix(line, "## Rollback of Step ")
+			if !strings.HasPrefix(line, "## Step ") && !rollback {
+				continue
 			}
-			
+
 			// Start new step
-			stepHeader := strings.TrimPrefix(line, "## ")
-			currentStep = &LogStep{}
-			
+			stepHeader := strings.TrimPrefix(strings.TrimPrefix(line, "## "), "Rollback of ")
+			currentStep = &LogStep{Rollback: rollback}
+
 			// Parse "Step N: Title"
 			parts := strings.SplitN(stepHeader, ": ", 2)
 			if len(parts) >= 1 {
@@ -128,17 +257,18 @@ func parseLogSteps(lines []string) []LogStep {
 			if len(parts) >= 2 {
 				currentStep.Title = parts[1]
 			}
-			
+
 			outputLines = []string{}
 			inOutputBlock = false
 			outputBlockStarted = false
+			inOriginalBlock = false
 			continue
 		}
-		
+
 		if currentStep == nil {
 			continue
 		}
-		
+
 		// Handle code blocks (```bash or ```)
 		if strings.HasPrefix(line, "```") {
 			if !inCodeBlock {
@@ -148,40 +278,86 @@ func parseLogSteps(lines []string) []LogStep {
 			}
 			continue
 		}
-		
-		// Collect command inside code block
+
+		// Collect command inside code block (may span several lines)
 		if inCodeBlock {
-			currentStep.Command = line
+			if currentStep.Command != "" {
+				currentStep.Command += "\n"
+			}
+			currentStep.Command += line
 			continue
 		}
-		
+
 		// Handle metadata lines (> **Key:** Value)
 		if strings.HasPrefix(line, "> **") {
 			metaLine := strings.TrimPrefix(line, "> ")
-			
+
 			// Check for Output marker
 			if strings.Contains(metaLine, "**Output:**") {
 				inOutputBlock = true
 				outputBlockStarted = false // Haven't seen opening ``` yet
+				inOriginalBlock = false
 				outputLines = []string{}
 				continue
 			}
-			
+
+			// The original command of an edited step is quoted like output
+			if strings.Contains(metaLine, "**Original command:**") {
+				inOutputBlock = true
+				outputBlockStarted = false
+				inOriginalBlock = true
+				outputLines = []string{}
+				continue
+			}
+
+			// Edited steps are flagged as modified
+			if strings.Contains(metaLine, "**Modified:**") {
+				currentStep.Modified = true
+				continue
+			}
+
+			// Manual steps are marked instead of having a command
+			if strings.Contains(metaLine, "**Manual step:**") {
+				currentStep.Manual = true
+				continue
+			}
+
+			// Parse the expectations the step did not meet
+			if strings.Contains(metaLine, "**Expectation failed:**") {
+				value := strings.TrimPrefix(metaLine, "**Expectation failed:**")
+				currentStep.Mismatches = append(currentStep.Mismatches, strings.TrimSpace(value))
+				continue
+			}
+
+			// Parse the operator's note on a manual step
+			if strings.Contains(metaLine, "**Note:**") {
+				value := strings.TrimPrefix(metaLine, "**Note:**")
+				currentStep.Note = strings.TrimSpace(value)
+				continue
+			}
+
 			// Parse Executed timestamp
 			if strings.Contains(metaLine, "**Executed:**") {
 				value := strings.TrimPrefix(metaLine, "**Executed:**")
 				currentStep.ExecutedAt = strings.TrimSpace(value)
 				continue
 			}
-			
+
 			// Parse Result status
 			if strings.Contains(metaLine, "**Result:**") {
 				value := strings.TrimPrefix(metaLine, "**Result:**")
 				currentStep.Status = strings.TrimSpace(value)
 				continue
 			}
+
+			// Parse the policy decision of a denied step
+			if strings.Contains(metaLine, "**Policy:**") {
+				value := strings.TrimPrefix(metaLine, "**Policy:**")
+				currentStep.Policy = strings.TrimSpace(value)
+				continue
+			}
 		}
-		
+
 		// Handle output content when in output block
 		if inOutputBlock {
 			// Check for ``` markers
@@ -191,17 +367,21 @@ func parseLogSteps(lines []string) []LogStep {
 					outputBlockStarted = true
 				} else {
 					// This is the closing ``` - save and exit
-					if len(outputLines) > 0 {
+					if inOriginalBlock {
+						currentStep.OriginalCommand = strings.Join(outputLines, "\n")
+						outputLines = []string{}
+					} else if len(outputLines) > 0 {
 						currentStep.Output = strings.Join(outputLines, "\n")
 						currentStep.HasOutput = true
 						outputLines = []string{}
 					}
 					inOutputBlock = false
 					outputBlockStarted = false
+					inOriginalBlock = false
 				}
 				continue
 			}
-			
+
 			// Only collect lines after we've seen the opening ```
 			if outputBlockStarted {
 				// Collect output line
@@ -216,15 +396,15 @@ func parseLogSteps(lines []string) []LogStep {
 			continue
 		}
 	}
-	
+
 	// Save last step
 	if currentStep != nil {
-		if len(outputLines) > 0 {
+		if len(outputLines) > 0 && !inOriginalBlock {
 			currentStep.Output = strings.Join(outputLines, "\n")
 			currentStep.HasOutput = true
 		}
 		steps = append(steps, *currentStep)
 	}
-	
+
 	return steps
 }
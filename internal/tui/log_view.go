@@ -26,17 +26,41 @@ func (m model) renderLogExecutionView() (content string, currentStepLine int) {
 
 	// Metadata section
 	metaStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("250")).
+		Foreground(colorMuted).
 		PaddingLeft(4)
 
 	if m.logMetadata.ExecutedBy != "" {
-		builder.WriteString(metaStyle.Render(fmt.Sprintf("Executed by: %s", m.logMetadata.ExecutedBy)) + "\n")
+		executedBy := m.logMetadata.ExecutedBy
+		if m.logMetadata.Host != "" {
+			executedBy += " on " + m.logMetadata.Host
+		}
+		builder.WriteString(metaStyle.Render(fmt.Sprintf("Executed by: %s", executedBy)) + "\n")
+		lineCount++
+	}
+	if m.logMetadata.WorkingDir != "" {
+		builder.WriteString(metaStyle.Render(fmt.Sprintf("Working directory: %s", m.logMetadata.WorkingDir)) + "\n")
+		lineCount++
+	}
+	if m.logMetadata.Revision != "" {
+		builder.WriteString(metaStyle.Render(fmt.Sprintf("SOP revision: %s", m.logMetadata.Revision)) + "\n")
+		lineCount++
+	}
+	if m.logMetadata.Checksum != "" {
+		builder.WriteString(metaStyle.Render(fmt.Sprintf("SOP checksum: %s", m.logMetadata.Checksum)) + "\n")
+		lineCount++
+	}
+	if m.logMetadata.Version != "" {
+		builder.WriteString(metaStyle.Render(fmt.Sprintf("opsy version: %s", m.logMetadata.Version)) + "\n")
 		lineCount++
 	}
 	if m.logMetadata.StartedAt != "" {
 		builder.WriteString(metaStyle.Render(fmt.Sprintf("Started: %s", m.logMetadata.StartedAt)) + "\n")
 		lineCount++
 	}
+	if m.logMetadata.Variables != "" {
+		builder.WriteString(metaStyle.Render(fmt.Sprintf("Variables: %s", m.logMetadata.Variables)) + "\n")
+		lineCount++
+	}
 	if m.logMetadata.Status != "" {
 		statusStyle := metaStyle.Copy()
 		if strings.Contains(m.logMetadata.Status, "✅") || strings.Contains(m.logMetadata.Status, "Success") {
@@ -50,10 +74,14 @@ func (m model) renderLogExecutionView() (content string, currentStepLine int) {
 	builder.WriteString("\n")
 	lineCount++
 
-	// Progress indicator
-	totalSteps := len(m.logSteps)
+	// Progress indicator; rollback entries are not steps of the SOP
+	totalSteps := 0
 	completedSteps := 0
 	for _, step := range m.logSteps {
+		if step.Rollback {
+			continue
+		}
+		totalSteps++
 		if strings.Contains(step.Status, "✅") || strings.Contains(step.Status, "Success") {
 			completedSteps++
 		}
@@ -72,8 +100,12 @@ func (m model) renderLogExecutionView() (content string, currentStepLine int) {
 
 		isCurrent := i == m.currentLogStep
 
-		// Step header
-		stepHeader := renderStepHeader(step.StepNumber, step.Title, isCurrent)
+		// Step header; rollback entries name the step they undo
+		title := step.Title
+		if step.Rollback {
+			title = "↩ Rollback of " + title
+		}
+		stepHeader := renderStepHeader(step.StepNumber, title, isCurrent)
 		builder.WriteString(stepHeader + "\n")
 		lineCount++
 
@@ -82,13 +114,34 @@ func (m model) renderLogExecutionView() (content string, currentStepLine int) {
 		builder.WriteString(statusBadge + "\n\n")
 		lineCount += 2
 
-		// Command block
-		if step.Command != "" {
+		// Command block; manual steps have none
+		if step.Manual {
+			manualStyle := lipgloss.NewStyle().
+				Foreground(colorSecondary).
+				PaddingLeft(4)
+			builder.WriteString(manualStyle.Render("✋ Manual step, performed by the operator") + "\n\n")
+			lineCount += 2
+		} else if step.Command != "" {
 			cmdBlock := renderCommandBlock(step.Command, m.width)
 			builder.WriteString(cmdBlock)
 			lineCount += strings.Count(cmdBlock, "\n")
 		}
 
+		// Edited steps show the command from the SOP as well
+		if step.Modified {
+			modifiedStyle := lipgloss.NewStyle().
+				Foreground(colorWarning).
+				Bold(true).
+				PaddingLeft(4)
+			builder.WriteString(modifiedStyle.Render("⚠ Modified: the command above was edited during the run") + "\n")
+			lineCount++
+			if step.OriginalCommand != "" {
+				originalBlock := renderLabeledCommandBlock("Original command:", step.OriginalCommand, m.width)
+				builder.WriteString(originalBlock)
+				lineCount += strings.Count(originalBlock, "\n")
+			}
+		}
+
 		// Execution timestamp
 		if step.ExecutedAt != "" {
 			timeStyle := lipgloss.NewStyle().
@@ -98,6 +151,28 @@ func (m model) renderLogExecutionView() (content string, currentStepLine int) {
 			lineCount += 2
 		}
 
+		// Policy rule that denied the step
+		if step.Policy != "" {
+			policyStyle := lipgloss.NewStyle().
+				Foreground(colorError).
+				PaddingLeft(4)
+			builder.WriteString(policyStyle.Render(step.Policy) + "\n\n")
+			lineCount += 2
+		}
+
+		// Expectations the result did not meet
+		if mismatches := renderMismatches(step.Mismatches, m.width); mismatches != "" {
+			builder.WriteString(mismatches)
+			lineCount += strings.Count(mismatches, "\n")
+		}
+
+		// Operator's note on a manual step
+		if step.Note != "" {
+			noteBlock := renderNote(step.Note, m.width)
+			builder.WriteString(noteBlock)
+			lineCount += strings.Count(noteBlock, "\n")
+		}
+
 		// Output section
 		if step.HasOutput && step.Output != "" {
 			outputBlock := renderOutputBlock(step.Output, m.width, 10)
@@ -113,6 +188,10 @@ func (m model) renderLogExecutionView() (content string, currentStepLine int) {
 		}
 	}
 
+	if summary := renderDeviations(m.logMetadata.Deviations, m.width); summary != "" {
+		builder.WriteString("\n" + summary)
+	}
+
 	return builder.String(), currentStepLine
 }
 
@@ -0,0 +1,113 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// manualKeysHelp lists the answers to a manual step
+const manualKeysHelp = "d done, f failed, s skip, any other key to cancel"
+
+// promptManualStep asks the operator for the outcome of manual step index
+func (m *model) promptManualStep(index int) {
+	m.manualPending = true
+	m.manualAnswer = ""
+	m.manualNote.Reset()
+	m.manualNote.Blur()
+	m.status = fmt.Sprintf("Step %d is a manual step: %s", index+1, manualKeysHelp)
+	if m.runAll {
+		m.status = fmt.Sprintf("Run all paused: step %d is a manual step (%s)", index+1, manualKeysHelp)
+	}
+	m.updateViewportContent()
+}
+
+// handleManualKeys takes the answer to the current manual step, then an
+// optional note that is recorded with it on enter
+func (m *model) handleManualKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd) {
+	index := m.currentStep
+
+	if m.manualAnswer == "" {
+		switch msg.String() {
+		case "d":
+			m.manualAnswer = statusSuccess
+		case "f":
+			m.manualAnswer = statusError
+		case "s":
+			m.manualAnswer = statusSkipped
+		default:
+			m.manualPending = false
+			if m.runAll {
+				m.runAll = false
+				m.status = fmt.Sprintf("Run all stopped before step %d", index+1)
+			} else {
+				m.status = "Step not recorded"
+			}
+			m.updateViewportContent()
+			return *m, tea.Batch(cmds...)
+		}
+		m.status = fmt.Sprintf("Step %d %s: add a note (optional), enter to record, esc to change the answer", index+1, manualAnswerLabel(m.manualAnswer))
+		cmds = append(cmds, m.manualNote.Focus())
+		m.updateViewportContent()
+		return *m, tea.Batch(cmds...)
+	}
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.manualAnswer = ""
+		m.manualNote.Blur()
+		m.status = fmt.Sprintf("Step %d is a manual step: %s", index+1, manualKeysHelp)
+	case tea.KeyEnter:
+		cmds = append(cmds, m.recordManualStep(index)...)
+	default:
+		var cmd tea.Cmd
+		m.manualNote, cmd = m.manualNote.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+	m.updateViewportContent()
+	return *m, tea.Batch(cmds...)
+}
+
+// recordManualStep records the operator's answer and note for step index,
+// saves the log and, during a run-all, moves on unless the step failed
+func (m *model) recordManualStep(index int) []tea.Cmd {
+	step := &m.steps[index]
+	step.Status = m.manualAnswer
+	step.Note = strings.TrimSpace(m.manualNote.Value())
+	step.Output = ""
+	step.Error = ""
+	if step.Status == statusError {
+		step.Error = "Marked as failed by the operator"
+	}
+	step.ExecutedAt = time.Now()
+
+	m.manualPending = false
+	m.manualAnswer = ""
+	m.manualNote.Blur()
+	m.manualNote.Reset()
+	m.status = fmt.Sprintf("Step %d marked %s", index+1, manualAnswerLabel(step.Status))
+
+	cmds := []tea.Cmd{m.saveExecutionLog(false)}
+	if m.runAll {
+		// Skipping a checklist item is a decision, not a failure
+		if step.Status == statusSkipped {
+			cmds = append(cmds, m.runAllFrom(index+1)...)
+		} else {
+			cmds = append(cmds, m.advanceRunAll(index)...)
+		}
+	}
+	return cmds
+}
+
+// manualAnswerLabel describes the answer to a manual step, e.g. "done"
+func manualAnswerLabel(status string) string {
+	switch status {
+	case statusSuccess:
+		return "done"
+	case statusError:
+		return "failed"
+	}
+	return status
+}
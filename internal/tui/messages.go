@@ -12,8 +12,39 @@ type enterModeMsg struct {
 	status string
 	sop    *types.SOP
 	steps  []SOPStep
-	path   string // Path for context (used for logs filtering)
-	from   string // Previous mode when entering logs mode
+	path   string            // Path for context (used for logs filtering)
+	from   string            // Previous mode when entering logs mode
+	vars   map[string]string // Resolved variables to record for a new run
+
+	session *runSession // Run to continue instead of starting a new one
+	step    int         // Step to position on when entering execute mode
+}
+
+// stepFinishedMsg is sent when an asynchronously executed step completes
+type stepFinishedMsg struct {
+	seq    int
+	index  int
+	result *types.ExecutionResult
+	err    error
+}
+
+// stepOutputMsg carries one line of live output from a running step
+type stepOutputMsg struct {
+	seq    int
+	index  int
+	line   string
+	output <-chan string // Channel to keep listening on for further lines
+}
+
+// editorFinishedMsg is sent when the external editor opened on an SOP exits
+type editorFinishedMsg struct {
+	path string
+	err  error
+}
+
+// logSavedMsg is sent after an execution log has been written
+type logSavedMsg struct {
+	err error
 }
 
 // item represents a file/directory in the browser
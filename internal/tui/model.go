@@ -1,23 +1,41 @@
 package tui
 
 import (
+	"context"
 	"os"
 	"time"
 
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 
 	"opsy/internal/config"
+	"opsy/internal/executor"
+	"opsy/internal/parser"
+	"opsy/internal/policy"
 	"opsy/internal/types"
 )
 
 // ExecutorInterface defines the interface for command execution
 type ExecutorInterface interface {
-	ExecuteStep(step types.Step) (*types.ExecutionResult, error)
-	ValidateCommand(command string) error
+	ExecuteStepStream(ctx context.Context, step types.Step, onOutput func(line string)) (*types.ExecutionResult, error)
+	CheckCommand(command string) policy.Decision
+}
+
+// StepRunner executes a single step, streaming its output
+// Implemented by the executor and by persistent shell sessions
+type StepRunner interface {
+	ExecuteStepStream(ctx context.Context, step types.Step, onOutput func(line string)) (*types.ExecutionResult, error)
+}
+
+// ShellSessionStarter is implemented by executors that can run all steps of
+// a run in one persistent shell
+type ShellSessionStarter interface {
+	NewShellSession(language string) (*executor.ShellSession, error)
 }
 
 // LoggerInterface defines the interface for logging
@@ -27,14 +45,23 @@ type LoggerInterface interface {
 
 // SOPStep represents a step in the SOP with execution state
 type SOPStep struct {
-	ID          int
-	Title       string
-	Description string
-	Command     string
-	Status      string // "pending", "executed", "skipped", "error"
-	Output      string
-	Error       string
-	ExecutedAt  time.Time // When the step was executed
+	ID           int
+	Title        string
+	Description  string
+	Command      string
+	Status       string // "pending", "executed", "skipped", "error"
+	Output       string
+	Error        string
+	ExecutedAt   time.Time // When the step was executed
+	PolicyRule   string    // Command policy rule that denied the step
+	SavedCommand string    // Command last written back to the SOP file
+	Note         string    // Operator's note on a manual step
+	Mismatches   []string  // Expectations the last run did not meet
+
+	RollbackStatus string    // Result of the step's rollback block, "" until it ran
+	RollbackOutput string    // Output of the rollback block
+	RollbackError  string    // Why the rollback block failed
+	RolledBackAt   time.Time // When the rollback block ran
 }
 
 // model represents the application state
@@ -43,6 +70,8 @@ type model struct {
 	width, height int
 	mode          string
 	quitting      bool
+	config        *config.Config // Loaded once at startup
+	keys          keyMap         // Configurable execute mode bindings
 
 	// Browse mode
 	fileList    list.Model
@@ -52,22 +81,49 @@ type model struct {
 	sop           *types.SOP
 	steps         []SOPStep
 	currentStep   int
+	session       *runSession            // Current run, created when an SOP is opened
+	shell         *executor.ShellSession // Persistent shell for SOPs with execution: persistent
 	viewport      viewport.Model
 	viewportReady bool
 
+	// Running step state
+	running        bool               // A step is currently executing in the background
+	runningStep    int                // Index of the step being executed
+	runSeq         int                // Incremented per execution to discard stale output messages
+	cancelRun      context.CancelFunc // Cancels the running step
+	runStartedAt   time.Time          // When the running step was started
+	spinner        spinner.Model      // Spinner shown next to the running step
+	confirmPending bool               // Waiting for y before running a step that requires confirmation
+	confirmReason  string             // Why confirmation is required, shown in the prompt
+	runAll         bool               // Running all remaining steps one after another
+	manualPending  bool               // Waiting for the operator's answer to a manual step
+	manualAnswer   string             // Status chosen for the manual step, "" until answered
+	manualNote     textinput.Model    // Optional note recorded with the answer
+
+	// Rollback after a failed run
+	rollbackPending bool // Waiting for y before rolling back the succeeded steps
+	rollingBack     bool // Running the rollback blocks of succeeded steps in reverse
+
+	// Variables mode
+	varSOP    *types.SOP        // SOP waiting for its variable values
+	varInputs []textinput.Model // One input per declared variable
+	varErrors []string          // Validation error per variable
+	varFocus  int               // Index of the focused input
+
 	// Edit mode
-	textInput textinput.Model
-	textarea  textarea.Model
+	textarea     textarea.Model   // Multi-line command editor
+	editOriginal string           // Command when editing started, for the diff
+	editReview   bool             // Showing the diff before the edit is saved
+	sopEdit      *parser.StepEdit // Edit waiting for y before it is written to the SOP file
 
 	// Log mode
-	logList        list.Model
-	logViewPort    viewport.Model
-	logViewReady   bool
-	logViewContent string
-	logViewPath    string
-	sopPath        string // Store SOP path when entering logs mode
-	previousMode   string // Store previous mode when entering logs mode
-	
+	logList      list.Model
+	logViewPort  viewport.Model
+	logViewReady bool
+	logViewPath  string
+	sopPath      string // Store SOP path when entering logs mode
+	previousMode string // Store previous mode when entering logs mode
+
 	// Log execution view (similar to execute mode but read-only)
 	logMetadata    LogMetadata
 	logSteps       []LogStep
@@ -83,10 +139,14 @@ type model struct {
 }
 
 // NewModel creates a new TUI model
-func NewModel(executor ExecutorInterface, logger LoggerInterface) model {
-	// Initialize paths
-	defaultDir := config.DefaultBaseDirectory()
-	os.MkdirAll(defaultDir, 0755)
+func NewModel(executor ExecutorInterface, logger LoggerInterface, cfg *config.Config) model {
+	// Colors must be set before any styles are built
+	applyTheme(cfg.Theme)
+
+	// Initialize paths (never create directories in read-only roots)
+	if !cfg.IsReadOnly(cfg.BaseDirectory) {
+		os.MkdirAll(cfg.BaseDirectory, 0755)
+	}
 
 	// Create file list with initial items
 	fileList := list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 10)
@@ -106,29 +166,43 @@ func NewModel(executor ExecutorInterface, logger LoggerInterface) model {
 	logList.SetShowHelp(false)       // Disable list's help
 	logList.SetShowPagination(false) // Disable pagination info
 
-	// Create text input for editing
-	ti := textinput.New()
-	ti.Placeholder = "Enter command..."
-	ti.Focus()
-	ti.CharLimit = 256
-	ti.Width = 60
+	// Create multi-line editor for commands (newlines and \ continuations are kept)
+	ta := textarea.New()
+	ta.Placeholder = "Enter command..."
+	ta.CharLimit = 0 // No limit
+	ta.MaxHeight = 0 // No line limit
+
+	// Create input for notes on manual steps
+	note := textinput.New()
+	note.Placeholder = "What was done, or why it failed (optional)"
+	note.Prompt = "Note: "
+
+	// Create spinner for running steps
+	sp := spinner.New()
+	sp.Spinner = spinner.Dot
+	sp.Style = lipgloss.NewStyle().Foreground(colorAccent)
 
 	// Initialize the model
 	m := model{
 		mode:               modeBrowse,
-		currentPath:        defaultDir,
+		config:             cfg,
+		keys:               newKeyMap(cfg.Keybindings),
+		currentPath:        cfg.BaseDirectory,
 		fileList:           fileList,
 		logList:            logList,
 		logViewReady:       false,
 		executor:           executor,
 		logger:             logger,
-		textInput:          ti,
+		textarea:           ta,
+		manualNote:         note,
+		spinner:            sp,
 		status:             "Ready",
 		viewportReady:      false,
 		manualScrollActive: false,
 	}
 
 	// Build initial file list
+	m.currentPath = m.homePath()
 	m.fileList = m.buildFileList(m.currentPath)
 
 	return m
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+
+	"opsy/internal/parser"
+	"opsy/internal/types"
 )
 
 // renderStepHeader renders a step header with number and title
@@ -40,6 +44,11 @@ func renderStepHeader(stepNum int, title string, isCurrent bool) string {
 
 // renderCommandBlock renders a command in a styled box
 func renderCommandBlock(command string, width int) string {
+	return renderLabeledCommandBlock("Command:", command, width)
+}
+
+// renderLabeledCommandBlock renders a command in a styled box under a label
+func renderLabeledCommandBlock(label, command string, width int) string {
 	if command == "" {
 		return ""
 	}
@@ -50,10 +59,10 @@ func renderCommandBlock(command string, width int) string {
 		Foreground(colorAccent).
 		Bold(true).
 		PaddingLeft(4)
-	builder.WriteString(cmdLabelStyle.Render("Command:") + "\n")
+	builder.WriteString(cmdLabelStyle.Render(label) + "\n")
 
 	commandBoxStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("117")).
+		Foreground(colorCode).
 		Border(lipgloss.RoundedBorder()).
 		BorderForeground(lipgloss.Color("240")).
 		Padding(0, 1).
@@ -68,6 +77,11 @@ func renderCommandBlock(command string, width int) string {
 
 // renderOutputBlock renders output in a styled box
 func renderOutputBlock(output string, width int, maxLines int) string {
+	return renderLabeledOutputBlock("Output:", output, width, maxLines)
+}
+
+// renderLabeledOutputBlock renders output in a styled box under a label
+func renderLabeledOutputBlock(label, output string, width int, maxLines int) string {
 	if output == "" {
 		return ""
 	}
@@ -79,10 +93,10 @@ func renderOutputBlock(output string, width int, maxLines int) string {
 		Bold(true).
 		PaddingLeft(4)
 
-	builder.WriteString(outputLabelStyle.Render("Output:") + "\n")
+	builder.WriteString(outputLabelStyle.Render(label) + "\n")
 
 	outputBoxStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("252")).
+		Foreground(colorOutput).
 		Border(lipgloss.RoundedBorder()).
 		BorderForeground(lipgloss.Color("238")).
 		Padding(0, 1).
@@ -97,6 +111,11 @@ func renderOutputBlock(output string, width int, maxLines int) string {
 
 // renderErrorBlock renders error in a styled box
 func renderErrorBlock(errorMsg string, width int, maxLines int) string {
+	return renderLabeledErrorBlock("Error:", errorMsg, width, maxLines)
+}
+
+// renderLabeledErrorBlock renders error in a styled box under a label
+func renderLabeledErrorBlock(label, errorMsg string, width int, maxLines int) string {
 	if errorMsg == "" {
 		return ""
 	}
@@ -108,7 +127,7 @@ func renderErrorBlock(errorMsg string, width int, maxLines int) string {
 		Bold(true).
 		PaddingLeft(4)
 
-	builder.WriteString(errorLabelStyle.Render("Error:") + "\n")
+	builder.WriteString(errorLabelStyle.Render(label) + "\n")
 
 	errorBoxStyle := lipgloss.NewStyle().
 		Foreground(colorError).
@@ -129,7 +148,7 @@ func renderErrorBlock(errorMsg string, width int, maxLines int) string {
 func renderStatusBadge(status string, isCurrent bool, isExecuteMode bool) string {
 	// Normalize status to handle both formats
 	normalizedStatus := normalizeStatus(status)
-	
+
 	var badge string
 
 	switch normalizedStatus {
@@ -161,6 +180,27 @@ func renderStatusBadge(status string, isCurrent bool, isExecuteMode bool) string
 			Padding(0, 1).
 			Bold(true).
 			Render("⏰ TIMEOUT")
+	case "cancelled":
+		badge = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("0")).
+			Background(colorWarning).
+			Padding(0, 1).
+			Bold(true).
+			Render("■ CANCELLED")
+	case "denied":
+		badge = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("0")).
+			Background(colorError).
+			Padding(0, 1).
+			Bold(true).
+			Render("⛔ DENIED")
+	case "running":
+		badge = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("0")).
+			Background(colorAccent).
+			Padding(0, 1).
+			Bold(true).
+			Render("⟳ RUNNING")
 	default:
 		if isCurrent && isExecuteMode {
 			badge = lipgloss.NewStyle().
@@ -172,7 +212,7 @@ func renderStatusBadge(status string, isCurrent bool, isExecuteMode bool) string
 		} else {
 			badge = lipgloss.NewStyle().
 				Foreground(colorFaint).
-				Background(lipgloss.Color("235")).
+				Background(colorHighlight).
 				Padding(0, 1).
 				Render("○ PENDING")
 		}
@@ -189,6 +229,14 @@ func renderStatusBadge(status string, isCurrent bool, isExecuteMode bool) string
 	return "  " + badge
 }
 
+// renderRunningIndicator renders the spinner and elapsed time for a running step
+func renderRunningIndicator(spinnerView string, elapsed time.Duration) string {
+	elapsedStyle := lipgloss.NewStyle().
+		Foreground(colorFaint)
+
+	return "    " + spinnerView + " Running " + elapsedStyle.Render(formatElapsed(elapsed))
+}
+
 // renderTitleHeader renders a centered title with divider
 func renderTitleHeader(title string, width int) string {
 	var builder strings.Builder
@@ -211,6 +259,277 @@ func renderTitleHeader(title string, width int) string {
 	return builder.String()
 }
 
+// renderSOPMetadata renders the SOP description and front matter fields
+// Returns an empty string when the SOP has no metadata
+func renderSOPMetadata(sop *types.SOP, width int) string {
+	var builder strings.Builder
+
+	metaStyle := lipgloss.NewStyle().
+		Foreground(colorMuted).
+		PaddingLeft(2)
+
+	if sop.Description != "" {
+		wrapped := wrapText(sop.Description, width-8)
+		builder.WriteString(metaStyle.Render(wrapped) + "\n")
+	}
+
+	var fields []string
+	if sop.Owner != "" {
+		fields = append(fields, "Owner: "+sop.Owner)
+	}
+	if sop.Version != "" {
+		fields = append(fields, "Version: "+sop.Version)
+	}
+	if sop.Timeout > 0 {
+		fields = append(fields, "Timeout: "+sop.Timeout.String())
+	}
+	if sop.ExecutionMode == types.ExecutionPersistent {
+		fields = append(fields, "Shell: persistent")
+	}
+	if sop.ReadOnly {
+		fields = append(fields, "Root: "+sop.Root+" (read-only)")
+	}
+	if sop.Severity != "" {
+		severityStyle := lipgloss.NewStyle().Bold(true)
+		switch sop.Severity {
+		case "high", "critical":
+			severityStyle = severityStyle.Foreground(colorError)
+		case "medium":
+			severityStyle = severityStyle.Foreground(colorWarning)
+		default:
+			severityStyle = severityStyle.Foreground(colorSuccess)
+		}
+		fields = append(fields, "Severity: "+severityStyle.Render(sop.Severity))
+	}
+	if len(fields) > 0 {
+		builder.WriteString(metaStyle.Render(strings.Join(fields, " · ")) + "\n")
+	}
+	if len(sop.Tags) > 0 {
+		builder.WriteString(metaStyle.Render("Tags: "+strings.Join(sop.Tags, ", ")) + "\n")
+	}
+	if len(sop.RequiredTools) > 0 {
+		builder.WriteString(metaStyle.Render("Requires: "+strings.Join(sop.RequiredTools, ", ")) + "\n")
+	}
+
+	if builder.Len() == 0 {
+		return ""
+	}
+	builder.WriteString("\n")
+	return builder.String()
+}
+
+// renderStepAttributes renders the fence annotations of a step, e.g. confirm
+// or continue_on_error. The timeout is only shown when it overrides the SOP
+// default and the language only when the step is not shell code.
+func renderStepAttributes(step types.Step, sopTimeout time.Duration) string {
+	var fields []string
+	if step.Manual {
+		fields = append(fields, lipgloss.NewStyle().Foreground(colorAccent).Render("manual step"))
+	}
+	switch step.CommandType {
+	case "", "shell", "sh", "bash", "zsh":
+	default:
+		fields = append(fields, "runs with "+step.CommandType)
+	}
+	if step.Name != "" {
+		fields = append(fields, "id: "+step.Name)
+	}
+	if step.Timeout > 0 && step.Timeout != sopTimeout {
+		fields = append(fields, "timeout: "+step.Timeout.String())
+	}
+	if step.Confirm {
+		fields = append(fields, lipgloss.NewStyle().Foreground(colorWarning).Render("requires confirmation"))
+	}
+	if step.ContinueOnError {
+		fields = append(fields, "continues on error")
+	}
+
+	if step.OriginalCommand != "" && step.OriginalCommand != step.Command {
+		fields = append(fields, lipgloss.NewStyle().Foreground(colorWarning).Render("modified"))
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+
+	attrStyle := lipgloss.NewStyle().
+		Foreground(colorFaint).
+		PaddingLeft(4)
+	return attrStyle.Render(strings.Join(fields, " · ")) + "\n\n"
+}
+
+// renderStepSource marks a step spliced in by an include directive with the
+// file and line it comes from, relative to the SOP's directory
+func renderStepSource(step types.Step, sopPath string) string {
+	if step.Source == "" {
+		return ""
+	}
+	source := step.Source
+	if rel, err := filepath.Rel(filepath.Dir(sopPath), step.Source); err == nil {
+		source = rel
+	}
+
+	sourceStyle := lipgloss.NewStyle().
+		Foreground(colorSecondary).
+		PaddingLeft(4)
+	return sourceStyle.Render(fmt.Sprintf("⤷ included from %s:%d", source, step.LineNumber)) + "\n\n"
+}
+
+// renderReferences renders the reference code blocks that precede step index
+func renderReferences(blocks []types.CodeBlock, index int, width int) string {
+	var builder strings.Builder
+	for _, block := range blocks {
+		if block.BeforeStep != index {
+			continue
+		}
+		label := "Reference:"
+		if block.Language != "" {
+			label = "Reference (" + block.Language + "):"
+		}
+		builder.WriteString(renderLabeledCommandBlock(label, block.Content, width))
+	}
+	return builder.String()
+}
+
+// renderExpectations renders the assertions checked after a step has run
+func renderExpectations(expectations []types.Expectation, width int) string {
+	if len(expectations) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(expectations))
+	for _, expectation := range expectations {
+		lines = append(lines, parser.FormatExpectation(expectation))
+	}
+
+	labelStyle := lipgloss.NewStyle().
+		Foreground(colorAccent).
+		Bold(true).
+		PaddingLeft(4)
+	itemStyle := lipgloss.NewStyle().
+		Foreground(colorFaint).
+		PaddingLeft(6).
+		Width(width - 8)
+	return labelStyle.Render("Expect:") + "\n" + itemStyle.Render(strings.Join(lines, "\n")) + "\n\n"
+}
+
+// renderRollback renders the rollback block of a step, labeled with the
+// result of its last run
+func renderRollback(command, status string, width int) string {
+	label := "Rollback:"
+	switch status {
+	case "":
+	case statusRunning:
+		label += " ⟳ rolling back"
+	case statusSuccess:
+		label += " ✓ rolled back"
+	default:
+		label += " ✗ " + status
+	}
+	return renderLabeledCommandBlock(label, command, width)
+}
+
+// renderMismatches renders the expectations a step's result did not meet
+func renderMismatches(mismatches []string, width int) string {
+	if len(mismatches) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	labelStyle := lipgloss.NewStyle().
+		Foreground(colorError).
+		Bold(true).
+		PaddingLeft(4)
+	builder.WriteString(labelStyle.Render("Expectations not met:") + "\n")
+
+	itemStyle := lipgloss.NewStyle().
+		Foreground(colorError).
+		PaddingLeft(6).
+		Width(width - 8)
+	for _, mismatch := range mismatches {
+		builder.WriteString(itemStyle.Render("✗ "+mismatch) + "\n")
+	}
+	builder.WriteString("\n")
+	return builder.String()
+}
+
+// renderDeviations renders the summary of where a run diverged from the SOP
+func renderDeviations(deviations []string, width int) string {
+	if len(deviations) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	labelStyle := lipgloss.NewStyle().
+		Foreground(colorWarning).
+		Bold(true).
+		PaddingLeft(4)
+	builder.WriteString(labelStyle.Render("Deviations from the SOP:") + "\n")
+
+	itemStyle := lipgloss.NewStyle().
+		Foreground(colorMuted).
+		PaddingLeft(6).
+		Width(width - 8)
+	for _, deviation := range deviations {
+		builder.WriteString(itemStyle.Render("• "+deviation) + "\n")
+	}
+	builder.WriteString("\n")
+	return builder.String()
+}
+
+// renderConfirmPrompt renders the prompt shown while a step waits for confirmation
+func renderConfirmPrompt(reason string, width int) string {
+	promptStyle := lipgloss.NewStyle().
+		Foreground(colorWarning).
+		Bold(true).
+		PaddingLeft(4).
+		Width(width - 8)
+	return promptStyle.Render("⚠ This step requires "+reason+": press y to run, any other key to abort") + "\n\n"
+}
+
+// renderManualPrompt renders the prompt shown while a manual step waits for
+// the operator's answer and, once answered, the note input
+func renderManualPrompt(answer, noteInput string, width int) string {
+	promptStyle := lipgloss.NewStyle().
+		Foreground(colorWarning).
+		Bold(true).
+		PaddingLeft(4).
+		Width(width - 8)
+	if answer == "" {
+		return promptStyle.Render("✋ Perform this step by hand, then answer: "+manualKeysHelp) + "\n\n"
+	}
+	inputStyle := lipgloss.NewStyle().
+		PaddingLeft(4)
+	return promptStyle.Render("✋ Marking the step "+manualAnswerLabel(answer)+": enter to record, esc to change the answer") + "\n" +
+		inputStyle.Render(noteInput) + "\n\n"
+}
+
+// renderNote renders the operator's note on a manual step
+func renderNote(note string, width int) string {
+	if note == "" {
+		return ""
+	}
+	labelStyle := lipgloss.NewStyle().
+		Foreground(colorAccent).
+		Bold(true).
+		PaddingLeft(4)
+	noteStyle := lipgloss.NewStyle().
+		Foreground(colorMuted).
+		PaddingLeft(6).
+		Width(width - 8)
+	return labelStyle.Render("Note:") + "\n" + noteStyle.Render(wrapText(note, width-14)) + "\n\n"
+}
+
+// renderSavePrompt renders the diff of a code block about to be written back
+// to the SOP file, with the prompt to confirm it
+func renderSavePrompt(old, edited, file string, width int) string {
+	promptStyle := lipgloss.NewStyle().
+		Foreground(colorWarning).
+		Bold(true).
+		PaddingLeft(4).
+		Width(width - 8)
+	return renderCommandDiff(old, edited, width) + "\n" +
+		promptStyle.Render("⚠ Write this change to "+file+": press y to save, any other key to cancel") + "\n\n"
+}
+
 // renderProgressBar renders a progress bar with label
 func renderProgressBar(completed, total int, width int) string {
 	var builder strings.Builder
@@ -254,7 +573,7 @@ func renderStepSeparator(width int) string {
 // Handles both "success" and "✅ Success" formats
 func normalizeStatus(status string) string {
 	status = strings.TrimSpace(status)
-	
+
 	if strings.Contains(status, "✅") || strings.Contains(status, "Success") {
 		return "success"
 	}
@@ -267,7 +586,13 @@ func normalizeStatus(status string) string {
 	if strings.Contains(status, "⏰") || strings.Contains(status, "Timeout") {
 		return "timeout"
 	}
-	
+	if strings.Contains(status, "🛑") || strings.Contains(status, "Cancelled") {
+		return "cancelled"
+	}
+	if strings.Contains(status, "⛔") || strings.Contains(status, "Denied") {
+		return "denied"
+	}
+
 	return status // Return as-is if no match
 }
 
@@ -277,12 +602,12 @@ func extractSOPName(sopPath string) string {
 	if sopPath == "" {
 		return "Unknown"
 	}
-	
+
 	// Get base filename
 	base := filepath.Base(sopPath)
-	
+
 	// Remove .md extension
 	name := strings.TrimSuffix(base, ".md")
-	
+
 	return name
 }
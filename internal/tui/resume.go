@@ -0,0 +1,227 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"opsy/internal/logger"
+	"opsy/internal/parser"
+	"opsy/internal/types"
+)
+
+// logTimeFormat is the timestamp format used in markdown logs
+const logTimeFormat = "2006-01-02 15:04:05"
+
+// resumeRun reopens the SOP of a logged run with the step statuses and
+// outputs restored, positioned on the first step that did not succeed.
+// The run keeps its ID and start time, so saving rewrites the same log file.
+func (m *model) resumeRun(logPath string) tea.Cmd {
+	logFile, err := readRunLog(logPath)
+	if err != nil {
+		m.status = fmt.Sprintf("Error reading log file: %v", err)
+		return nil
+	}
+	if logFile.OriginalSOP == "" || logFile.SOPRunID == "" {
+		m.status = "Cannot resume: log has no Original SOP or run ID"
+		return nil
+	}
+
+	sop, err := m.loadSOP(logFile.OriginalSOP)
+	if err != nil {
+		m.status = fmt.Sprintf("Cannot resume: %v", err)
+		return nil
+	}
+
+	// Re-apply the variable values the run was started with
+	values, err := parser.ResolveVariables(sop.Variables, logFile.Variables)
+	if err != nil {
+		m.status = fmt.Sprintf("Cannot resume: %v", err)
+		return nil
+	}
+	parser.ApplyVariables(sop, values)
+
+	steps, changed := restoreSteps(sop, logFile)
+
+	// The run continues under the current operator and SOP revision
+	session := &runSession{
+		id:        logFile.SOPRunID,
+		startedAt: logFile.StartedAt,
+		sopPath:   sop.Path,
+		checksum:  sop.Checksum,
+		variables: values,
+	}
+
+	// Continue at the first step that still needs to run
+	current := 0
+	for i, step := range steps {
+		if step.Status != statusSuccess && step.Status != statusSkipped {
+			current = i
+			break
+		}
+		current = i
+	}
+
+	status := fmt.Sprintf("Resumed run %s at step %d", logFile.SOPRunID, current+1)
+	if changed > 0 {
+		status += fmt.Sprintf(" (%d changed step(s) not restored)", changed)
+	}
+
+	return func() tea.Msg {
+		return enterModeMsg{
+			mode:    modeExecute,
+			status:  status,
+			sop:     sop,
+			steps:   steps,
+			vars:    values,
+			session: session,
+			step:    current,
+		}
+	}
+}
+
+// readRunLog reads a run log to resume. The JSON sidecar is used as is,
+// logs without one are rebuilt from the markdown
+func readRunLog(path string) (*types.LogFile, error) {
+	if logFile, err := logger.ReadLogFile(path); err == nil {
+		return logFile, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return markdownLogFile(ParseLogFile(string(content)))
+}
+
+// markdownLogFile rebuilds the parts of a structured log needed to resume
+// from a parsed markdown log
+func markdownLogFile(metadata LogMetadata, logSteps []LogStep) (*types.LogFile, error) {
+	startedAt, err := time.ParseInLocation(logTimeFormat, metadata.StartedAt, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time %q", metadata.StartedAt)
+	}
+
+	logFile := &types.LogFile{
+		Title:       metadata.Title,
+		SOPRunID:    metadata.RunID,
+		OriginalSOP: metadata.SOPPath,
+		StartedAt:   startedAt,
+		Variables:   parseLogVariables(metadata.Variables),
+	}
+	for _, logStep := range logSteps {
+		step := types.LogStep{
+			StepID:          logStep.StepNumber,
+			Command:         logStep.Command,
+			Output:          logStep.Output,
+			OriginalStep:    types.Step{ID: logStep.StepNumber, Title: logStep.Title, Command: logStep.Command, Manual: logStep.Manual},
+			OriginalCommand: logStep.OriginalCommand,
+			Modified:        logStep.Modified,
+		}
+		if status := normalizeStatus(logStep.Status); status != "" {
+			step.ResultStatus = status
+			step.ExecutedAt, _ = time.ParseInLocation(logTimeFormat, logStep.ExecutedAt, time.Local)
+			step.ExecutionResult = &types.ExecutionResult{
+				ExecutedAt: step.ExecutedAt,
+				Status:     status,
+				Output:     logStep.Output,
+				Error:      logStep.Policy, // The only error the markdown log records
+				Note:       logStep.Note,
+				Mismatches: logStep.Mismatches,
+			}
+		}
+		if logStep.Rollback {
+			logFile.Rollbacks = append(logFile.Rollbacks, step)
+		} else {
+			logFile.Steps = append(logFile.Steps, step)
+		}
+	}
+	return logFile, nil
+}
+
+// restoreSteps builds the steps of sop with the statuses and outputs from a
+// log. Commands the operator edited during the run are edited again.
+// Steps whose command no longer matches the log are left pending; the number
+// of such steps is returned
+func restoreSteps(sop *types.SOP, logFile *types.LogFile) ([]SOPStep, int) {
+	steps := newSOPSteps(sop)
+	changed := 0
+	logSteps := append(append([]types.LogStep{}, logFile.Steps...), logFile.Rollbacks...)
+	for n, logStep := range logSteps {
+		i := logStep.StepID - 1
+		if i < 0 || i >= len(steps) {
+			changed++
+			continue
+		}
+		status := ""
+		if logStep.ExecutionResult != nil {
+			status = logStep.ResultStatus
+		}
+		edited := logStep.Modified && logStep.OriginalCommand != ""
+		if status == "" && !edited {
+			continue // Never run
+		}
+
+		// Rollback entries are restored when the step's rollback block is unchanged
+		if n >= len(logFile.Steps) {
+			rollback := sop.Steps[i].Rollback
+			if rollback == nil || strings.TrimSpace(logStep.Command) != strings.TrimSpace(rollback.Command) {
+				changed++
+				continue
+			}
+			steps[i].RollbackStatus = status
+			steps[i].RollbackOutput = logStep.Output
+			steps[i].RollbackError = logStep.ExecutionResult.Error
+			steps[i].RolledBackAt = logStep.ExecutedAt
+			continue
+		}
+
+		// Manual steps are matched by their title, they have no command
+		manual, title := logStep.OriginalStep.Manual, logStep.OriginalStep.Title
+		if manual != sop.Steps[i].Manual || (manual && title != sop.Steps[i].Title) {
+			changed++
+			continue
+		}
+
+		// Edited steps are matched against the SOP by their original command
+		logged := logStep.Command
+		if edited {
+			logged = logStep.OriginalCommand
+		}
+		if strings.TrimSpace(logged) != strings.TrimSpace(steps[i].Command) {
+			changed++
+			continue
+		}
+		if edited {
+			sop.Steps[i].OriginalCommand = sop.Steps[i].Command
+			sop.Steps[i].Command = logStep.Command
+			steps[i].Command = logStep.Command
+		}
+		if status == "" {
+			continue
+		}
+
+		steps[i].Status = status
+		steps[i].Output = logStep.Output
+		steps[i].Error = logStep.ExecutionResult.Error
+		steps[i].Note = logStep.ExecutionResult.Note
+		steps[i].Mismatches = logStep.ExecutionResult.Mismatches
+		steps[i].ExecutedAt = logStep.ExecutedAt
+	}
+	return steps, changed
+}
+
+// parseLogVariables parses the "K=V, K=V" variables line of a markdown log
+// header. Values containing ", " cannot be told apart from the next pair
+func parseLogVariables(line string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(line, ", ") {
+		if key, value, ok := strings.Cut(pair, "="); ok && key != "" {
+			values[key] = value
+		}
+	}
+	return values
+}
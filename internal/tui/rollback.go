@@ -0,0 +1,218 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"opsy/internal/policy"
+	"opsy/internal/types"
+)
+
+// rollbackSteps returns the succeeded steps whose rollback block still has
+// to run, the most recently executed first
+func (m model) rollbackSteps() []int {
+	var indexes []int
+	for i := len(m.steps) - 1; i >= 0; i-- {
+		if i >= len(m.sop.Steps) || m.sop.Steps[i].Rollback == nil {
+			continue
+		}
+		if m.steps[i].Status == statusSuccess && m.steps[i].RollbackStatus != statusSuccess {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return m.steps[indexes[a]].ExecutedAt.After(m.steps[indexes[b]].ExecutedAt)
+	})
+	return indexes
+}
+
+// runFailed reports whether the run failed, the same rule opsy run uses to
+// offer a rollback: a step failed, timed out or was denied, and it does not
+// allow errors with continue_on_error. A cancelled step interrupts the run
+// instead
+func (m model) runFailed() bool {
+	for i, step := range m.steps {
+		if i < len(m.sop.Steps) && m.sop.Steps[i].ContinueOnError {
+			continue
+		}
+		switch step.Status {
+		case statusError, statusTimeout, statusDenied:
+			return true
+		}
+	}
+	return false
+}
+
+// rolledBack reports whether the run was rolled back: at least one rollback
+// block ran and none is left to run or failed
+func (m model) rolledBack() bool {
+	for _, step := range m.steps {
+		if step.RollbackStatus != "" {
+			return len(m.rollbackSteps()) == 0
+		}
+	}
+	return false
+}
+
+// rollbackHint tells the operator a failed run can be rolled back, or
+// returns "" when there is nothing to roll back
+func (m model) rollbackHint() string {
+	n := len(m.rollbackSteps())
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s to roll back %d step(s))", m.keys.Rollback.Help().Key, n)
+}
+
+// promptRollback asks the operator to confirm rolling back the succeeded
+// steps of a failed run
+func (m *model) promptRollback() {
+	if m.running {
+		m.status = fmt.Sprintf("Step %d is still running", m.runningStep+1)
+		return
+	}
+	if !m.runFailed() {
+		m.status = "Nothing failed, there is nothing to roll back"
+		return
+	}
+	indexes := m.rollbackSteps()
+	if len(indexes) == 0 {
+		m.status = "No succeeded step has a rollback block left to run"
+		return
+	}
+
+	numbers := make([]string, len(indexes))
+	for i, index := range indexes {
+		numbers[i] = strconv.Itoa(index + 1)
+	}
+	m.rollbackPending = true
+	m.status = fmt.Sprintf("Roll back step(s) %s in this order: press y to start, any other key to cancel", strings.Join(numbers, ", "))
+}
+
+// nextRollback starts the rollback block of the next step to roll back,
+// pausing first when it requires confirmation
+func (m *model) nextRollback() []tea.Cmd {
+	indexes := m.rollbackSteps()
+	if len(indexes) == 0 {
+		m.rollingBack = false
+		m.status = "Rollback finished"
+		m.updateViewportContent()
+		return nil
+	}
+	index := indexes[0]
+	rollback := *m.sop.Steps[index].Rollback
+
+	// Follow the rollback with the highlight and viewport
+	m.currentStep = index
+	m.manualScrollActive = false
+
+	// Rollback commands are checked against the policy like any other
+	if decision := m.executor.CheckCommand(rollback.Command); decision.Action == policy.ActionDeny {
+		step := &m.steps[index]
+		step.RollbackStatus = statusDenied
+		step.RollbackOutput = ""
+		step.RollbackError = "Denied by " + decision.Reason()
+		step.RolledBackAt = time.Now()
+		m.rollingBack = false
+		m.status = fmt.Sprintf("Rollback stopped: rollback of step %d denied by %s", index+1, decision.Reason())
+		m.updateViewportContent()
+		return []tea.Cmd{m.saveExecutionLog(false)}
+	}
+
+	if reason := m.confirmationReason(rollback); reason != "" {
+		m.confirmPending = true
+		m.confirmReason = reason
+		m.status = fmt.Sprintf("Rollback paused: rollback of step %d requires %s (y to run, any other key to stop)", index+1, reason)
+		m.updateViewportContent()
+		return nil
+	}
+	return m.startRollback(index)
+}
+
+// startRollback runs the rollback block of step index in the background
+func (m *model) startRollback(index int) []tea.Cmd {
+	runner, err := m.stepRunner()
+	if err != nil {
+		m.rollingBack = false
+		m.status = fmt.Sprintf("Error starting shell session: %v", err)
+		return nil
+	}
+
+	m.running = true
+	m.runningStep = index
+	m.runSeq++
+	m.runStartedAt = time.Now()
+	step := &m.steps[index]
+	step.RollbackStatus = statusRunning
+	step.RollbackOutput = ""
+	step.RollbackError = ""
+	m.status = fmt.Sprintf("Rolling back step %d... (%s to cancel)", index+1, m.keys.Cancel.Help().Key)
+	m.updateViewportContent()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelRun = cancel
+	return []tea.Cmd{m.executeStepCmd(ctx, runner, index, *m.sop.Steps[index].Rollback), m.spinner.Tick}
+}
+
+// finishRollback records the result of a rollback block, saves the log and
+// moves on to the next step, stopping at the first rollback that fails
+func (m *model) finishRollback(msg stepFinishedMsg) []tea.Cmd {
+	step := &m.steps[msg.index]
+	if msg.err != nil {
+		step.RollbackStatus = statusError
+		step.RollbackError = msg.err.Error()
+		step.RolledBackAt = time.Now()
+	} else {
+		step.RollbackStatus = msg.result.Status
+		step.RollbackOutput = msg.result.Output
+		step.RollbackError = msg.result.Error
+		step.RolledBackAt = msg.result.ExecutedAt
+	}
+
+	cmds := []tea.Cmd{m.saveExecutionLog(false)}
+	if step.RollbackStatus != statusSuccess {
+		m.rollingBack = false
+		m.status = fmt.Sprintf("Rollback stopped: rollback of step %d %s", msg.index+1, step.RollbackStatus)
+		m.updateViewportContent()
+		return cmds
+	}
+	m.status = fmt.Sprintf("Rolled back step %d", msg.index+1)
+	return append(cmds, m.nextRollback()...)
+}
+
+// rollbackLog converts the rollback blocks that ran into log entries, in the
+// order they ran
+func (m model) rollbackLog() []types.ExecutionStep {
+	var indexes []int
+	for i, step := range m.steps {
+		if i < len(m.sop.Steps) && m.sop.Steps[i].Rollback != nil &&
+			step.RollbackStatus != "" && step.RollbackStatus != statusRunning {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return m.steps[indexes[a]].RolledBackAt.Before(m.steps[indexes[b]].RolledBackAt)
+	})
+
+	log := make([]types.ExecutionStep, 0, len(indexes))
+	for _, i := range indexes {
+		step := m.steps[i]
+		log = append(log, types.ExecutionStep{
+			StepID:       step.ID,
+			OriginalStep: *m.sop.Steps[i].Rollback,
+			ExecutionResult: &types.ExecutionResult{
+				ExecutedAt: step.RolledBackAt,
+				Status:     step.RollbackStatus,
+				Output:     step.RollbackOutput,
+				Error:      step.RollbackError,
+			},
+		})
+	}
+	return log
+}
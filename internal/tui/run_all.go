@@ -0,0 +1,63 @@
+package tui
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// nextRemainingStep returns the first step at or after from that has not
+// succeeded or been skipped, or -1 if there is none
+func (m model) nextRemainingStep(from int) int {
+	for i := from; i < len(m.steps); i++ {
+		if m.steps[i].Status != statusSuccess && m.steps[i].Status != statusSkipped {
+			return i
+		}
+	}
+	return -1
+}
+
+// runAllFrom moves to the next remaining step and starts it as part of a
+// run-all, pausing first when the step requires confirmation
+func (m *model) runAllFrom(index int) []tea.Cmd {
+	next := m.nextRemainingStep(index)
+	if next < 0 {
+		m.runAll = false
+		m.status = "Run all finished: no remaining steps"
+		m.updateViewportContent()
+		return nil
+	}
+
+	// Follow the run with the highlight and viewport
+	m.currentStep = next
+	m.manualScrollActive = false
+
+	if reason := m.confirmationReason(m.sop.Steps[next]); reason != "" {
+		m.confirmPending = true
+		m.confirmReason = reason
+		m.status = fmt.Sprintf("Run all paused: step %d requires %s (y to run, any other key to stop)", next+1, reason)
+		m.updateViewportContent()
+		return nil
+	}
+	return m.startStep(next)
+}
+
+// advanceRunAll continues a run-all after a step finished, stopping at the
+// first failure unless the step is marked continue_on_error
+func (m *model) advanceRunAll(index int) []tea.Cmd {
+	status := m.steps[index].Status
+	switch {
+	case status == statusSuccess:
+	case status == statusCancelled:
+		m.runAll = false
+		m.status = fmt.Sprintf("Run all cancelled at step %d", index+1)
+		return nil
+	case m.sop.Steps[index].ContinueOnError:
+		m.status = fmt.Sprintf("Step %d %s, continuing (continue_on_error)", index+1, status)
+	default:
+		m.runAll = false
+		m.status = fmt.Sprintf("Run all stopped: step %d %s", index+1, status) + m.rollbackHint()
+		return nil
+	}
+	return m.runAllFrom(index + 1)
+}
@@ -0,0 +1,43 @@
+package tui
+
+import (
+	"sync"
+	"time"
+
+	"opsy/internal/logger"
+	"opsy/internal/types"
+)
+
+// runSession identifies one execution run of the open SOP
+// It is created when an SOP is opened for execution; every log save for the
+// run reuses its ID and start time so the logger rewrites a single log file
+type runSession struct {
+	id        string
+	startedAt time.Time
+	sopPath   string
+	checksum  string            // Checksum of the SOP revision the run started with
+	variables map[string]string // Resolved variable values
+
+	contextOnce sync.Once
+	context     types.RunContext // Operator, host and SOP revision
+}
+
+// newRunSession starts a new run session of sop at the current time
+func newRunSession(sop *types.SOP) *runSession {
+	now := time.Now()
+	return &runSession{
+		id:        logger.NewRunID(now),
+		startedAt: now,
+		sopPath:   sop.Path,
+		checksum:  sop.Checksum,
+	}
+}
+
+// runContext returns the context of the run, collecting it on first use.
+// Collecting runs git, so it happens in the log save command, not in Update
+func (s *runSession) runContext() types.RunContext {
+	s.contextOnce.Do(func() {
+		s.context = logger.CollectRunContext(s.sopPath, s.checksum)
+	})
+	return s.context
+}
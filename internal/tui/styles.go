@@ -2,7 +2,48 @@ package tui
 
 import "github.com/charmbracelet/lipgloss"
 
-// Color palette
+// palette is the set of colors used by a theme
+type palette struct {
+	primary, secondary, accent, text, faint, border lipgloss.Color
+	success, error, warning                         lipgloss.Color
+	muted, code, output, highlight                  lipgloss.Color
+}
+
+// themes maps the configurable theme names to their palettes
+var themes = map[string]palette{
+	"dark": {
+		primary:   "213", // Purple
+		secondary: "170", // Light purple
+		accent:    "45",  // Cyan
+		text:      "15",  // White
+		faint:     "240", // Gray
+		border:    "242", // Dark gray
+		success:   "42",  // Green
+		error:     "203", // Red
+		warning:   "220", // Yellow
+		muted:     "250", // Light gray, descriptions
+		code:      "117", // Light blue, commands
+		output:    "252", // Near white, command output
+		highlight: "235", // Selection background
+	},
+	"light": {
+		primary:   "90",  // Dark purple
+		secondary: "127", // Purple
+		accent:    "31",  // Teal
+		text:      "235", // Near black
+		faint:     "245", // Gray
+		border:    "248", // Light gray
+		success:   "28",  // Dark green
+		error:     "160", // Dark red
+		warning:   "130", // Orange
+		muted:     "238", // Dark gray, descriptions
+		code:      "25",  // Dark blue, commands
+		output:    "236", // Near black, command output
+		highlight: "254", // Selection background
+	},
+}
+
+// Color palette (dark by default, see applyTheme)
 var (
 	colorPrimary   = lipgloss.Color("213") // Purple
 	colorSecondary = lipgloss.Color("170") // Light purple
@@ -13,36 +54,78 @@ var (
 	colorSuccess   = lipgloss.Color("42")  // Green
 	colorError     = lipgloss.Color("203") // Red
 	colorWarning   = lipgloss.Color("220") // Yellow
+	colorMuted     = lipgloss.Color("250") // Light gray
+	colorCode      = lipgloss.Color("117") // Light blue
+	colorOutput    = lipgloss.Color("252") // Near white
+	colorHighlight = lipgloss.Color("235") // Selection background
 )
 
 // Base styles
 var (
+	baseStyle         lipgloss.Style
+	headerStyle       lipgloss.Style
+	borderStyle       lipgloss.Style
+	statusBarStyle    lipgloss.Style
+	itemStyle         lipgloss.Style // Item styles for list
+	selectedItemStyle lipgloss.Style
+)
+
+func init() {
+	buildStyles()
+}
+
+// applyTheme switches the color palette and rebuilds the base styles
+// Unknown names fall back to the dark theme
+func applyTheme(name string) {
+	p, ok := themes[name]
+	if !ok {
+		p = themes["dark"]
+	}
+
+	colorPrimary = p.primary
+	colorSecondary = p.secondary
+	colorAccent = p.accent
+	colorText = p.text
+	colorFaint = p.faint
+	colorBorder = p.border
+	colorSuccess = p.success
+	colorError = p.error
+	colorWarning = p.warning
+	colorMuted = p.muted
+	colorCode = p.code
+	colorOutput = p.output
+	colorHighlight = p.highlight
+
+	buildStyles()
+}
+
+// buildStyles creates the base styles from the current color palette
+func buildStyles() {
 	baseStyle = lipgloss.NewStyle().
-			Foreground(colorText)
+		Foreground(colorText)
 
 	headerStyle = lipgloss.NewStyle().
-			Foreground(colorPrimary).
-			Padding(0, 1).
-			MarginBottom(1)
+		Foreground(colorPrimary).
+		Padding(0, 1).
+		MarginBottom(1)
 
 	borderStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(colorBorder)
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(colorBorder)
 
 	statusBarStyle = lipgloss.NewStyle().
-			Foreground(colorText).
-			Padding(0, 1).
-			Width(80)
+		Foreground(colorText).
+		Padding(0, 1).
+		Width(80)
 
-	// Item styles for list
 	itemStyle = lipgloss.NewStyle().
-			PaddingLeft(2).
-			Foreground(colorText)
+		PaddingLeft(2).
+		Foreground(colorText)
 
 	selectedItemStyle = lipgloss.NewStyle().
-				PaddingLeft(2).
-				Foreground(colorText).
-				Background(lipgloss.Color("235")).
-				BorderLeft(true).
-				BorderLeftForeground(colorAccent)
-)
+		PaddingLeft(2).
+		Foreground(colorText).
+		Background(colorHighlight).
+		BorderLeft(true).
+		BorderLeftForeground(colorAccent)
+}
@@ -10,16 +10,27 @@ package tui
 // - view.go: View rendering
 // - execute_view.go: Execution view rendering with viewport
 // - handlers.go: Command handlers (save log, etc.)
+// - session.go: Run session shared by all log saves of one execution
+// - variables.go: Form prompting for SOP variables before execution
+// - resume.go: Resuming a logged run
+// - run_all.go: Running all remaining steps in sequence
+// - manual.go: Answering manual checklist steps
+// - rollback.go: Rolling back the succeeded steps of a failed run
+// - keys.go: Configurable execute mode key bindings
+// - diff.go: Line diff of edited commands
+// - editor.go: Opening SOPs in the external editor and reloading them
 // - helpers.go: Utility functions (text wrapping, file listing, etc.)
 
 import (
 	tea "github.com/charmbracelet/bubbletea"
+
+	"opsy/internal/config"
 )
 
 // Run starts the TUI application
-func Run(executor ExecutorInterface, logger LoggerInterface) error {
+func Run(executor ExecutorInterface, logger LoggerInterface, cfg *config.Config) error {
 	p := tea.NewProgram(
-		NewModel(executor, logger),
+		NewModel(executor, logger, cfg),
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
 	)
@@ -1,25 +1,41 @@
 package tui
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
+
+	"opsy/internal/config"
+	"opsy/internal/logger"
+	"opsy/internal/policy"
 	"opsy/internal/types"
 
 	"github.com/stretchr/testify/assert"
 )
 
 // Mock implementations for testing
-type MockExecutor struct{}
+type MockExecutor struct {
+	policy *policy.Policy // nil allows every command
+}
 
-func (m *MockExecutor) ExecuteStep(step types.Step) (*types.ExecutionResult, error) {
+func (m *MockExecutor) ExecuteStepStream(ctx context.Context, step types.Step, onOutput func(line string)) (*types.ExecutionResult, error) {
+	if onOutput != nil {
+		onOutput("Command output")
+	}
 	return &types.ExecutionResult{
 		Status: "success",
 		Output: "Command output",
 	}, nil
 }
 
-func (m *MockExecutor) ValidateCommand(command string) error {
-	return nil
+func (m *MockExecutor) CheckCommand(command string) policy.Decision {
+	return m.policy.Evaluate(command)
 }
 
 type MockLogger struct{}
@@ -28,12 +44,21 @@ func (m *MockLogger) LogExecution(execution types.SOPExecution) (string, error)
 	return "/tmp/test.log", nil
 }
 
+// testConfig returns the default configuration rooted in a temporary directory
+func testConfig(t *testing.T) *config.Config {
+	cfg := config.Default()
+	cfg.BaseDirectory = t.TempDir()
+	cfg.Roots = []config.Root{{Name: config.DefaultRootName, Path: cfg.BaseDirectory}}
+	cfg.LogDirectory = t.TempDir()
+	return cfg
+}
+
 func TestNewModel(t *testing.T) {
 	executor := &MockExecutor{}
 	logger := &MockLogger{}
-	
-	model := NewModel(executor, logger)
-	
+
+	model := NewModel(executor, logger, testConfig(t))
+
 	assert.Equal(t, modeBrowse, model.mode)
 	assert.Equal(t, "Ready", model.status)
 	assert.NotNil(t, model.fileList)
@@ -42,9 +67,9 @@ func TestNewModel(t *testing.T) {
 func TestModelInitialization(t *testing.T) {
 	executor := &MockExecutor{}
 	logger := &MockLogger{}
-	
-	model := NewModel(executor, logger)
-	
+
+	model := NewModel(executor, logger, testConfig(t))
+
 	assert.Equal(t, modeBrowse, model.mode)
 	assert.NotNil(t, model.executor)
 	assert.NotNil(t, model.logger)
@@ -53,18 +78,18 @@ func TestModelInitialization(t *testing.T) {
 func TestGetModeContext(t *testing.T) {
 	executor := &MockExecutor{}
 	logger := &MockLogger{}
-	model := NewModel(executor, logger)
-	
+	model := NewModel(executor, logger, testConfig(t))
+
 	// Test different modes
 	model.mode = modeBrowse
 	assert.Equal(t, "Browser", model.getModeContext())
-	
+
 	model.mode = modeExecute
 	assert.Equal(t, "Execution", model.getModeContext())
-	
+
 	model.mode = modeLogs
 	assert.Equal(t, "Logs", model.getModeContext())
-	
+
 	model.mode = modeEdit
 	assert.Equal(t, "Edit", model.getModeContext())
 }
@@ -72,22 +97,1112 @@ func TestGetModeContext(t *testing.T) {
 func TestGetPathContext(t *testing.T) {
 	executor := &MockExecutor{}
 	logger := &MockLogger{}
-	model := NewModel(executor, logger)
-	
+	model := NewModel(executor, logger, testConfig(t))
+
 	// Test browse mode
 	model.mode = modeBrowse
 	model.currentPath = t.TempDir()
 	assert.Equal(t, model.currentPath, model.getPathContext())
-	
+
 	// Test logs mode when browsing
 	model.mode = modeLogs
 	model.currentPath = t.TempDir()
 	model.logViewReady = false
 	assert.Equal(t, model.currentPath, model.getPathContext())
-	
+
 	// Test logs mode when viewing a file
 	model.mode = modeLogs
 	model.logViewReady = true
 	model.logViewPath = t.TempDir() + "/test.log"
 	assert.Equal(t, "test.log", model.getPathContext())
-}
\ No newline at end of file
+}
+
+func TestExecuteStepRunsInBackground(t *testing.T) {
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	m.mode = modeExecute
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{{ID: 1, Title: "echo", Command: "echo hi"}},
+	}
+	m.steps = []SOPStep{{ID: 1, Title: "echo", Command: "echo hi", Status: statusPending}}
+
+	// Pressing enter starts the step without blocking
+	cmds := m.handleExecuteCommands(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.NotEmpty(t, cmds)
+	assert.True(t, m.running)
+	assert.Equal(t, statusRunning, m.steps[0].Status)
+
+	// A second run request is refused while the step is running
+	m.handleExecuteCommands(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Contains(t, m.status, "still running")
+
+	// The background command streams output and reports completion
+	batch, ok := m.executeStepCmd(context.Background(), m.executor, 0, m.sop.Steps[0])().(tea.BatchMsg)
+	assert.True(t, ok)
+	assert.Len(t, batch, 2)
+
+	finished, ok := batch[0]().(stepFinishedMsg)
+	assert.True(t, ok)
+	output, ok := batch[1]().(stepOutputMsg)
+	assert.True(t, ok)
+
+	updated, _ := m.Update(output)
+	m = updated.(model)
+	assert.True(t, m.running)
+	assert.Equal(t, "Command output", m.steps[0].Output)
+
+	updated, _ = m.Update(finished)
+	m = updated.(model)
+	assert.False(t, m.running)
+	assert.Equal(t, statusSuccess, m.steps[0].Status)
+	assert.Equal(t, "Command output", m.steps[0].Output)
+
+	// Late output from a finished step is not appended again
+	updated, _ = m.Update(output)
+	m = updated.(model)
+	assert.Equal(t, "Command output", m.steps[0].Output)
+}
+
+func TestConfirmStep(t *testing.T) {
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	m.mode = modeExecute
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{{ID: 1, Title: "drop", Command: "dropdb test", Confirm: true}},
+	}
+	m.steps = []SOPStep{{ID: 1, Title: "drop", Command: "dropdb test", Status: statusPending}}
+
+	// Enter only asks for confirmation
+	m.handleExecuteKeys(tea.KeyMsg{Type: tea.KeyEnter}, nil)
+	assert.True(t, m.confirmPending)
+	assert.False(t, m.running)
+
+	// Any key other than y aborts
+	m.handleExecuteKeys(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")}, nil)
+	assert.False(t, m.confirmPending)
+	assert.False(t, m.running)
+	assert.Equal(t, statusPending, m.steps[0].Status)
+
+	// y runs the step
+	m.handleExecuteKeys(tea.KeyMsg{Type: tea.KeyEnter}, nil)
+	m.handleExecuteKeys(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")}, nil)
+	assert.False(t, m.confirmPending)
+	assert.True(t, m.running)
+	assert.Equal(t, statusRunning, m.steps[0].Status)
+}
+
+func TestKeyMap(t *testing.T) {
+	keys := newKeyMap(map[string]string{"run": "r, space", "back": "esc"})
+
+	assert.Equal(t, "↑↓ nav · r run · a run all · c cancel · e edit · w save to SOP · o open SOP · s skip · b roll back · l logs · esc back", keys.executeHelp())
+
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	m.keys = keys
+	m.mode = modeExecute
+	m.sop = &types.SOP{Title: "Test SOP", Steps: []types.Step{{ID: 1, Title: "echo", Command: "echo hi"}}}
+	m.steps = []SOPStep{{ID: 1, Title: "echo", Command: "echo hi", Status: statusPending}}
+
+	// The default enter no longer runs the step, the configured keys do
+	m.handleExecuteCommands(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.False(t, m.running)
+	m.handleExecuteCommands(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")})
+	assert.True(t, m.running)
+}
+
+func TestBrowseRoots(t *testing.T) {
+	cfg := testConfig(t)
+	team := t.TempDir()
+	cfg.Roots = append(cfg.Roots, config.Root{Name: "team", Path: team, ReadOnly: true})
+	if err := os.Mkdir(filepath.Join(team, "db"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(team, "db", "restore.md"), []byte("# Restore\n\n```bash\necho restore\n```\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(&MockExecutor{}, &MockLogger{}, cfg)
+
+	// Several roots are listed at the top level
+	assert.Equal(t, rootListPath, m.currentPath)
+	items := m.fileList.Items()
+	assert.Len(t, items, 2)
+	assert.Equal(t, "team/", items[1].(item).title)
+	assert.Contains(t, items[1].(item).desc, "read-only")
+
+	// The top of a root leads back to the root list, not above it
+	parent, ok := m.parentPath(team)
+	assert.True(t, ok)
+	assert.Equal(t, rootListPath, parent)
+	_, ok = m.parentPath(rootListPath)
+	assert.False(t, ok)
+
+	// SOPs remember their root
+	sop, err := m.loadSOP(filepath.Join(team, "db", "restore.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "team", sop.Root)
+	assert.True(t, sop.ReadOnly)
+}
+
+func TestRunAll(t *testing.T) {
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	m.mode = modeExecute
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{
+			{ID: 1, Title: "one", Command: "true"},
+			{ID: 2, Title: "two", Command: "false", ContinueOnError: true},
+			{ID: 3, Title: "three", Command: "true", Confirm: true},
+			{ID: 4, Title: "four", Command: "false"},
+			{ID: 5, Title: "five", Command: "true"},
+		},
+	}
+	m.steps = newSOPSteps(m.sop)
+
+	// finish completes the running step with the given status
+	finish := func(status string) {
+		updated, _ := m.Update(stepFinishedMsg{
+			seq:    m.runSeq,
+			index:  m.runningStep,
+			result: &types.ExecutionResult{Status: status},
+		})
+		m = updated.(model)
+	}
+
+	m.handleExecuteCommands(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+	assert.True(t, m.runAll)
+	assert.Equal(t, 0, m.runningStep)
+
+	// Success advances the highlight and starts the next step
+	finish(statusSuccess)
+	assert.True(t, m.running)
+	assert.Equal(t, 1, m.runningStep)
+	assert.Equal(t, 1, m.currentStep)
+
+	// continue_on_error keeps going, then pauses at the confirm step
+	finish(statusError)
+	assert.False(t, m.running)
+	assert.True(t, m.confirmPending)
+	assert.Equal(t, 2, m.currentStep)
+
+	m.handleExecuteKeys(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")}, nil)
+	assert.True(t, m.running)
+	assert.Equal(t, 2, m.runningStep)
+
+	// A failure stops the run
+	finish(statusSuccess)
+	finish(statusError)
+	assert.False(t, m.runAll)
+	assert.False(t, m.running)
+	assert.Equal(t, 3, m.currentStep)
+	assert.Equal(t, statusPending, m.steps[4].Status)
+	assert.Contains(t, m.status, "Run all stopped")
+}
+
+func TestManualSteps(t *testing.T) {
+	var saved types.SOPExecution
+	m := NewModel(&MockExecutor{}, &recordingLogger{saved: &saved}, testConfig(t))
+	m.mode = modeExecute
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{
+			{ID: 1, Title: "one", Command: "true"},
+			{ID: 2, Title: "Notify #incidents", Manual: true},
+			{ID: 3, Title: "Check dashboard", Manual: true},
+			{ID: 4, Title: "four", Command: "true"},
+			{ID: 5, Title: "Verify graphs", Manual: true},
+		},
+	}
+	m.steps = newSOPSteps(m.sop)
+
+	press := func(keys ...string) {
+		for _, k := range keys {
+			msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+			switch k {
+			case "enter":
+				msg = tea.KeyMsg{Type: tea.KeyEnter}
+			case "esc":
+				msg = tea.KeyMsg{Type: tea.KeyEsc}
+			}
+			updated, _ := m.Update(msg)
+			m = updated.(model)
+		}
+	}
+	finish := func(status string) {
+		updated, _ := m.Update(stepFinishedMsg{
+			seq:    m.runSeq,
+			index:  m.runningStep,
+			result: &types.ExecutionResult{Status: status},
+		})
+		m = updated.(model)
+	}
+
+	// Run-all pauses at a manual step instead of running it
+	press("a")
+	finish(statusSuccess)
+	assert.False(t, m.running)
+	assert.True(t, m.manualPending)
+	assert.Equal(t, 1, m.currentStep)
+	assert.Contains(t, m.status, "Run all paused: step 2 is a manual step")
+
+	// The answer can be changed before it is recorded with a note
+	press("f", "esc", "d", "posted by alice", "enter")
+	assert.Equal(t, statusSuccess, m.steps[1].Status)
+	assert.Equal(t, "posted by alice", m.steps[1].Note)
+
+	// The run moves on to the next manual step; skipping it keeps going
+	assert.True(t, m.manualPending)
+	assert.Equal(t, 2, m.currentStep)
+	press("s", "enter")
+	assert.Equal(t, statusSkipped, m.steps[2].Status)
+	assert.True(t, m.running)
+	assert.Equal(t, 3, m.runningStep)
+
+	// Failing one stops it
+	finish(statusSuccess)
+	press("f", "p99 is red", "enter")
+	assert.False(t, m.runAll)
+	assert.Equal(t, statusError, m.steps[4].Status)
+	assert.Contains(t, m.status, "Run all stopped: step 5 error")
+
+	m.saveExecutionLog(true)()
+	assert.Equal(t, "posted by alice", saved.ExecutionLog[1].ExecutionResult.Note)
+	assert.Equal(t, "p99 is red", saved.ExecutionLog[4].ExecutionResult.Note)
+	assert.Equal(t, "failed", saved.Status)
+
+	// Manual steps have no command to edit, and other keys cancel the prompt
+	m.currentStep = 1
+	press("e")
+	assert.Equal(t, modeExecute, m.mode)
+	assert.Contains(t, m.status, "manual step")
+	press("enter", "x")
+	assert.False(t, m.manualPending)
+	assert.Equal(t, "Step not recorded", m.status)
+
+	// Markdown logs keep the answer and the note
+	_, logSteps := ParseLogFile("## Step 2: Notify #incidents\n" +
+		"> **Manual step:** ✋ Performed by the operator  \n" +
+		"> **Result:** ✅ Done  \n" +
+		"> **Note:** posted by alice  \n")
+	if assert.Len(t, logSteps, 1) {
+		assert.True(t, logSteps[0].Manual)
+		assert.Equal(t, statusSuccess, normalizeStatus(logSteps[0].Status))
+		assert.Equal(t, "posted by alice", logSteps[0].Note)
+		assert.Empty(t, logSteps[0].Command)
+	}
+}
+
+func TestExpectationMismatches(t *testing.T) {
+	var saved types.SOPExecution
+	m := NewModel(&MockExecutor{}, &recordingLogger{saved: &saved}, testConfig(t))
+	m.mode = modeExecute
+	m.width = 100
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{
+			{ID: 1, Title: "ls", Command: "ls", Expectations: []types.Expectation{{Kind: types.ExpectContains, Value: ".sql.gz"}}},
+		},
+	}
+	m.steps = newSOPSteps(m.sop)
+
+	m.startStep(0)
+	updated, _ := m.Update(stepFinishedMsg{
+		seq:   m.runSeq,
+		index: 0,
+		result: &types.ExecutionResult{
+			Status:     statusError,
+			Error:      "Expectations not met: output does not contain \".sql.gz\"",
+			Mismatches: []string{"output does not contain \".sql.gz\""},
+		},
+	})
+	m = updated.(model)
+	assert.Equal(t, []string{"output does not contain \".sql.gz\""}, m.steps[0].Mismatches)
+
+	content, _ := m.renderExecutionContent()
+	assert.Contains(t, content, "contains: .sql.gz")
+	assert.Contains(t, content, "Expectations not met:")
+	assert.Contains(t, content, "✗ output does not contain \".sql.gz\"")
+
+	m.saveExecutionLog(true)()
+	assert.Equal(t, m.steps[0].Mismatches, saved.ExecutionLog[0].ExecutionResult.Mismatches)
+
+	// Rerunning the step clears the previous mismatches
+	m.startStep(0)
+	assert.Empty(t, m.steps[0].Mismatches)
+
+	// Markdown logs list each mismatch
+	_, logSteps := ParseLogFile("## Step 1: ls\n```bash\nls\n```\n\n" +
+		"> **Result:** ❌ Error  \n" +
+		"> **Expectation failed:** exit code 2, expected 0  \n" +
+		"> **Expectation failed:** output does not contain \".sql.gz\"  \n")
+	if assert.Len(t, logSteps, 1) {
+		assert.Equal(t, []string{"exit code 2, expected 0", "output does not contain \".sql.gz\""}, logSteps[0].Mismatches)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	commandPolicy, err := policy.New([]policy.Rule{
+		{Name: "no-drop", Action: policy.ActionDeny, Match: `(?i)drop table`, Message: "irreversible"},
+		{Name: "prod", Action: policy.ActionConfirm, Command: "kubectl", Match: `--context[= ]prod`},
+	})
+	assert.NoError(t, err)
+
+	var saved types.SOPExecution
+	log := &recordingLogger{saved: &saved}
+	m := NewModel(&MockExecutor{policy: commandPolicy}, log, testConfig(t))
+	m.mode = modeExecute
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{
+			{ID: 1, Title: "one", Command: "true"},
+			{ID: 2, Title: "two", Command: "kubectl --context=prod get pods"},
+			{ID: 3, Title: "three", Command: "psql -c 'DROP TABLE users'"},
+			{ID: 4, Title: "four", Command: "true"},
+		},
+	}
+	m.steps = newSOPSteps(m.sop)
+
+	finish := func(status string) {
+		updated, _ := m.Update(stepFinishedMsg{
+			seq:    m.runSeq,
+			index:  m.runningStep,
+			result: &types.ExecutionResult{Status: status},
+		})
+		m = updated.(model)
+	}
+
+	// A confirm rule pauses run-all like confirm=true
+	m.handleExecuteCommands(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+	finish(statusSuccess)
+	assert.True(t, m.confirmPending)
+	assert.Contains(t, m.confirmReason, "policy rule prod")
+	m.handleExecuteKeys(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")}, nil)
+	assert.True(t, m.running)
+
+	// A denied step is recorded without running and stops the run
+	finish(statusSuccess)
+	assert.False(t, m.running)
+	assert.False(t, m.runAll)
+	assert.Equal(t, statusDenied, m.steps[2].Status)
+	assert.Equal(t, "no-drop", m.steps[2].PolicyRule)
+	assert.Contains(t, m.status, "denied by policy rule no-drop: irreversible")
+	assert.Equal(t, statusPending, m.steps[3].Status)
+	assert.Equal(t, "failed", runStatus(m.steps))
+
+	m.saveExecutionLog(true)()
+	result := saved.ExecutionLog[2].ExecutionResult
+	assert.Equal(t, "denied", result.Status)
+	assert.Equal(t, "no-drop", result.PolicyRule)
+	assert.Equal(t, "Denied by policy rule no-drop: irreversible", result.Error)
+}
+
+func TestRollback(t *testing.T) {
+	var saved types.SOPExecution
+	m := NewModel(&MockExecutor{}, &recordingLogger{saved: &saved}, testConfig(t))
+	m.mode = modeExecute
+	m.width = 100
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{
+			{ID: 1, Title: "Stop app", Command: "stop app", Rollback: &types.Step{ID: 1, Title: "Stop app", Command: "start app"}},
+			{ID: 2, Title: "Backup", Command: "backup"},
+			{ID: 3, Title: "Switch", Command: "switch", Rollback: &types.Step{ID: 3, Title: "Switch", Command: "switch back", Confirm: true}},
+			{ID: 4, Title: "Migrate", Command: "migrate"},
+		},
+	}
+	m.steps = newSOPSteps(m.sop)
+
+	press := func(keys ...string) {
+		for _, k := range keys {
+			updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+			m = updated.(model)
+		}
+	}
+	finish := func(status string) {
+		updated, _ := m.Update(stepFinishedMsg{
+			seq:    m.runSeq,
+			index:  m.runningStep,
+			result: &types.ExecutionResult{Status: status, Output: "out", ExecutedAt: time.Now()},
+		})
+		m = updated.(model)
+	}
+
+	// Nothing to roll back before something failed
+	press("b")
+	assert.False(t, m.rollbackPending)
+	assert.Contains(t, m.status, "Nothing failed")
+
+	press("a")
+	finish(statusSuccess)
+	finish(statusSuccess)
+	finish(statusSuccess)
+	finish(statusError)
+	assert.Equal(t, "Run all stopped: step 4 error (b to roll back 2 step(s))", m.status)
+
+	// Rolling back walks the succeeded steps in reverse, confirming where asked
+	press("b")
+	assert.True(t, m.rollbackPending)
+	assert.Contains(t, m.status, "Roll back step(s) 3, 1")
+	press("y")
+	assert.True(t, m.confirmPending)
+	assert.Equal(t, 2, m.currentStep)
+	press("y")
+	assert.True(t, m.running)
+	assert.Equal(t, statusRunning, m.steps[2].RollbackStatus)
+	content, _ := m.renderExecutionContent()
+	assert.Contains(t, content, "Rollback: ⟳ rolling back")
+
+	finish(statusSuccess)
+	assert.Equal(t, statusSuccess, m.steps[2].RollbackStatus)
+	assert.Equal(t, "out", m.steps[2].RollbackOutput)
+	assert.Equal(t, statusSuccess, m.steps[2].Status)
+	assert.Equal(t, 0, m.runningStep)
+
+	// A failed rollback stops the walk and can be retried
+	finish(statusError)
+	assert.False(t, m.rollingBack)
+	assert.Equal(t, "Rollback stopped: rollback of step 1 error", m.status)
+	m.saveExecutionLog(true)()
+	assert.Equal(t, "failed", saved.Status)
+
+	press("b", "y")
+	finish(statusSuccess)
+	assert.Equal(t, "Rollback finished", m.status)
+
+	m.saveExecutionLog(true)()
+	assert.Equal(t, "rolled_back", saved.Status)
+	assert.Len(t, saved.ExecutionLog, 4)
+	if assert.Len(t, saved.Rollbacks, 2) {
+		assert.Equal(t, "switch back", saved.Rollbacks[0].OriginalStep.Command)
+		assert.Equal(t, "start app", saved.Rollbacks[1].OriginalStep.Command)
+	}
+	press("b")
+	assert.Contains(t, m.status, "No succeeded step has a rollback block")
+
+	// Markdown logs keep rollback entries apart from the steps
+	_, logSteps := ParseLogFile("## Step 1: Stop app\n```bash\nstop app\n```\n\n> **Result:** ✅ Success  \n\n" +
+		"## Rollback of Step 1: Stop app\n```bash\nstart app\n```\n\n> **Result:** ✅ Success  \n")
+	if assert.Len(t, logSteps, 2) {
+		assert.False(t, logSteps[0].Rollback)
+		assert.True(t, logSteps[1].Rollback)
+		assert.Equal(t, 1, logSteps[1].StepNumber)
+		assert.Equal(t, "start app", logSteps[1].Command)
+	}
+	logFile, err := markdownLogFile(LogMetadata{StartedAt: "2025-10-09 22:37:14"}, logSteps)
+	assert.NoError(t, err)
+	steps, changed := restoreSteps(m.sop, logFile)
+	assert.Zero(t, changed)
+	assert.Equal(t, statusSuccess, steps[0].Status)
+	assert.Equal(t, statusSuccess, steps[0].RollbackStatus)
+
+	// Errors come back with the step and rollback results
+	logFile.Steps[0].ResultStatus = statusError
+	logFile.Steps[0].ExecutionResult.Error = "exit status 1"
+	logFile.Rollbacks[0].ResultStatus = statusError
+	logFile.Rollbacks[0].ExecutionResult.Error = "exit status 2"
+	steps, _ = restoreSteps(m.sop, logFile)
+	assert.Equal(t, "exit status 1", steps[0].Error)
+	assert.Equal(t, "exit status 2", steps[0].RollbackError)
+
+	// Like opsy run, cancelled runs and steps allowed to fail are not rolled back
+	m.steps = newSOPSteps(m.sop)
+	m.steps[0].Status = statusSuccess
+	m.steps[1].Status = statusCancelled
+	assert.False(t, m.runFailed())
+	m.steps[1].Status = statusError
+	m.sop.Steps[1].ContinueOnError = true
+	assert.False(t, m.runFailed())
+	m.sop.Steps[1].ContinueOnError = false
+	assert.True(t, m.runFailed())
+}
+
+func TestExecuteKeysDoNotScroll(t *testing.T) {
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	m.width, m.height = 100, 20
+	sop := &types.SOP{Title: "Long"}
+	for i := 1; i <= 20; i++ {
+		sop.Steps = append(sop.Steps, types.Step{ID: i, Title: "Step", Command: "echo"})
+	}
+	updated, _ := m.Update(enterModeMsg{mode: modeExecute, sop: sop, steps: newSOPSteps(sop)})
+	m = updated.(model)
+	m.currentStep = 19
+	m.updateViewportContent()
+	offset := m.viewport.YOffset
+	assert.NotZero(t, offset)
+
+	// b rolls back, it doesn't page up like it does in a plain viewport
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b")})
+	m = updated.(model)
+	assert.Contains(t, m.status, "Nothing failed")
+	assert.Equal(t, offset, m.viewport.YOffset)
+	assert.False(t, m.manualScrollActive)
+
+	// Other keys still scroll
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyPgUp})
+	m = updated.(model)
+	assert.Less(t, m.viewport.YOffset, offset)
+	assert.True(t, m.manualScrollActive)
+}
+
+func TestIncludedSteps(t *testing.T) {
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	m.mode = modeExecute
+	m.width = 100
+	m.sop = &types.SOP{
+		Title: "Deploy",
+		Path:  "/sops/infra/deploy.md",
+		Steps: []types.Step{
+			{ID: 1, Title: "lb", Command: "lb drain web-1", LineNumber: 12, Source: "/sops/common/maintenance-on.md"},
+			{ID: 2, Title: "deploy", Command: "deploy", LineNumber: 9},
+		},
+	}
+	m.steps = newSOPSteps(m.sop)
+
+	// Included steps are marked with the file they come from
+	content, _ := m.renderExecutionContent()
+	assert.Contains(t, content, "⤷ included from ../common/maintenance-on.md:12")
+	assert.Equal(t, 1, strings.Count(content, "included from"))
+
+	// Editing the included file reloads the SOP as well
+	assert.True(t, isSOPFile(m.sop, "/sops/common/maintenance-on.md"))
+	assert.True(t, isSOPFile(m.sop, "/sops/infra/deploy.md"))
+	assert.False(t, isSOPFile(m.sop, "/sops/common/other.md"))
+}
+
+// recordingLogger keeps the last execution it was asked to log
+type recordingLogger struct {
+	saved *types.SOPExecution
+}
+
+func (l *recordingLogger) LogExecution(execution types.SOPExecution) (string, error) {
+	*l.saved = execution
+	return "/tmp/test.log", nil
+}
+
+func TestEditCommand(t *testing.T) {
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	m.width, m.height = 100, 40
+	m.mode = modeExecute
+	original := "pg_dump production \\\n  | gzip > backup.sql.gz"
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{{ID: 1, Title: "dump", Command: original}},
+	}
+	m.steps = newSOPSteps(m.sop)
+
+	m.handleExecuteCommands(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	m.mode = modeEdit
+	assert.Equal(t, original, m.textarea.Value())                       // Newlines and continuations survive
+	assert.Equal(t, calculateViewportHeight(40)-2, m.textarea.Height()) // Sized to the viewport
+
+	// Typing enter inserts a newline instead of saving
+	m.textarea.CursorEnd()
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = updated.(model)
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("ls -l")})
+	m = updated.(model)
+	assert.Equal(t, modeEdit, m.mode)
+	assert.Equal(t, original+"\nls -l", m.textarea.Value())
+
+	// ctrl+s shows the diff, enter saves
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	m = updated.(model)
+	assert.True(t, m.editReview)
+	assert.Contains(t, m.renderEditView(), "+ ls -l")
+	assert.Equal(t, original, m.sop.Steps[0].Command)
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = updated.(model)
+	assert.Equal(t, original+"\nls -l", m.sop.Steps[0].Command)
+	assert.Equal(t, original+"\nls -l", m.steps[0].Command)
+	assert.Equal(t, original, m.sop.Steps[0].OriginalCommand) // Kept for the log
+	assert.Equal(t, modeExecute, cmd().(enterModeMsg).mode)
+}
+
+func TestSaveEditToSOP(t *testing.T) {
+	cfg := testConfig(t)
+	sopPath := filepath.Join(cfg.BaseDirectory, "restart.md")
+	content := "# Restart\n\n```bash\nsystemctl restart web\n```\n\nCheck it.\n\n```bash\ncurl -I localhost\n```\n"
+	if err := os.WriteFile(sopPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(&MockExecutor{}, &MockLogger{}, cfg)
+	m.width, m.height = 100, 40
+	m.mode = modeExecute
+	sop, err := m.loadSOP(sopPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.sop = sop
+	m.steps = newSOPSteps(sop)
+	save := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("w")}
+	yes := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")}
+
+	// Nothing to save until the command is edited
+	updated, _ := m.Update(save)
+	m = updated.(model)
+	assert.Nil(t, m.sopEdit)
+	assert.Equal(t, "Step 1 has no unsaved edits", m.status)
+
+	m.sop.Steps[0].OriginalCommand = m.sop.Steps[0].Command
+	m.sop.Steps[0].Command = "systemctl restart web\nsystemctl status web"
+	m.steps[0].Command = m.sop.Steps[0].Command
+
+	// w shows the diff of the code block, any key but y cancels
+	updated, _ = m.Update(save)
+	m = updated.(model)
+	if assert.NotNil(t, m.sopEdit) {
+		view, _ := m.renderExecutionContent()
+		assert.Contains(t, view, "+ systemctl status web")
+	}
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+	m = updated.(model)
+	assert.Nil(t, m.sopEdit)
+	assert.Equal(t, content, mustRead(t, sopPath))
+
+	updated, _ = m.Update(save)
+	m = updated.(model)
+	updated, _ = m.Update(yes)
+	m = updated.(model)
+	assert.Equal(t, "Saved step 1 to restart.md", m.status)
+	assert.Equal(t, strings.Replace(content, "restart web\n", "restart web\nsystemctl status web\n", 1), mustRead(t, sopPath))
+
+	// The edit stays a deviation of this run, but is no longer unsaved
+	assert.Equal(t, "systemctl restart web", m.sop.Steps[0].OriginalCommand)
+	updated, _ = m.Update(save)
+	m = updated.(model)
+	assert.Equal(t, "Step 1 has no unsaved edits", m.status)
+
+	// A file changed behind opsy's back is not overwritten
+	m.sop.Steps[1].OriginalCommand = m.sop.Steps[1].Command
+	m.sop.Steps[1].Command = "curl -I localhost:8080"
+	if err := os.WriteFile(sopPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.currentStep = 1
+	updated, _ = m.Update(save)
+	m = updated.(model)
+	assert.Nil(t, m.sopEdit)
+	assert.Contains(t, m.status, "changed on disk since it was loaded")
+
+	// Read-only roots are never written to
+	m.sop.ReadOnly, m.sop.Root = true, "shared"
+	updated, _ = m.Update(save)
+	m = updated.(model)
+	assert.Equal(t, "Cannot save to SOP: root shared is read-only", m.status)
+}
+
+func TestEditorCommand(t *testing.T) {
+	t.Setenv("EDITOR", "nano")
+	assert.Equal(t, []string{"nano", "+12", "deploy.md"}, editorCommand("", "deploy.md", 12).Args)
+	assert.Equal(t, []string{"nvim", "deploy.md"}, editorCommand("nvim", "deploy.md", 0).Args)
+	assert.Equal(t, []string{"code", "--wait", "--goto", "deploy.md:3"}, editorCommand("code --wait", "deploy.md", 3).Args)
+
+	t.Setenv("EDITOR", "")
+	assert.Equal(t, []string{"vi", "+1", "deploy.md"}, editorCommand("", "deploy.md", 1).Args)
+}
+
+func TestReloadSOP(t *testing.T) {
+	cfg := testConfig(t)
+	sopPath := filepath.Join(cfg.BaseDirectory, "deploy.md")
+	write := func(blocks ...string) {
+		content := "# Deploy\n"
+		for _, block := range blocks {
+			content += "\n```bash\n" + block + "\n```\n"
+		}
+		if err := os.WriteFile(sopPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("make build", "make test", "make deploy")
+
+	m := NewModel(&MockExecutor{}, &MockLogger{}, cfg)
+	m.mode = modeExecute
+	sop, err := m.loadSOP(sopPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.sop = sop
+	m.steps = newSOPSteps(sop)
+	m.session = newRunSession(sop)
+	m.steps[0].Status, m.steps[0].Output = statusSuccess, "built"
+	m.steps[1].Status = statusSuccess
+	m.sop.Steps[1].OriginalCommand = "make test"
+	m.sop.Steps[1].Command = "make test V=1"
+	m.steps[1].Command = "make test V=1"
+	m.steps[2].Status = statusError
+	m.currentStep = 2
+
+	// A step is inserted and the failed one fixed in the editor
+	write("make lint", "make build", "make test", "make deploy ENV=prod")
+	updated, _ := m.Update(editorFinishedMsg{path: sopPath})
+	m = updated.(model)
+
+	assert.Len(t, m.steps, 4)
+	assert.Equal(t, statusPending, m.steps[0].Status)
+	assert.Equal(t, statusSuccess, m.steps[1].Status)
+	assert.Equal(t, "built", m.steps[1].Output)
+	assert.Equal(t, 2, m.steps[1].ID)
+	assert.Equal(t, statusSuccess, m.steps[2].Status)
+	assert.Equal(t, "make test V=1", m.steps[2].Command) // The run's edit survives
+	assert.Equal(t, "make test", m.sop.Steps[2].OriginalCommand)
+	assert.Equal(t, statusPending, m.steps[3].Status)
+	assert.Equal(t, "make deploy ENV=prod", m.steps[3].Command)
+	assert.Equal(t, 3, m.currentStep)
+	assert.Equal(t, "Reloaded deploy.md (4 steps), 1 changed step(s) reset to pending", m.status)
+
+	// An editor that fails leaves the SOP alone
+	updated, _ = m.Update(editorFinishedMsg{path: sopPath, err: errors.New("exit status 1")})
+	m = updated.(model)
+	assert.Equal(t, "Editor failed: exit status 1", m.status)
+	assert.Len(t, m.steps, 4)
+}
+
+func TestReferenceBlocks(t *testing.T) {
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	m.width, m.height = 100, 40
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{{ID: 1, Title: "check", Command: "print('ok')", CommandType: "python"}},
+		References: []types.CodeBlock{
+			{Language: "yaml", Content: "replicas: 3", BeforeStep: 0},
+			{Language: "json", Content: `{"done": true}`, BeforeStep: 1},
+		},
+	}
+	m.steps = newSOPSteps(m.sop)
+
+	content, _ := m.renderExecutionContent()
+	assert.Contains(t, content, "runs with python")
+	assert.Contains(t, content, "Reference (yaml):")
+	assert.Contains(t, content, "replicas: 3")
+	assert.Less(t, strings.Index(content, "replicas: 3"), strings.Index(content, "print('ok')"))
+	assert.Greater(t, strings.Index(content, `{"done": true}`), strings.Index(content, "print('ok')"))
+}
+
+func TestDiffLines(t *testing.T) {
+	lines := diffLines("a\nb\nc", "a\nc\nd")
+	assert.Equal(t, []diffLine{{' ', "a"}, {'-', "b"}, {' ', "c"}, {'+', "d"}}, lines)
+
+	assert.Equal(t, []diffLine{{' ', "same"}}, diffLines("same", "same"))
+}
+
+func TestResumeRun(t *testing.T) {
+	cfg := testConfig(t)
+	sopPath := filepath.Join(cfg.BaseDirectory, "deploy.md")
+	sopContent := "---\nvars:\n  - name: HOST\n  - name: TABLES\n---\n# Deploy\n\n" +
+		"```bash\nping -c1 {{ .HOST }}\n```\n\n" +
+		"```bash\necho one \\\n  two\n```\n\n" +
+		"```bash\necho three\n```\n"
+	if err := os.WriteFile(sopPath, []byte(sopContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := logger.NewLogger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A run that stopped after an error in step 2
+	startedAt := time.Date(2025, 10, 9, 22, 37, 14, 0, time.Local)
+	execution := types.SOPExecution{
+		ID:        "run-42",
+		SOPName:   "Deploy",
+		SOPPath:   sopPath,
+		StartedAt: startedAt,
+		Status:    "running",
+		Variables: map[string]string{"HOST": "web1", "TABLES": "users, orders"},
+		ExecutionLog: []types.ExecutionStep{
+			{StepID: 1, OriginalStep: types.Step{ID: 1, Title: "Deploy", Command: "ping -c1 web1"},
+				ExecutionResult: &types.ExecutionResult{Status: "success", Output: "pong", ExecutedAt: startedAt}},
+			{StepID: 2, OriginalStep: types.Step{ID: 2, Title: "Deploy", Command: "echo one \\\n  two"},
+				ExecutionResult: &types.ExecutionResult{Status: "error", Output: "boom", Error: "exit status 1", ExecutedAt: startedAt}},
+			{StepID: 3, OriginalStep: types.Step{ID: 3, Title: "Deploy", Command: "echo three"}},
+		},
+	}
+	logPath, err := log.LogExecution(execution)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel(&MockExecutor{}, log, cfg)
+	m.width, m.height = 100, 40
+	cmd := m.resumeRun(logPath)
+	if !assert.NotNil(t, cmd, m.status) {
+		return
+	}
+	updated, _ := m.Update(cmd())
+	m = updated.(model)
+
+	assert.Equal(t, modeExecute, m.mode)
+	assert.Equal(t, "ping -c1 web1", m.steps[0].Command) // Variables re-applied
+	assert.Equal(t, statusSuccess, m.steps[0].Status)
+	assert.Equal(t, "pong", m.steps[0].Output)
+	assert.Equal(t, statusError, m.steps[1].Status)
+	assert.Equal(t, "exit status 1", m.steps[1].Error)
+	assert.Equal(t, statusPending, m.steps[2].Status)
+	assert.Equal(t, 1, m.currentStep) // First step that did not succeed
+	assert.Equal(t, "run-42", m.session.id)
+	assert.True(t, startedAt.Equal(m.session.startedAt))
+	assert.Equal(t, "users, orders", m.session.variables["TABLES"]) // Read from the sidecar as is
+
+	// Saving the resumed run rewrites the original log file
+	m.steps[1].Status = statusSuccess
+	m.saveExecutionLog(true)()
+	entries, _ := os.ReadDir(filepath.Dir(logPath))
+	assert.Len(t, entries, 2) // Markdown log and JSON sidecar
+	content, _ := os.ReadFile(logPath)
+	assert.Contains(t, string(content), "**SOP Run ID:** run-42")
+	assert.NotContains(t, string(content), "In Progress")
+
+	// Logs without a sidecar are resumed from the markdown
+	os.Remove(logger.JSONPath(logPath))
+	m = NewModel(&MockExecutor{}, log, cfg)
+	m.width, m.height = 100, 40
+	cmd = m.resumeRun(logPath)
+	if !assert.NotNil(t, cmd, m.status) {
+		return
+	}
+	updated, _ = m.Update(cmd())
+	m = updated.(model)
+	assert.Equal(t, "run-42", m.session.id)
+	assert.Equal(t, "web1", m.session.variables["HOST"])
+	assert.Equal(t, statusSuccess, m.steps[0].Status)
+	assert.Equal(t, statusSuccess, m.steps[1].Status)
+	assert.Equal(t, statusPending, m.steps[2].Status)
+}
+
+func TestLoadLogFile(t *testing.T) {
+	cfg := testConfig(t)
+	log, err := logger.NewLogger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startedAt := time.Date(2025, 10, 9, 22, 37, 14, 0, time.Local)
+	logPath, err := log.LogExecution(types.SOPExecution{
+		ID:        "run-7",
+		SOPName:   "Deploy",
+		SOPPath:   "/sops/deploy.md",
+		StartedAt: startedAt,
+		EndedAt:   startedAt.Add(time.Minute),
+		Status:    "failed",
+		ExecutionLog: []types.ExecutionStep{
+			{StepID: 1, OriginalStep: types.Step{ID: 1, Title: "Check", Command: "echo a\necho b"},
+				ExecutionResult: &types.ExecutionResult{Status: "timeout", Output: "a", ExecutedAt: startedAt}},
+			{StepID: 2, OriginalStep: types.Step{ID: 2, Title: "Deploy", Command: "deploy"}},
+			{StepID: 3, OriginalStep: types.Step{ID: 3, Title: "Edited", Command: "echo new", OriginalCommand: "echo old \\\n  --flag"},
+				ExecutionResult: &types.ExecutionResult{Status: "success", Output: "new", ExecutedAt: startedAt}},
+			{StepID: 4, OriginalStep: types.Step{ID: 4, Title: "Cleanup", Command: "cleanup"},
+				ExecutionResult: &types.ExecutionResult{Status: "skipped", ExecutedAt: startedAt}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both sources produce the same view
+	fromJSON, jsonSteps, err := LoadLogFile(logPath)
+	assert.NoError(t, err)
+	fromMarkdown, markdownSteps := ParseLogFile(mustRead(t, logPath))
+	assert.Equal(t, fromMarkdown, fromJSON)
+	assert.Equal(t, markdownSteps, jsonSteps)
+	assert.Equal(t, "⏰ Timeout", jsonSteps[0].Status)
+	assert.Equal(t, "", jsonSteps[1].Status)
+
+	// Edited commands keep the original, and the run lists its deviations
+	assert.Len(t, jsonSteps, 4)
+	assert.True(t, jsonSteps[2].Modified)
+	assert.Equal(t, "echo new", jsonSteps[2].Command)
+	assert.Equal(t, "echo old \\\n  --flag", jsonSteps[2].OriginalCommand)
+	assert.Equal(t, "new", jsonSteps[2].Output)
+	assert.False(t, jsonSteps[3].Modified)
+	assert.Equal(t, []string{"Step 3 (Edited): command modified", "Step 4 (Cleanup): skipped"}, fromJSON.Deviations)
+
+	// The sidecar wins when present
+	if err := os.WriteFile(logger.JSONPath(logPath), []byte(`{"title": "From JSON"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metadata, _, err := LoadLogFile(logPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "From JSON", metadata.Title)
+
+	// Old logs without a sidecar fall back to the markdown
+	os.Remove(logger.JSONPath(logPath))
+	metadata, _, err = LoadLogFile(logPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "Deploy", metadata.Title)
+}
+
+func TestResumeEditedStep(t *testing.T) {
+	sop := &types.SOP{Steps: []types.Step{
+		{ID: 1, Title: "one", Command: "echo old"},
+		{ID: 2, Title: "two", Command: "echo two"},
+	}}
+	logFile := &types.LogFile{Steps: []types.LogStep{
+		{StepID: 1, Command: "echo new", ResultStatus: statusSuccess, Modified: true, OriginalCommand: "echo old",
+			ExecutionResult: &types.ExecutionResult{Status: statusSuccess}},
+		{StepID: 2, Command: "echo two"},
+	}}
+
+	steps, changed := restoreSteps(sop, logFile)
+
+	assert.Equal(t, 0, changed)
+	assert.Equal(t, statusSuccess, steps[0].Status)
+	assert.Equal(t, "echo new", steps[0].Command)
+	assert.Equal(t, "echo new", sop.Steps[0].Command)
+	assert.Equal(t, "echo old", sop.Steps[0].OriginalCommand)
+	assert.Equal(t, statusPending, steps[1].Status)
+}
+
+func mustRead(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestTailOutput(t *testing.T) {
+	assert.Equal(t, "a\nb", tailOutput("a\nb", 3))
+	assert.Equal(t, "... (3 earlier lines)\nd\ne", tailOutput("a\nb\nc\nd\ne", 3))
+}
+
+func TestFormatElapsed(t *testing.T) {
+	assert.Equal(t, "0s", formatElapsed(200*time.Millisecond))
+	assert.Equal(t, "12s", formatElapsed(12400*time.Millisecond))
+	assert.Equal(t, "1m5s", formatElapsed(65*time.Second))
+}
+
+func TestRunStatus(t *testing.T) {
+	assert.Equal(t, "completed", runStatus([]SOPStep{{Status: statusSuccess}, {Status: statusSkipped}}))
+	assert.Equal(t, "failed", runStatus([]SOPStep{{Status: statusSuccess}, {Status: statusTimeout}}))
+	assert.Equal(t, "interrupted", runStatus([]SOPStep{{Status: statusError}, {Status: statusCancelled}}))
+	assert.Equal(t, "interrupted", runStatus([]SOPStep{{Status: statusSuccess}, {Status: statusPending}}))
+	assert.Equal(t, "interrupted", runStatus([]SOPStep{{Status: statusSuccess}, {Status: statusRunning}}))
+}
+
+func TestRunSessionContext(t *testing.T) {
+	var saved types.SOPExecution
+	m := NewModel(&MockExecutor{}, &recordingLogger{saved: &saved}, testConfig(t))
+	m.width, m.height = 100, 40
+	sop := &types.SOP{Title: "Deploy", Path: "/sops/deploy.md", Checksum: "abc123", Steps: []types.Step{{ID: 1, Command: "deploy"}}}
+
+	// Opening the SOP doesn't collect the context, the first save does
+	updated, _ := m.Update(enterModeMsg{mode: modeExecute, sop: sop, steps: newSOPSteps(sop)})
+	m = updated.(model)
+	assert.Empty(t, m.session.context.OpsyVersion)
+
+	m.steps[0].Status = statusSuccess
+	m.saveExecutionLog(false)()
+	assert.NotEmpty(t, saved.Context.OpsyVersion)
+	assert.Equal(t, saved.Context, m.session.context)
+	assert.Equal(t, "sha256:abc123", saved.Context.SOPChecksum) // The revision parsed when the run started
+}
+
+func TestQuitWhileRunning(t *testing.T) {
+	var saved types.SOPExecution
+	m := NewModel(&MockExecutor{}, &recordingLogger{saved: &saved}, testConfig(t))
+	m.mode = modeExecute
+	m.sop = &types.SOP{
+		Title: "Test SOP",
+		Steps: []types.Step{
+			{ID: 1, Title: "one", Command: "true"},
+			{ID: 2, Title: "two", Command: "sleep 60"},
+		},
+	}
+	m.steps = newSOPSteps(m.sop)
+	m.steps[0].Status = statusSuccess
+	m.startStep(1)
+	assert.True(t, m.running)
+
+	// Ctrl+C cancels the running step and logs the run as interrupted
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+	m = updated.(model)
+	assert.True(t, m.quitting)
+	assert.Equal(t, "interrupted", saved.Status)
+	assert.Equal(t, statusCancelled, saved.ExecutionLog[1].ExecutionResult.Status)
+	assert.False(t, saved.EndedAt.IsZero())
+}
+
+func TestLeaveWhileRunning(t *testing.T) {
+	var saved types.SOPExecution
+	m := NewModel(&MockExecutor{}, &recordingLogger{saved: &saved}, testConfig(t))
+	m.width, m.height = 100, 40
+	sopA := &types.SOP{Title: "A", Path: "/sops/a.md", Steps: []types.Step{{ID: 1, Title: "wait", Command: "sleep 100"}}}
+	updated, _ := m.Update(enterModeMsg{mode: modeExecute, sop: sopA, steps: newSOPSteps(sopA)})
+	m = updated.(model)
+	m.startStep(0)
+	seq := m.runSeq
+
+	// Going to the browser through the logs browser ends the run
+	updated, _ = m.Update(enterModeMsg{mode: modeLogs, from: modeExecute})
+	m = updated.(model)
+	assert.NotNil(t, m.session)
+	updated, cmd := m.Update(enterModeMsg{mode: modeBrowse})
+	m = updated.(model)
+	if msg := cmd(); msg != nil {
+		if batch, ok := msg.(tea.BatchMsg); ok {
+			for _, c := range batch {
+				if c != nil {
+					c()
+				}
+			}
+		}
+	}
+	assert.Nil(t, m.session)
+	assert.False(t, m.running)
+	assert.Equal(t, "interrupted", saved.Status)
+	assert.Equal(t, statusCancelled, saved.ExecutionLog[0].ExecutionResult.Status)
+	assert.False(t, saved.EndedAt.IsZero())
+
+	// A result of A arriving after B was opened does not touch B
+	sopB := &types.SOP{Title: "B", Path: "/sops/b.md", Steps: []types.Step{{ID: 1, Title: "wipe", Command: "rm -rf /srv/data"}}}
+	updated, _ = m.Update(enterModeMsg{mode: modeExecute, sop: sopB, steps: newSOPSteps(sopB)})
+	m = updated.(model)
+	updated, _ = m.Update(stepFinishedMsg{seq: seq, index: 0, result: &types.ExecutionResult{Status: statusSuccess, Output: "from A"}})
+	m = updated.(model)
+	assert.Equal(t, statusPending, m.steps[0].Status)
+	assert.Empty(t, m.steps[0].Output)
+}
+
+func TestDescribeSOP(t *testing.T) {
+	sop := &types.SOP{
+		Title:    "Backup Database",
+		Owner:    "dba-team",
+		Version:  "1.2",
+		Tags:     []string{"database", "backup"},
+		Severity: "high",
+	}
+	assert.Equal(t, "Backup Database · dba-team · v1.2 · #database #backup · high", describeSOP(sop, "backup.md"))
+	assert.Equal(t, "plain.md", describeSOP(&types.SOP{}, "plain.md"))
+}
+
+func TestVariableForm(t *testing.T) {
+	m := NewModel(&MockExecutor{}, &MockLogger{}, testConfig(t))
+	sop := &types.SOP{
+		Title: "Backup",
+		Variables: []types.Variable{
+			{Name: "DB_NAME", Default: "production"},
+			{Name: "DB_HOST", Required: true},
+		},
+		Steps: []types.Step{{ID: 1, Title: "pg_dump", Command: "pg_dump -h ${DB_HOST} -d {{ .DB_NAME }}"}},
+	}
+
+	m.startVariableForm(sop)
+	assert.Equal(t, modeVars, m.mode)
+	assert.Equal(t, "production", m.varInputs[0].Value())
+
+	// Submitting without the required value highlights the field
+	assert.Nil(t, m.submitVariables())
+	assert.Equal(t, 1, m.varFocus)
+	assert.Equal(t, "DB_HOST is required", m.varErrors[1])
+
+	m.varInputs[1].SetValue("db.internal")
+	cmd := m.submitVariables()
+	if assert.NotNil(t, cmd) {
+		msg := cmd().(enterModeMsg)
+		assert.Equal(t, modeExecute, msg.mode)
+		assert.Equal(t, "pg_dump -h db.internal -d production", msg.steps[0].Command)
+		assert.Equal(t, map[string]string{"DB_NAME": "production", "DB_HOST": "db.internal"}, msg.vars)
+	}
+}
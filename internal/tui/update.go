@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 
-	"opsy/internal/config"
+	"opsy/internal/logger"
 	"opsy/internal/parser"
 )
 
@@ -22,7 +25,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.width, m.height = msg.Width, msg.Height
-		
+
 		// Calculate content height for lists
 		listHeight := calculateViewportHeight(m.height)
 		m.fileList.SetSize(m.width, listHeight)
@@ -46,6 +49,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.updateViewportContent()
 			}
 		}
+		m.resizeEditor()
 
 		// Update log viewport dimensions if in log view mode
 		if m.mode == modeLogs && m.logViewReady {
@@ -60,6 +64,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.currentPath = msg.path
 		m.fileList = m.buildFileList(m.currentPath)
 		m.status = fmt.Sprintf("Directory changed to: %s", filepath.Base(msg.path))
+		if msg.path == rootListPath {
+			m.status = "Showing SOP roots"
+		}
 		// Ensure the list is properly sized and configured
 		if m.width > 0 && m.height > 0 {
 			listHeight := calculateViewportHeight(m.height)
@@ -68,13 +75,57 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.fileList.SetShowPagination(false)
 		}
 
+	case editorFinishedMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Editor failed: %v", msg.err)
+			break
+		}
+		switch {
+		case m.mode == modeExecute && m.sop != nil && isSOPFile(m.sop, msg.path):
+			m.reloadSOP()
+			m.updateViewportContent()
+		case m.mode == modeBrowse:
+			// Title and metadata shown in the list may have changed
+			selected := m.fileList.Index()
+			m.fileList = m.buildFileList(m.currentPath)
+			m.fileList.Select(selected)
+			if m.width > 0 && m.height > 0 {
+				m.fileList.SetSize(m.width, calculateViewportHeight(m.height))
+				m.fileList.SetShowHelp(false)
+				m.fileList.SetShowPagination(false)
+			}
+			m.status = fmt.Sprintf("Saved %s", filepath.Base(msg.path))
+		}
+
 	case enterModeMsg:
+		// Going back to the browser, however it is reached, or opening
+		// another run ends the open run, cancelling its running step
+		if m.session != nil && (msg.mode == modeBrowse || (msg.mode == modeExecute && msg.sop != nil)) {
+			cmds = append(cmds, m.endRun())
+		}
 		m.mode = msg.mode
 		if msg.status != "" {
 			m.status = msg.status
 		}
 		if msg.sop != nil {
 			m.sop = msg.sop
+			// Opening an SOP for execution starts a new run (or resumes one)
+			if msg.mode == modeExecute {
+				m.closeShell()
+				m.runSeq++ // Results of the previous SOP's steps are stale
+				m.session = msg.session
+				if m.session == nil {
+					m.session = newRunSession(msg.sop)
+					m.session.variables = msg.vars
+				}
+				m.currentStep = msg.step
+				m.runAll = false
+				m.confirmPending = false
+				m.manualPending = false
+				m.rollbackPending = false
+				m.rollingBack = false
+				m.sopEdit = nil
+			}
 		}
 		if msg.steps != nil {
 			m.steps = msg.steps
@@ -89,7 +140,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		// Initialize and refresh log list when entering logs mode
 		if msg.mode == modeLogs {
-			cfg := config.GetConfig()
+			cfg := m.config
 			// Store SOP path for returning to browse mode
 			m.sopPath = m.currentPath
 			// Store previous mode for proper return
@@ -135,10 +186,92 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+	case stepOutputMsg:
+		// Append live output to the running step, ignoring lines that arrive
+		// after the step already finished (the final result has the full output)
+		if m.running && msg.seq == m.runSeq && msg.index < len(m.steps) {
+			output := &m.steps[msg.index].Output
+			if m.rollingBack {
+				output = &m.steps[msg.index].RollbackOutput
+			}
+			if *output != "" {
+				*output += "\n"
+			}
+			*output += msg.line
+			m.updateViewportContent()
+		}
+		cmds = append(cmds, waitForOutput(msg.seq, msg.index, msg.output))
+
+	case stepFinishedMsg:
+		if msg.seq != m.runSeq {
+			break
+		}
+		m.running = false
+		if m.cancelRun != nil {
+			m.cancelRun() // Release the context
+			m.cancelRun = nil
+		}
+		// Rollback blocks record their result next to the step they undo
+		if m.rollingBack && msg.index < len(m.steps) {
+			cmds = append(cmds, m.finishRollback(msg)...)
+			break
+		}
+		if msg.index < len(m.steps) {
+			step := &m.steps[msg.index]
+			if msg.err != nil {
+				step.Status = statusError
+				step.Error = msg.err.Error()
+				step.ExecutedAt = time.Now()
+				m.status = fmt.Sprintf("Error executing step: %v", msg.err)
+			} else {
+				step.Status = msg.result.Status
+				step.Output = msg.result.Output
+				step.Error = msg.result.Error
+				step.Mismatches = msg.result.Mismatches
+				step.ExecutedAt = msg.result.ExecutedAt
+				if msg.result.Status == statusSuccess {
+					m.status = fmt.Sprintf("Step %d executed successfully", msg.index+1)
+				} else {
+					m.status = fmt.Sprintf("Step %d execution %s", msg.index+1, msg.result.Status)
+					if !m.runAll {
+						m.status += m.rollbackHint()
+					}
+				}
+			}
+		}
+		// Update viewport content to show execution results
+		m.updateViewportContent()
+
+		// Save incremental log after each command execution
+		cmds = append(cmds, m.saveExecutionLog(false))
+
+		// Carry on with the next step when running all remaining steps
+		if m.runAll && msg.index < len(m.steps) {
+			cmds = append(cmds, m.advanceRunAll(msg.index)...)
+		}
+
+	case logSavedMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error saving log: %v", msg.err)
+		}
+
+	case spinner.TickMsg:
+		// Keep the spinner and elapsed timer moving only while a step runs
+		if m.running {
+			m.spinner, cmd = m.spinner.Update(msg)
+			cmds = append(cmds, cmd)
+			m.refreshViewportContent()
+		}
+
 	case tea.KeyMsg:
 		// Global key bindings
 		switch msg.String() {
 		case "ctrl+c":
+			// Don't leave a running command behind when quitting, and finish
+			// the open run's log before exiting
+			if save := m.endRun(); save != nil {
+				save()
+			}
 			m.quitting = true
 			return m, tea.Quit
 		}
@@ -153,6 +286,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.handleLogKeys(msg, cmds)
 		case modeEdit:
 			return m.handleEditKeys(msg, cmds)
+		case modeVars:
+			return m.handleVarsKeys(msg, cmds)
 		}
 	}
 
@@ -168,6 +303,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case modeLogs:
 		m.logList, cmd = m.logList.Update(msg)
 		cmds = append(cmds, cmd)
+	case modeVars:
+		// Keep the focused input's cursor blinking
+		if m.varFocus < len(m.varInputs) {
+			m.varInputs[m.varFocus], cmd = m.varInputs[m.varFocus].Update(msg)
+			cmds = append(cmds, cmd)
+		}
+	case modeEdit:
+		// Keep the editor's cursor blinking
+		m.textarea, cmd = m.textarea.Update(msg)
+		cmds = append(cmds, cmd)
 	case modeExecute:
 		// Viewport is updated in handleExecuteKeys
 	}
@@ -178,10 +323,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 // handleBrowseKeys handles key events in browse mode
 func (m model) handleBrowseKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd) {
 	switch msg.String() {
-	case "h": // Go to home (base) directory
-		baseDir := config.DefaultBaseDirectory()
+	case "h": // Go to home (root list or base directory)
+		homeDir := m.homePath()
 		cmds = append(cmds, func() tea.Msg {
-			return browseToDirMsg{path: baseDir}
+			return browseToDirMsg{path: homeDir}
 		})
 		m.status = "Returned to base directory"
 	case "l": // Go to logs directory
@@ -194,15 +339,20 @@ func (m model) handleBrowseKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.
 			}
 		})
 		m.status = "Entering logs browser"
+	case "o": // Open the selected SOP in the editor
+		if selectedItem, ok := m.fileList.SelectedItem().(item); ok && !selectedItem.isDir {
+			if m.config.IsReadOnly(selectedItem.filePath) {
+				m.status = fmt.Sprintf("Cannot edit %s: its root is read-only", selectedItem.title)
+			} else {
+				cmds = append(cmds, m.openEditor(selectedItem.filePath, 0))
+			}
+		}
 	case "q": // In browse mode, 'q' should quit the app
 		m.quitting = true
 		return m, tea.Quit
 	case "backspace": // Go back to parent directory
 		// Navigate to parent directory when backspace is pressed
-		parentDir := filepath.Dir(m.currentPath)
-		baseDir := config.DefaultBaseDirectory()
-
-		if m.currentPath != baseDir && parentDir != m.currentPath {
+		if parentDir, ok := m.parentPath(m.currentPath); ok {
 			cmds = append(cmds, func() tea.Msg {
 				return browseToDirMsg{path: parentDir}
 			})
@@ -216,10 +366,7 @@ func (m model) handleBrowseKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.
 			// Check if this is a parent directory reference
 			if selectedItem.title == "../" {
 				// Handle parent directory case
-				parentDir := filepath.Dir(m.currentPath)
-				baseDir := config.DefaultBaseDirectory()
-
-				if m.currentPath != baseDir && parentDir != m.currentPath {
+				if parentDir, ok := m.parentPath(m.currentPath); ok {
 					cmds = append(cmds, func() tea.Msg {
 						return browseToDirMsg{path: parentDir}
 					})
@@ -235,20 +382,15 @@ func (m model) handleBrowseKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.
 				m.status = fmt.Sprintf("Entered: %s", selectedItem.title)
 			} else {
 				// Load SOP file
-				sop, err := parser.ParseSOP(selectedItem.filePath)
+				sop, err := m.loadSOP(selectedItem.filePath)
 				if err != nil {
 					m.status = fmt.Sprintf("Error loading SOP: %v", err)
+				} else if len(sop.Variables) > 0 {
+					// Prompt for variables before execution
+					m.startVariableForm(sop)
+					cmds = append(cmds, textinput.Blink)
 				} else {
-					steps := make([]SOPStep, len(sop.Steps))
-					for i, step := range sop.Steps {
-						steps[i] = SOPStep{
-							ID:          step.ID,
-							Title:       step.Title,
-							Description: step.Description,
-							Command:     step.Command,
-							Status:      "pending",
-						}
-					}
+					steps := newSOPSteps(sop)
 					cmds = append(cmds, func() tea.Msg {
 						return enterModeMsg{
 							mode:   modeExecute,
@@ -272,15 +414,81 @@ func (m model) handleBrowseKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.
 
 // handleExecuteKeys handles key events in execute mode
 func (m *model) handleExecuteKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "q": // 'q' in execute mode goes back to browse
+	// A step marked confirm=true runs only on y; any other key aborts
+	if m.confirmPending {
+		m.confirmPending = false
+		if msg.String() == "y" && m.rollingBack {
+			cmds = append(cmds, m.startRollback(m.currentStep)...)
+		} else if msg.String() == "y" {
+			cmds = append(cmds, m.startStep(m.currentStep)...)
+		} else if m.rollingBack {
+			m.rollingBack = false
+			m.status = fmt.Sprintf("Rollback stopped before step %d", m.currentStep+1)
+		} else if m.runAll {
+			m.runAll = false
+			m.status = fmt.Sprintf("Run all stopped before step %d", m.currentStep+1)
+		} else {
+			m.status = "Step not run"
+		}
+		m.updateViewportContent()
+		return *m, tea.Batch(cmds...)
+	}
+
+	// A manual step waits for the operator's answer and note
+	if m.manualPending {
+		return m.handleManualKeys(msg, cmds)
+	}
+
+	// Rolling back the succeeded steps starts only on y
+	if m.rollbackPending {
+		m.rollbackPending = false
+		if msg.String() == "y" {
+			m.rollingBack = true
+			cmds = append(cmds, m.nextRollback()...)
+		} else {
+			m.status = "Rollback cancelled"
+		}
+		m.updateViewportContent()
+		return *m, tea.Batch(cmds...)
+	}
+
+	// An edit written back to the SOP file is saved only on y
+	if m.sopEdit != nil {
+		edit := m.sopEdit
+		m.sopEdit = nil
+		if msg.String() == "y" {
+			if err := edit.Apply(m.sop); err != nil {
+				m.status = fmt.Sprintf("Cannot save to SOP: %v", err)
+			} else {
+				m.steps[edit.Index].SavedCommand = edit.New
+				m.status = fmt.Sprintf("Saved step %d to %s", edit.Index+1, filepath.Base(m.sop.Path))
+			}
+		} else {
+			m.status = "SOP not changed"
+		}
+		m.updateViewportContent()
+		return *m, tea.Batch(cmds...)
+	}
+
+	switch k := msg.String(); {
+	case key.Matches(msg, m.keys.Back): // Back (q) in execute mode goes back to browse
+		if m.running {
+			m.status = fmt.Sprintf("Step %d is still running", m.runningStep+1)
+			break
+		}
+		// Leaving execute mode ends the run
+		status := "Returned to SOP browser"
+		if n := len(logger.Deviations(m.executionLog())); n > 0 {
+			status = fmt.Sprintf("Returned to SOP browser (run logged with %d deviation(s) from the SOP)", n)
+		}
+		cmds = append(cmds, m.endRun())
 		cmds = append(cmds, func() tea.Msg {
 			return enterModeMsg{
 				mode:   modeBrowse,
-				status: "Returned to SOP browser",
+				status: status,
 			}
 		})
-	case "up", "k": // Navigate up to previous step
+	case k == "up" || k == "k": // Navigate up to previous step
 		if m.currentStep > 0 {
 			m.currentStep--
 			m.manualScrollActive = false // Re-enable auto-scroll on step navigation
@@ -290,7 +498,7 @@ func (m *model) handleExecuteKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, te
 		} else {
 			m.status = "Already at top"
 		}
-	case "down", "j": // Navigate down to next step
+	case k == "down" || k == "j": // Navigate down to next step
 		if m.currentStep < len(m.steps)-1 {
 			m.currentStep++
 			m.manualScrollActive = false // Re-enable auto-scroll on step navigation
@@ -300,22 +508,27 @@ func (m *model) handleExecuteKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, te
 		} else {
 			m.status = "Already at last step"
 		}
-	case "ctrl+u": // Scroll view up by half page
+	case k == "ctrl+u": // Scroll view up by half page
 		m.viewport.HalfViewUp()
 		m.manualScrollActive = true // Disable auto-scroll on manual scroll
 		m.status = "Scrolled up"
-	case "ctrl+d": // Scroll view down by half page
+	case k == "ctrl+d": // Scroll view down by half page
 		m.viewport.HalfViewDown()
 		m.manualScrollActive = true // Disable auto-scroll on manual scroll
 		m.status = "Scrolled down"
+	case key.Matches(msg, m.keys.Run, m.keys.RunAll, m.keys.Cancel, m.keys.Edit, m.keys.Save,
+		m.keys.Open, m.keys.Skip, m.keys.Rollback, m.keys.Logs):
+		// Handle execute mode commands (run, cancel, edit, skip, logs). They
+		// don't reach the viewport, whose own keys (b pages up) would scroll it
+		cmds = append(cmds, m.handleExecuteCommands(msg)...)
 	default:
-		// Handle execute mode commands (enter, e, s, l)
-		executeCmds := m.handleExecuteCommands(msg)
-		cmds = append(cmds, executeCmds...)
-
-		// Also let viewport handle other keys
+		// Let viewport handle other keys
 		var cmd tea.Cmd
+		prevOffset := m.viewport.YOffset
 		m.viewport, cmd = m.viewport.Update(msg)
+		if m.viewport.YOffset != prevOffset {
+			m.manualScrollActive = true // Stop auto-follow once the user scrolls
+		}
 		cmds = append(cmds, cmd)
 	}
 	return *m, tea.Batch(cmds...)
@@ -328,14 +541,13 @@ func (m model) handleLogKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd
 		if m.logViewReady {
 			// Exit log view mode and return to log list
 			m.logViewReady = false
-			m.logViewContent = ""
 			m.logViewPath = ""
 			m.status = "Returned to log list"
 		} else {
 			// Return to previous mode
 			var returnMode string
 			var status string
-			
+
 			// Determine which mode to return to based on where we came from
 			switch m.previousMode {
 			case modeExecute:
@@ -345,7 +557,7 @@ func (m model) handleLogKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd
 				returnMode = modeBrowse
 				status = "Returned to SOP browser"
 			}
-			
+
 			cmds = append(cmds, func() tea.Msg {
 				return enterModeMsg{
 					mode:   returnMode,
@@ -353,6 +565,33 @@ func (m model) handleLogKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd
 				}
 			})
 		}
+	case "r": // Resume the viewed or selected run
+		logPath := m.logViewPath
+		if !m.logViewReady {
+			if selectedItem, ok := m.logList.SelectedItem().(item); ok && !selectedItem.isDir {
+				logPath = selectedItem.filePath
+			}
+		}
+		if logPath == "" {
+			m.status = "Select a log to resume"
+			break
+		}
+		if m.running {
+			m.status = fmt.Sprintf("Step %d is still running", m.runningStep+1)
+			break
+		}
+		if cmd := m.resumeRun(logPath); cmd != nil {
+			// Finish the run that was open in execute mode, if any
+			cmds = append(cmds, m.endRun())
+			m.logViewReady = false
+			m.logViewPath = ""
+			// Going back from the resumed run returns to the SOP browser
+			if m.sopPath != "" {
+				m.currentPath = m.sopPath
+				m.sopPath = ""
+			}
+			cmds = append(cmds, cmd)
+		}
 	case "h": // Go to home (base) directory from logs mode
 		cmds = append(cmds, func() tea.Msg {
 			return enterModeMsg{
@@ -364,7 +603,7 @@ func (m model) handleLogKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd
 	case "backspace": // Go back to parent directory in logs mode
 		// Navigate to parent directory when backspace is pressed
 		parentDir := filepath.Dir(m.currentPath)
-		cfg := config.GetConfig()
+		cfg := m.config
 		logsDir := cfg.LogDirectory
 
 		if m.currentPath != logsDir && parentDir != m.currentPath {
@@ -390,9 +629,9 @@ func (m model) handleLogKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd
 				// Check if this is a parent directory reference
 				if selectedItem.title == "../" {
 					// Handle parent directory case
-					cfg := config.GetConfig()
+					cfg := m.config
 					parentDir := filepath.Dir(m.currentPath)
-					
+
 					// Only go back if not at the log root directory
 					if m.currentPath != cfg.LogDirectory && parentDir != m.currentPath {
 						// Build log list for parent directory
@@ -422,25 +661,22 @@ func (m model) handleLogKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd
 						m.logList.SetShowPagination(false)
 					}
 				} else {
-					// View log file content
-					content, err := os.ReadFile(selectedItem.filePath)
+					// View log file content (JSON sidecar when present, else markdown)
+					metadata, steps, err := LoadLogFile(selectedItem.filePath)
 					if err != nil {
 						m.status = fmt.Sprintf("Error reading log file: %v", err)
 					} else {
-						// Store the log content and path
-						m.logViewContent = string(content)
+						// Store the structured log and path
 						m.logViewPath = selectedItem.filePath
-						
-						// Parse log file into structured data
-						m.logMetadata, m.logSteps = ParseLogFile(m.logViewContent)
+						m.logMetadata, m.logSteps = metadata, steps
 						m.currentLogStep = 0
-						
+
 						// Switch to log viewing mode (using a viewport)
 						viewportHeight := calculateViewportHeight(m.height)
 						m.logViewPort = viewport.New(m.width, viewportHeight)
 						m.logViewPort.YPosition = 0
 						m.logViewReady = true
-						
+
 						// Render log content with execute-mode-like styling
 						m.updateLogViewportContent()
 						m.status = fmt.Sprintf("Viewing log: %s", selectedItem.title)
@@ -542,26 +778,55 @@ func (m model) handleLogKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd
 }
 
 // handleEditKeys handles key events in edit mode
+// ctrl+s shows a diff against the original command, which is saved with enter
 func (m model) handleEditKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd) {
+	if m.editReview {
+		switch msg.String() {
+		case "enter", "y":
+			// Save the edited command to both the temporary steps and original SOP
+			if m.currentStep < len(m.steps) && m.currentStep < len(m.sop.Steps) {
+				editedCommand := m.editedCommand()
+				m.steps[m.currentStep].Command = editedCommand
+				// Also update the original SOP step so execution uses the edited
+				// command, keeping the SOP's version for the log
+				step := &m.sop.Steps[m.currentStep]
+				if step.OriginalCommand == "" {
+					step.OriginalCommand = step.Command
+				}
+				step.Command = editedCommand
+				m.textarea.Blur()
+				cmds = append(cmds, func() tea.Msg {
+					return enterModeMsg{
+						mode:   modeExecute,
+						status: "Command updated",
+					}
+				})
+			}
+		case "esc":
+			// Back to the editor
+			m.editReview = false
+			m.status = "Editing command"
+		}
+		return m, tea.Batch(cmds...)
+	}
+
 	switch msg.String() {
-	case "enter":
-		// Save the edited command to both the temporary steps and original SOP
-		if m.currentStep < len(m.steps) && m.currentStep < len(m.sop.Steps) {
-			editedCommand := m.textInput.Value()
-			m.steps[m.currentStep].Command = editedCommand
-			// Also update the original SOP step so execution uses the edited command
-			m.sop.Steps[m.currentStep].Command = editedCommand
+	case "ctrl+s":
+		if m.editedCommand() == m.editOriginal {
+			m.textarea.Blur()
 			cmds = append(cmds, func() tea.Msg {
 				return enterModeMsg{
 					mode:   modeExecute,
-					status: "Command updated",
+					status: "No changes",
 				}
 			})
-			// Update viewport to show the change
-			m.updateViewportContent()
+			break
 		}
+		m.editReview = true
+		m.status = "Review changes: enter to save, esc to keep editing"
 	case "esc":
 		// Cancel editing
+		m.textarea.Blur()
 		cmds = append(cmds, func() tea.Msg {
 			return enterModeMsg{
 				mode:   modeExecute,
@@ -570,71 +835,150 @@ func (m model) handleEditKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cm
 		})
 	default:
 		var cmd tea.Cmd
-		m.textInput, cmd = m.textInput.Update(msg)
+		m.textarea, cmd = m.textarea.Update(msg)
 		cmds = append(cmds, cmd)
 	}
 	return m, tea.Batch(cmds...)
 }
 
+// editedCommand returns the editor contents without trailing blank lines
+func (m model) editedCommand() string {
+	return strings.TrimRight(m.textarea.Value(), "\n")
+}
+
+// resizeEditor fits the command editor to the content area
+func (m *model) resizeEditor() {
+	if m.width <= 0 || m.height <= 0 {
+		return
+	}
+	m.textarea.SetWidth(m.width - 4)
+	m.textarea.SetHeight(calculateViewportHeight(m.height) - 2) // Leave room for the title line
+}
+
+// prepareSOPEdit prepares writing the edited command of step index back to
+// the SOP file; the change is shown as a diff and written only on y
+func (m *model) prepareSOPEdit(index int) {
+	step := m.sop.Steps[index]
+	expected := fileCommand(step, m.steps[index])
+	if expected == step.Command {
+		m.status = fmt.Sprintf("Step %d has no unsaved edits", index+1)
+		return
+	}
+	if m.sop.ReadOnly {
+		m.status = fmt.Sprintf("Cannot save to SOP: root %s is read-only", m.sop.Root)
+		return
+	}
+
+	edit, err := parser.PrepareStepEdit(m.sop, index, expected, step.Command)
+	if err != nil {
+		m.status = fmt.Sprintf("Cannot save to SOP: %v", err)
+		return
+	}
+	m.sopEdit = edit
+	m.status = fmt.Sprintf("Save step %d to %s: press y to write, any other key to cancel", index+1, filepath.Base(m.sop.Path))
+}
+
 // handleExecuteCommands handles command execution keys
 func (m *model) handleExecuteCommands(msg tea.KeyMsg) []tea.Cmd {
 	var cmds []tea.Cmd
 
-	switch msg.String() {
-	case "enter", " ":
-		// Run current step (no auto-advance)
-		if m.currentStep < len(m.steps) {
-			step := m.sop.Steps[m.currentStep]
-			result, err := m.executor.ExecuteStep(step)
-			if err != nil {
-				m.steps[m.currentStep].Status = statusError
-				m.steps[m.currentStep].Error = err.Error()
-				m.steps[m.currentStep].ExecutedAt = time.Now()
-				m.status = fmt.Sprintf("Error executing step: %v", err)
+	switch {
+	case key.Matches(msg, m.keys.Run):
+		// Run current step in the background (no auto-advance)
+		if m.running {
+			m.status = fmt.Sprintf("Step %d is still running", m.runningStep+1)
+		} else if m.currentStep < len(m.steps) {
+			if reason := m.confirmationReason(m.sop.Steps[m.currentStep]); reason != "" {
+				m.confirmPending = true
+				m.confirmReason = reason
+				m.status = fmt.Sprintf("Step %d requires %s: press y to run, any other key to abort", m.currentStep+1, reason)
+				m.updateViewportContent()
 			} else {
-				m.steps[m.currentStep].Status = result.Status
-				m.steps[m.currentStep].Output = result.Output
-				m.steps[m.currentStep].ExecutedAt = result.ExecutedAt
-				if result.Status == statusSuccess {
-					m.status = "Step executed successfully"
-				} else {
-					m.status = fmt.Sprintf("Step execution %s", result.Status)
-				}
+				cmds = append(cmds, m.startStep(m.currentStep)...)
 			}
-			// Update viewport content to show execution results
-			m.updateViewportContent()
-			
-			// Save incremental log after each command execution
-			cmds = append(cmds, m.saveExecutionLog())
 		}
-	case "e":
+	case key.Matches(msg, m.keys.RunAll):
+		// Run all remaining steps from the current one
+		if m.running {
+			m.status = fmt.Sprintf("Step %d is still running", m.runningStep+1)
+		} else {
+			m.runAll = true
+			cmds = append(cmds, m.runAllFrom(m.currentStep)...)
+		}
+	case key.Matches(msg, m.keys.Cancel):
+		// Cancel the running step (kills its whole process group)
+		if m.running && m.cancelRun != nil {
+			m.cancelRun()
+			m.status = fmt.Sprintf("Cancelling step %d...", m.runningStep+1)
+		} else {
+			m.status = "No step is running"
+		}
+	case key.Matches(msg, m.keys.Edit):
 		// Edit command
-		if m.currentStep < len(m.steps) {
-			m.textInput.SetValue(m.steps[m.currentStep].Command)
+		if m.running && m.currentStep == m.runningStep {
+			m.status = "Cannot edit a running step"
+		} else if m.currentStep < len(m.sop.Steps) && m.sop.Steps[m.currentStep].Manual {
+			m.status = fmt.Sprintf("Step %d is a manual step, it has no command to edit", m.currentStep+1)
+		} else if m.currentStep < len(m.steps) {
+			m.editOriginal = m.steps[m.currentStep].Command
+			m.editReview = false
+			m.textarea.SetValue(m.editOriginal)
+			m.resizeEditor()
 			cmds = append(cmds, func() tea.Msg {
 				return enterModeMsg{
 					mode:   modeEdit,
 					status: m.status,
 				}
 			})
-			cmds = append(cmds, textinput.Blink)
+			cmds = append(cmds, m.textarea.Focus())
 		}
-	case "s":
-		// Skip current step (no auto-advance)
+	case key.Matches(msg, m.keys.Save):
+		// Write the current step's edited command back to the SOP file
 		if m.currentStep < len(m.steps) {
+			m.prepareSOPEdit(m.currentStep)
+			m.updateViewportContent()
+		}
+	case key.Matches(msg, m.keys.Open):
+		// Fix the SOP itself in the editor, at the current step; included
+		// steps open the file they come from
+		path, line := m.sop.Path, 0
+		if m.currentStep < len(m.sop.Steps) {
+			line = m.sop.Steps[m.currentStep].LineNumber
+			if source := m.sop.Steps[m.currentStep].Source; source != "" {
+				path = source
+			}
+		}
+		if m.running {
+			m.status = fmt.Sprintf("Step %d is still running", m.runningStep+1)
+		} else if path == m.sop.Path && m.sop.ReadOnly {
+			m.status = fmt.Sprintf("Cannot edit SOP: root %s is read-only", m.sop.Root)
+		} else if path != m.sop.Path && m.config.IsReadOnly(path) {
+			m.status = fmt.Sprintf("Cannot edit %s: its root is read-only", filepath.Base(path))
+		} else {
+			cmds = append(cmds, m.openEditor(path, line))
+		}
+	case key.Matches(msg, m.keys.Skip):
+		// Skip current step (no auto-advance)
+		if m.running && m.currentStep == m.runningStep {
+			m.status = "Cannot skip a running step"
+		} else if m.currentStep < len(m.steps) {
 			m.steps[m.currentStep].Status = statusSkipped
 			m.status = "Step skipped"
 			// Update viewport content to show skip
 			m.updateViewportContent()
 		}
-	case "l":
+	case key.Matches(msg, m.keys.Rollback):
+		// Undo the succeeded steps of a failed run with their rollback blocks
+		m.promptRollback()
+		m.updateViewportContent()
+	case key.Matches(msg, m.keys.Logs):
 		// Go to logs browser
 		cmds = append(cmds, func() tea.Msg {
 			return enterModeMsg{
 				mode:   modeLogs,
 				status: "Entered logs browser",
 				path:   filepath.Dir(m.sop.Path), // Pass SOP directory path for logs filtering
-				from:   m.mode,                  // Pass current mode as previous mode
+				from:   m.mode,                   // Pass current mode as previous mode
 			}
 		})
 	}
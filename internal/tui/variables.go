@@ -0,0 +1,167 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"opsy/internal/parser"
+	"opsy/internal/types"
+)
+
+// startVariableForm switches to variables mode to prompt for the SOP's declared variables
+func (m *model) startVariableForm(sop *types.SOP) {
+	m.varSOP = sop
+	m.varInputs = make([]textinput.Model, len(sop.Variables))
+	m.varErrors = make([]string, len(sop.Variables))
+	m.varFocus = 0
+
+	for i, v := range sop.Variables {
+		ti := textinput.New()
+		ti.Placeholder = v.Name
+		ti.CharLimit = 256
+		ti.Width = 60
+		ti.SetValue(v.Default)
+		m.varInputs[i] = ti
+	}
+	m.focusVariable(0)
+
+	m.mode = modeVars
+	m.status = fmt.Sprintf("Enter variables for: %s", sop.Title)
+}
+
+// focusVariable moves input focus to the variable at index
+func (m *model) focusVariable(index int) {
+	if index < 0 || index >= len(m.varInputs) {
+		return
+	}
+	for i := range m.varInputs {
+		m.varInputs[i].Blur()
+	}
+	m.varFocus = index
+	m.varInputs[index].Focus()
+}
+
+// handleVarsKeys handles key events in variables mode
+func (m model) handleVarsKeys(msg tea.KeyMsg, cmds []tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.varSOP = nil
+		cmds = append(cmds, func() tea.Msg {
+			return enterModeMsg{
+				mode:   modeBrowse,
+				status: "Variable entry cancelled",
+			}
+		})
+	case "tab", "down":
+		m.focusVariable((m.varFocus + 1) % len(m.varInputs))
+	case "shift+tab", "up":
+		m.focusVariable((m.varFocus - 1 + len(m.varInputs)) % len(m.varInputs))
+	case "enter":
+		// Enter moves through the fields and submits on the last one
+		if m.varFocus < len(m.varInputs)-1 {
+			m.focusVariable(m.varFocus + 1)
+		} else {
+			cmds = append(cmds, m.submitVariables())
+		}
+	default:
+		var cmd tea.Cmd
+		m.varInputs[m.varFocus], cmd = m.varInputs[m.varFocus].Update(msg)
+		m.varErrors[m.varFocus] = ""
+		cmds = append(cmds, cmd)
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// submitVariables validates the form and opens the SOP with the values applied
+// Returns nil and highlights the first invalid field if validation fails
+func (m *model) submitVariables() tea.Cmd {
+	values := make(map[string]string, len(m.varInputs))
+	firstInvalid := -1
+	for i, v := range m.varSOP.Variables {
+		value := strings.TrimSpace(m.varInputs[i].Value())
+		m.varErrors[i] = ""
+		if err := parser.ValidateVariable(v, value); err != nil {
+			m.varErrors[i] = err.Error()
+			if firstInvalid < 0 {
+				firstInvalid = i
+			}
+		}
+		values[v.Name] = value
+	}
+	if firstInvalid >= 0 {
+		m.focusVariable(firstInvalid)
+		m.status = m.varErrors[firstInvalid]
+		return nil
+	}
+
+	resolved, err := parser.ResolveVariables(m.varSOP.Variables, values)
+	if err != nil {
+		m.status = err.Error()
+		return nil
+	}
+
+	sop := m.varSOP
+	parser.ApplyVariables(sop, resolved)
+	steps := newSOPSteps(sop)
+	m.varSOP = nil
+
+	return func() tea.Msg {
+		return enterModeMsg{
+			mode:   modeExecute,
+			status: fmt.Sprintf("Loaded SOP: %s", sop.Title),
+			sop:    sop,
+			steps:  steps,
+			vars:   resolved,
+		}
+	}
+}
+
+// renderVariablesView renders the variables form
+func (m model) renderVariablesView() string {
+	if m.varSOP == nil {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	builder.WriteString(renderTitleHeader(m.varSOP.Title, m.width))
+
+	nameStyle := lipgloss.NewStyle().
+		Foreground(colorAccent).
+		Bold(true).
+		PaddingLeft(2)
+	descStyle := lipgloss.NewStyle().
+		Foreground(colorFaint)
+	errorStyle := lipgloss.NewStyle().
+		Foreground(colorError).
+		PaddingLeft(4)
+
+	for i, v := range m.varSOP.Variables {
+		label := v.Name
+		if v.Required {
+			label += " *"
+		}
+		line := nameStyle.Render(label)
+		if v.Description != "" {
+			line += " " + descStyle.Render(v.Description)
+		}
+		builder.WriteString(line + "\n")
+
+		indicator := "    "
+		if i == m.varFocus {
+			indicator = "  ▶ "
+		}
+		builder.WriteString(indicator + m.varInputs[i].View() + "\n")
+
+		if m.varErrors[i] != "" {
+			builder.WriteString(errorStyle.Render(m.varErrors[i]) + "\n")
+		}
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
@@ -52,15 +52,24 @@ func (m model) View() string {
 		editContent := m.renderEditView()
 		helpBar := m.renderEditHelpBar()
 		content = editContent + "\n\n" + helpBar
+	case modeVars:
+		// Show variables form with help bar
+		varsContent := m.renderVariablesView()
+		helpBar := m.renderVarsHelpBar()
+		content = varsContent + "\n\n" + helpBar
 	}
 
 	return header + "\n" + content
 }
 
-// renderEditView renders the edit mode view
+// renderEditView renders the edit mode view: the editor, or the diff
+// against the original command while the edit is being reviewed
 func (m model) renderEditView() string {
 	content := fmt.Sprintf("Editing Step %d/%d\n", m.currentStep+1, len(m.steps))
-	content += m.textInput.View()
+	if m.editReview {
+		return content + "\n" + renderCommandDiff(m.editOriginal, m.editedCommand(), m.width)
+	}
+	content += m.textarea.View()
 	return content
 }
 
@@ -69,9 +78,9 @@ func (m model) renderBrowseHelpBar() string {
 	helpStyle := statusBarStyle.Copy().
 		Width(m.width).
 		Foreground(colorFaint)
-	
+
 	// Short help only - consistent, concise text
-	helpText := "↑↓ nav · ←/bs back · enter select · h home · l logs · q quit"
+	helpText := "↑↓ nav · ←/bs back · enter select · o open · h home · l logs · q quit"
 	return helpStyle.Render(helpText)
 }
 
@@ -80,16 +89,16 @@ func (m model) renderLogsHelpBar() string {
 	helpStyle := statusBarStyle.Copy().
 		Width(m.width).
 		Foreground(colorFaint)
-	
+
 	// Short help only - consistent, concise text
 	if m.logViewReady {
 		// Help text when viewing a log file (execute-mode-like navigation)
-		helpText := "↑↓ nav steps · ctrl+u/d scroll · q back"
+		helpText := "↑↓ nav steps · ctrl+u/d scroll · r resume · q back"
 		return helpStyle.Render(helpText)
 	}
-	
+
 	// Help text when browsing log files
-	helpText := "↑↓ nav · ←/bs back · enter select · q back"
+	helpText := "↑↓ nav · ←/bs back · enter select · r resume · q back"
 	return helpStyle.Render(helpText)
 }
 
@@ -98,19 +107,38 @@ func (m model) renderExecuteHelpBar() string {
 	helpStyle := statusBarStyle.Copy().
 		Width(m.width).
 		Foreground(colorFaint)
-	
+
 	// Short help only - consistent, concise text
-	helpText := "↑↓ nav · enter run · e edit · s skip · l logs · q back"
+	helpText := m.keys.executeHelp()
 	return helpStyle.Render(helpText)
 }
 
+// renderVarsHelpBar renders help bar for variables mode
+func (m model) renderVarsHelpBar() string {
+	helpStyle := statusBarStyle.Copy().
+		Width(m.width).
+		Foreground(colorFaint)
+
+	// Short help only - consistent, concise text
+	helpText := "tab/↑↓ field · enter next/run · esc cancel"
+	return helpStyle.Render(helpText)
+}
+
+// editHelp returns the help text for the editor or the review of an edit
+func (m model) editHelp() string {
+	if m.editReview {
+		return "enter save · esc keep editing"
+	}
+	return "enter newline · ctrl+s review & save · esc cancel"
+}
+
 // renderEditHelpBar renders help bar for edit mode
 func (m model) renderEditHelpBar() string {
 	helpStyle := statusBarStyle.Copy().
 		Width(m.width).
 		Foreground(colorFaint)
-	
+
 	// Short help only - consistent, concise text
-	helpText := "enter save · esc cancel"
+	helpText := m.editHelp()
 	return helpStyle.Render(helpText)
 }
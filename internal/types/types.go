@@ -6,73 +6,196 @@ import (
 
 // SOP represents a Standard Operating Procedure
 type SOP struct {
-	Name        string     `json:"name"`
-	Path        string     `json:"path"`
-	Title       string     `json:"title"`
-	Description string     `json:"description"`
-	Steps       []Step     `json:"steps"`
-	Modified    time.Time  `json:"modified"`
+	Name        string      `json:"name"`
+	Path        string      `json:"path"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Steps       []Step      `json:"steps"`
+	References  []CodeBlock `json:"references,omitempty"` // Code blocks shown but never executed
+	Modified    time.Time   `json:"modified"`
+	Checksum    string      `json:"checksum,omitempty"` // SHA-256 of the file when it was parsed
+
+	// Metadata from the YAML front matter
+	Owner           string        `json:"owner,omitempty"`
+	Tags            []string      `json:"tags,omitempty"`
+	Version         string        `json:"version,omitempty"`
+	RequiredTools   []string      `json:"required_tools,omitempty"`
+	Timeout         time.Duration `json:"timeout,omitempty"`  // Default timeout for every step
+	Severity        string        `json:"severity,omitempty"` // e.g. "low", "medium", "high", "critical"
+	Variables       []Variable    `json:"variables,omitempty"`
+	ExecutionMode   string        `json:"execution_mode,omitempty"`   // ExecutionIsolated (default) or ExecutionPersistent
+	SessionLanguage string        `json:"session_language,omitempty"` // Shell language a persistent session runs, "" for the configured shell
+
+	// Where the SOP was found, set by the caller from the configured roots
+	Root     string `json:"root,omitempty"`      // Name of the SOP root
+	ReadOnly bool   `json:"read_only,omitempty"` // The root must never be written to
+}
+
+// Execution modes selectable in the SOP front matter
+const (
+	ExecutionIsolated   = "isolated"   // Every step runs in a fresh shell
+	ExecutionPersistent = "persistent" // All steps of a run share one shell process
+)
+
+// CodeBlock is a fenced block in a language without an interpreter, e.g. a
+// YAML manifest or a JSON payload, kept as reference content for a step
+type CodeBlock struct {
+	Language   string `json:"language,omitempty"`
+	Content    string `json:"content"`
+	LineNumber int    `json:"line_number"`      // Line of the opening fence
+	BeforeStep int    `json:"before_step"`      // Index of the step it precedes; len(Steps) after the last step
+	Source     string `json:"source,omitempty"` // Included file the block comes from; empty for the SOP itself
+}
+
+// Variable is an input declared by an SOP and referenced in its code blocks
+// as {{ .NAME }} or ${NAME}
+type Variable struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description"`
+	Default     string `json:"default,omitempty" yaml:"default"`
+	Required    bool   `json:"required,omitempty" yaml:"required"`
+	Pattern     string `json:"pattern,omitempty" yaml:"pattern"` // Regex the value must match
 }
 
 // Step represents a single step in an SOP
 type Step struct {
-	ID          int    `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Command     string `json:"command"`      // The actual command to execute
-	CommandType string `json:"command_type"` // "bash", "shell", etc.
-	Executed    bool   `json:"executed"`
+	ID          int              `json:"id"`
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Command     string           `json:"command"`      // The actual command to execute
+	CommandType string           `json:"command_type"` // Code block language, e.g. "bash" or "python"
+	Executed    bool             `json:"executed"`
 	Result      *ExecutionResult `json:"result,omitempty"`
-	LineNumber  int    `json:"line_number"`  // Line number in the original markdown file
+	LineNumber  int              `json:"line_number"`       // Line number in the original markdown file
+	Timeout     time.Duration    `json:"timeout,omitempty"` // Overrides the executor timeout when set
+
+	// Annotations from the fence info string, e.g. ```bash {confirm=true id=dump}
+	Name            string `json:"name,omitempty"`              // Optional identifier (id=...)
+	Confirm         bool   `json:"confirm,omitempty"`           // Ask before running the step
+	ContinueOnError bool   `json:"continue_on_error,omitempty"` // A failure does not stop the run
+
+	// Set when the operator edits Command during a run
+	OriginalCommand string `json:"original_command,omitempty"` // Command as written in the SOP
+
+	// Manual steps are checklist items the operator performs by hand; they
+	// have no Command and are answered done, skipped or failed
+	Manual bool `json:"manual,omitempty"`
+
+	// Assertions from the ```expect block following the code block, checked
+	// by the executor once the command has finished
+	Expectations []Expectation `json:"expectations,omitempty"`
+
+	// Code that undoes the step, from a ```bash rollback block following it
+	// It has the ID and title of the step it rolls back
+	Rollback *Step `json:"rollback,omitempty"`
+
+	// Provenance of steps spliced in by an include directive: the file the
+	// step was read from, with LineNumber a line of that file. Empty for
+	// steps of the SOP file itself
+	Source string `json:"source,omitempty"`
+}
+
+// Expectation kinds, written as "kind: value" lines in an ```expect block
+const (
+	ExpectExitCode    = "exit_code"    // The command exits with this code instead of 0
+	ExpectContains    = "contains"     // The output contains the text
+	ExpectNotContains = "not_contains" // The output does not contain the text
+	ExpectMatches     = "matches"      // The output matches the regex, ^ and $ match at line breaks
+	ExpectJSON        = "json"         // The output is JSON with a value at Path
+)
+
+// Expectation is an assertion on the result of a step
+type Expectation struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value,omitempty"` // Exit code, text, regex, or the JSON encoded value expected at Path
+	Path  string `json:"path,omitempty"`  // JSON path for ExpectJSON, e.g. .items[0].status
 }
 
 // ExecutionResult holds the result of executing a command
 type ExecutionResult struct {
 	ExecutedAt time.Time `json:"executed_at"`
-	Status     string    `json:"status"`     // "success", "error", "skipped"
-	Output     string    `json:"output"`     // Captured stdout/stderr
+	Status     string    `json:"status"` // "success", "error", "timeout", "cancelled", "skipped", "denied"
+	Output     string    `json:"output"` // Captured stdout/stderr
 	ExitCode   int       `json:"exit_code"`
+	Exited     bool      `json:"exited,omitempty"` // The command ran to completion and ExitCode is its exit status
 	Error      string    `json:"error,omitempty"`
+	PolicyRule string    `json:"policy_rule,omitempty"` // Command policy rule that denied the step
+	Note       string    `json:"note,omitempty"`        // Operator's note on a manual step
+	Mismatches []string  `json:"mismatches,omitempty"`  // Expectations the result did not meet
 }
 
 // SOPExecution represents a single execution run of an SOP
 type SOPExecution struct {
-	ID            string           `json:"id"`
-	SOPName       string           `json:"sop_name"`
-	SOPPath       string           `json:"sop_path"`
-	ExecutedBy    string           `json:"executed_by"`
-	StartedAt     time.Time        `json:"started_at"`
-	EndedAt       time.Time        `json:"ended_at"`
-	Status        string           `json:"status"` // "completed", "failed", "interrupted"
-	ExecutionLog  []ExecutionStep  `json:"execution_log"`
+	ID           string            `json:"id"`
+	SOPName      string            `json:"sop_name"`
+	SOPPath      string            `json:"sop_path"`
+	ExecutedBy   string            `json:"executed_by"`
+	StartedAt    time.Time         `json:"started_at"`
+	EndedAt      time.Time         `json:"ended_at"`
+	Status       string            `json:"status"`              // "running", "completed", "failed", "interrupted", "rolled_back"
+	Variables    map[string]string `json:"variables,omitempty"` // Resolved variable values
+	Context      RunContext        `json:"context"`
+	ExecutionLog []ExecutionStep   `json:"execution_log"`
+	Rollbacks    []ExecutionStep   `json:"rollbacks,omitempty"` // Rollback blocks run after a failure, in the order they ran
+}
+
+// RunContext records who ran an SOP, where, and against which revision of it
+type RunContext struct {
+	User        string `json:"user"`                // OS username
+	RealName    string `json:"real_name,omitempty"` // Full name, where the OS knows it
+	Hostname    string `json:"hostname"`
+	WorkingDir  string `json:"working_dir"`
+	OpsyVersion string `json:"opsy_version"`
+	SOPChecksum string `json:"sop_checksum,omitempty"` // "sha256:<hex>" of the SOP file when the run started
+	GitCommit   string `json:"git_commit,omitempty"`   // Commit of the repository containing the SOP
+	GitDirty    bool   `json:"git_dirty,omitempty"`    // The SOP file has uncommitted changes
 }
 
 // ExecutionStep represents a step in the execution log
 type ExecutionStep struct {
-	StepID       int              `json:"step_id"`
-	OriginalStep Step             `json:"original_step"`
+	StepID          int              `json:"step_id"`
+	OriginalStep    Step             `json:"original_step"`
 	ExecutionResult *ExecutionResult `json:"execution_result"`
 }
 
 // LogFile represents the structure of a log file
 type LogFile struct {
-	Title       string    `json:"title"`
-	SOPRunID    string    `json:"sop_run_id"`
-	OriginalSOP string    `json:"original_sop"`
-	ExecutedBy  string    `json:"executed_by"`
-	StartedAt   time.Time `json:"started_at"`
-	EndedAt     time.Time `json:"ended_at"`
-	Status      string    `json:"status"` // completed status
-	Steps       []LogStep `json:"steps"`
+	Title       string            `json:"title"`
+	SOPRunID    string            `json:"sop_run_id"`
+	OriginalSOP string            `json:"original_sop"`
+	ExecutedBy  string            `json:"executed_by"`
+	StartedAt   time.Time         `json:"started_at"`
+	EndedAt     time.Time         `json:"ended_at"`
+	Status      string            `json:"status"` // completed status
+	Variables   map[string]string `json:"variables,omitempty"`
+	Context     RunContext        `json:"context"`
+	Steps       []LogStep         `json:"steps"`
+	Rollbacks   []LogStep         `json:"rollbacks,omitempty"`  // Rollback blocks that were run, in order
+	Deviations  []Deviation       `json:"deviations,omitempty"` // Where the run diverged from the SOP
+}
+
+// Deviation kinds
+const (
+	DeviationModified = "modified" // The command was edited before it ran
+	DeviationSkipped  = "skipped"  // The step was skipped
+)
+
+// Deviation records a step where the operator diverged from the procedure
+type Deviation struct {
+	StepID int    `json:"step_id"`
+	Title  string `json:"title"`
+	Kind   string `json:"kind"` // DeviationModified or DeviationSkipped
 }
 
 // LogStep represents a step in the log file
 type LogStep struct {
-	StepID       int              `json:"step_id"`
-	Command      string           `json:"command"`
-	ExecutedAt   time.Time        `json:"executed_at"`
-	ResultStatus string           `json:"result_status"` // success status
-	Output       string           `json:"output"`
-	OriginalStep Step             `json:"original_step"`
+	StepID          int              `json:"step_id"`
+	Command         string           `json:"command"`
+	ExecutedAt      time.Time        `json:"executed_at"`
+	ResultStatus    string           `json:"result_status"` // success status
+	Output          string           `json:"output"`
+	OriginalStep    Step             `json:"original_step"`
 	ExecutionResult *ExecutionResult `json:"execution_result"`
-}
\ No newline at end of file
+	OriginalCommand string           `json:"original_command,omitempty"` // SOP command, when Command was edited
+	Modified        bool             `json:"modified,omitempty"`
+}
@@ -0,0 +1,45 @@
+// Package version reports which build of opsy is running
+package version
+
+import "runtime/debug"
+
+// Version is set at build time, e.g.
+// go build -ldflags "-X opsy/internal/version.Version=v1.2.0"
+var Version = ""
+
+// String returns the opsy version
+// Without a version set at build time it falls back to the module version or
+// the VCS revision recorded by the Go toolchain, and finally "dev"
+func String() string {
+	if Version != "" {
+		return Version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return "dev+" + revision
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -8,38 +9,71 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 
 	"opsy/cmd"
+	"opsy/internal/config"
 	"opsy/internal/executor"
 	"opsy/internal/logger"
+	"opsy/internal/policy"
 	"opsy/internal/tui"
 )
 
+const usage = "Usage: opsy [--config FILE] [list | run [--yes] [--skip-manual] [--rollback] [--var KEY=VALUE ...] <sop.md>]"
+
 func main() {
+	configPath := flag.String("config", "", "config file (default $OPSY_CONFIG or ~/.opsy/config.yaml)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+
+	// Load configuration once and pass it everywhere
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Every step is checked against the command policy before it runs
+	commandPolicy, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Initialize executor
-	executor := executor.NewExecutor()
-	
+	executor := executor.NewExecutor(cfg)
+	executor.Policy = commandPolicy
+
 	// Initialize logger
-	logger, err := logger.NewLogger()
+	logger, err := logger.NewLogger(cfg)
 	if err != nil {
 		log.Fatal("Failed to initialize logger: ", err)
 	}
-	
+
 	// Check if a command was provided
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
+	if len(args) > 0 {
+		switch args[0] {
 		case "list":
-			cmd.ListSOPs()
+			cmd.ListSOPs(cfg)
+			return
+		case "run":
+			if err := cmd.RunSOP(args[1:], executor, logger); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
 			return
 		default:
-			fmt.Printf("Unknown command: %s\n", os.Args[1])
-			fmt.Println("Usage: opsy [list]")
+			fmt.Printf("Unknown command: %s\n", args[0])
+			fmt.Println(usage)
 			os.Exit(1)
 		}
 	}
-	
+
 	// Default: launch TUI
-	model := tui.NewModel(executor, logger)
+	model := tui.NewModel(executor, logger, cfg)
 	p := tea.NewProgram(model, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		log.Fatal("Error running program: ", err)
 	}
-}
\ No newline at end of file
+}